@@ -0,0 +1,337 @@
+package borges
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges/storage"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/framework.v0/queue"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+const (
+	// StatusFailed is the pseudo status used by the admin API to list
+	// the repositories whose last fetch failed.
+	StatusFailed = "failed"
+	// StatusStuck is the pseudo status used by the admin API to list the
+	// repositories that have been fetching for too long.
+	StatusStuck = "stuck"
+)
+
+// Admin is an HTTP handler serving a JSON API to enqueue and inspect
+// repositories. It has the following endpoints:
+//
+//   POST /repositories                 enqueue a repository by URL or ID
+//   GET  /repositories?status=<status> list repositories by status
+//   GET  /repositories/<id>            show a repository
+//   POST /repositories/<id>/requeue    set a repository as pending and enqueue it
+//
+// Besides the fetch statuses of the model, repositories can be listed with
+// the "failed" and "stuck" statuses.
+type Admin struct {
+	log      log15.Logger
+	store    storage.RepoStore
+	producer *Producer
+	mux      *http.ServeMux
+
+	// StuckTimeout is the time after which a repository that is still
+	// being fetched is considered stuck.
+	StuckTimeout time.Duration
+}
+
+// NewAdmin creates a new Admin that enqueues jobs in the given queue.
+func NewAdmin(log log15.Logger, store storage.RepoStore, q queue.Queue) *Admin {
+	a := &Admin{
+		log:          log.New("mode", "admin"),
+		store:        store,
+		producer:     NewProducer(log, nil, q),
+		mux:          http.NewServeMux(),
+		StuckTimeout: 12 * time.Hour,
+	}
+
+	a.mux.HandleFunc("/repositories", a.repositories)
+	a.mux.HandleFunc("/repositories/", a.repository)
+	return a
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// adminEnqueueRequest is the body of an enqueue request. Either ID or
+// Endpoint must be given.
+type adminEnqueueRequest struct {
+	ID       string   `json:"id"`
+	Endpoint string   `json:"endpoint"`
+	Aliases  []string `json:"aliases"`
+	IsFork   *bool    `json:"is_fork"`
+	Priority uint8    `json:"priority"`
+	Labels   []string `json:"labels"`
+}
+
+type adminRepository struct {
+	ID           string            `json:"id"`
+	Endpoints    []string          `json:"endpoints"`
+	Status       model.FetchStatus `json:"status"`
+	IsFork       *bool             `json:"is_fork,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	FetchedAt    *time.Time        `json:"fetched_at,omitempty"`
+	FetchErrorAt *time.Time        `json:"fetch_error_at,omitempty"`
+	LastCommitAt *time.Time        `json:"last_commit_at,omitempty"`
+	References   []*adminReference `json:"references,omitempty"`
+}
+
+type adminReference struct {
+	Name  string    `json:"name"`
+	Hash  string    `json:"hash"`
+	Init  string    `json:"init"`
+	Roots []string  `json:"roots"`
+	Time  time.Time `json:"time"`
+}
+
+func newAdminRepository(r *model.Repository, withRefs bool) *adminRepository {
+	result := &adminRepository{
+		ID:           uuid.UUID(r.ID).String(),
+		Endpoints:    r.Endpoints,
+		Status:       r.Status,
+		IsFork:       r.IsFork,
+		CreatedAt:    r.CreatedAt,
+		UpdatedAt:    r.UpdatedAt,
+		FetchedAt:    r.FetchedAt,
+		FetchErrorAt: r.FetchErrorAt,
+		LastCommitAt: r.LastCommitAt,
+	}
+
+	if !withRefs {
+		return result
+	}
+
+	for _, ref := range r.References {
+		var roots []string
+		for _, root := range ref.Roots {
+			roots = append(roots, root.String())
+		}
+
+		result.References = append(result.References, &adminReference{
+			Name:  ref.Name,
+			Hash:  ref.Hash.String(),
+			Init:  ref.Init.String(),
+			Roots: roots,
+			Time:  ref.Time,
+		})
+	}
+
+	return result
+}
+
+func (a *Admin) repositories(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.list(w, r)
+	case http.MethodPost:
+		a.enqueue(w, r)
+	default:
+		a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *Admin) repository(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/repositories/"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		repo, ok := a.get(w, parts[0])
+		if ok {
+			a.json(w, http.StatusOK, newAdminRepository(repo, true))
+		}
+	case len(parts) == 2 && parts[1] == "requeue" && r.Method == http.MethodPost:
+		repo, ok := a.get(w, parts[0])
+		if ok {
+			a.requeue(w, repo)
+		}
+	case len(parts) <= 2:
+		a.error(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		a.error(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *Admin) get(w http.ResponseWriter, id string) (*model.Repository, bool) {
+	ID, err := uuid.FromString(id)
+	if err != nil {
+		a.error(w, http.StatusBadRequest, "invalid repository id: "+id)
+		return nil, false
+	}
+
+	repo, err := a.store.Get(kallax.ULID(ID))
+	if err == kallax.ErrNotFound {
+		a.error(w, http.StatusNotFound, "repository not found: "+id)
+		return nil, false
+	} else if err != nil {
+		a.error(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	return repo, true
+}
+
+func (a *Admin) list(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		a.error(w, http.StatusBadRequest, "status is mandatory")
+		return
+	}
+
+	repos, err := a.byStatus(status)
+	if err != nil {
+		a.error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := make([]*adminRepository, 0, len(repos))
+	for _, repo := range repos {
+		result = append(result, newAdminRepository(repo, false))
+	}
+
+	a.json(w, http.StatusOK, result)
+}
+
+// byStatus returns the repositories with the given status. Failed
+// repositories are the ones not found, the ones requiring authentication and
+// the pending ones whose last fetch failed. Stuck repositories are the ones
+// whose fetch started more than StuckTimeout ago, as the repository is
+// updated when its status is set to fetching.
+func (a *Admin) byStatus(status string) ([]*model.Repository, error) {
+	switch status {
+	case StatusFailed:
+		var result []*model.Repository
+		for _, s := range []model.FetchStatus{model.NotFound, model.AuthRequired, model.Pending} {
+			repos, err := a.store.GetByStatus(s)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, repo := range repos {
+				if s != model.Pending || lastFetchFailed(repo) {
+					result = append(result, repo)
+				}
+			}
+		}
+
+		return result, nil
+	case StatusStuck:
+		repos, err := a.store.GetByStatus(model.Fetching)
+		if err != nil {
+			return nil, err
+		}
+
+		var result []*model.Repository
+		limit := time.Now().Add(-a.StuckTimeout)
+		for _, repo := range repos {
+			if repo.UpdatedAt.Before(limit) {
+				result = append(result, repo)
+			}
+		}
+
+		return result, nil
+	default:
+		return a.store.GetByStatus(model.FetchStatus(status))
+	}
+}
+
+func lastFetchFailed(r *model.Repository) bool {
+	if r.FetchErrorAt == nil {
+		return false
+	}
+
+	return r.FetchedAt == nil || r.FetchErrorAt.After(*r.FetchedAt)
+}
+
+func (a *Admin) enqueue(w http.ResponseWriter, r *http.Request) {
+	var req adminEnqueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Priority > uint8(queue.PriorityUrgent) {
+		a.error(w, http.StatusBadRequest, "priority must be between 0 and 8")
+		return
+	}
+
+	var ID uuid.UUID
+	switch {
+	case req.ID != "":
+		repo, ok := a.get(w, req.ID)
+		if !ok {
+			return
+		}
+
+		ID = uuid.UUID(repo.ID)
+	case req.Endpoint != "":
+		endpoints, err := jsonlEndpoints(req.Endpoint, req.Aliases)
+		if err != nil {
+			a.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ID, err = RepositoryID(endpoints, req.IsFork, a.store)
+		if err != nil {
+			a.error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		a.error(w, http.StatusBadRequest, "id or endpoint is mandatory")
+		return
+	}
+
+	a.publish(w, &Job{
+		RepositoryID: ID,
+		Priority:     queue.Priority(req.Priority),
+		Labels:       req.Labels,
+	})
+}
+
+// requeue sets the repository as pending, so it can be processed even if it
+// was stuck fetching, and enqueues it.
+func (a *Admin) requeue(w http.ResponseWriter, repo *model.Repository) {
+	if repo.Status != model.Pending {
+		if err := a.store.SetStatus(repo, model.Pending); err != nil {
+			a.error(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	a.publish(w, &Job{RepositoryID: uuid.UUID(repo.ID)})
+}
+
+func (a *Admin) publish(w http.ResponseWriter, j *Job) {
+	if err := a.producer.add(j); err != nil {
+		a.log.Error("error adding job to the queue", "job", j.RepositoryID, "error", err)
+		a.error(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	a.log.Info("job queued", "job", j.RepositoryID, "labels", j.Labels)
+	a.json(w, http.StatusAccepted, map[string]string{"id": j.RepositoryID.String()})
+}
+
+func (a *Admin) error(w http.ResponseWriter, status int, msg string) {
+	a.json(w, status, map[string]string{"error": msg})
+}
+
+func (a *Admin) json(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		a.log.Error("error writing response", "error", err)
+	}
+}
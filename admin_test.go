@@ -0,0 +1,179 @@
+package borges
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges/storage"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/framework.v0/queue"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestAdmin(t *testing.T) {
+	suite.Run(t, new(AdminSuite))
+}
+
+type AdminSuite struct {
+	suite.Suite
+	store storage.RepoStore
+	queue queue.Queue
+	iter  queue.JobIter
+	admin *Admin
+}
+
+func (s *AdminSuite) SetupTest() {
+	require := s.Require()
+
+	var err error
+	s.queue, err = queue.NewMemoryBroker().Queue("admin")
+	require.NoError(err)
+	s.iter, err = s.queue.Consume(1)
+	require.NoError(err)
+
+	s.store = storage.Local()
+	s.admin = NewAdmin(log15.New(), s.store, s.queue)
+}
+
+func (s *AdminSuite) TearDownTest() {
+	s.NoError(s.iter.Close())
+}
+
+func (s *AdminSuite) TestEnqueueEndpoint() {
+	require := s.Require()
+
+	var res map[string]string
+	s.do("POST", "/repositories", `{"endpoint": "https://foo/bar.git", "priority": 8}`, http.StatusAccepted, &res)
+
+	ID, err := uuid.FromString(res["id"])
+	require.NoError(err)
+
+	repo, err := s.store.Get(kallax.ULID(ID))
+	require.NoError(err)
+	require.Equal([]string{"https://foo/bar.git"}, repo.Endpoints)
+
+	j := s.nextJob()
+	require.Equal(ID, j.RepositoryID)
+
+	s.do("POST", "/repositories", `{"endpoint": "https://foo/bar.git"}`, http.StatusAccepted, &res)
+	require.Equal(ID.String(), res["id"])
+	s.nextJob()
+}
+
+func (s *AdminSuite) TestEnqueueID() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "https://foo/bar.git")
+
+	var res map[string]string
+	s.do("POST", "/repositories", `{"id": "`+uuid.UUID(repo.ID).String()+`"}`, http.StatusAccepted, &res)
+	require.Equal(uuid.UUID(repo.ID).String(), res["id"])
+	require.Equal(uuid.UUID(repo.ID), s.nextJob().RepositoryID)
+
+	s.do("POST", "/repositories", `{"id": "`+uuid.NewV4().String()+`"}`, http.StatusNotFound, &res)
+	s.do("POST", "/repositories", `{"id": "foo"}`, http.StatusBadRequest, &res)
+	s.do("POST", "/repositories", `{}`, http.StatusBadRequest, &res)
+	s.do("POST", "/repositories", `{"endpoint": "foo"}`, http.StatusBadRequest, &res)
+	s.do("POST", "/repositories", `{"endpoint": "https://foo/bar.git", "priority": 9}`, http.StatusBadRequest, &res)
+}
+
+func (s *AdminSuite) TestGet() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "https://foo/bar.git")
+	ID := uuid.UUID(repo.ID).String()
+
+	var res adminRepository
+	s.do("GET", "/repositories/"+ID, "", http.StatusOK, &res)
+	require.Equal(ID, res.ID)
+	require.Equal(model.Fetched, res.Status)
+	require.Equal([]string{"https://foo/bar.git"}, res.Endpoints)
+
+	var errRes map[string]string
+	s.do("GET", "/repositories/"+uuid.NewV4().String(), "", http.StatusNotFound, &errRes)
+	s.do("DELETE", "/repositories/"+ID, "", http.StatusMethodNotAllowed, &errRes)
+	s.do("GET", "/repositories/"+ID+"/foo/bar", "", http.StatusNotFound, &errRes)
+}
+
+func (s *AdminSuite) TestList() {
+	require := s.Require()
+	s.createRepo(model.Pending, "https://foo/pending.git")
+	s.createRepo(model.NotFound, "https://foo/notfound.git")
+	stuck := s.createRepo(model.Fetching, "https://foo/fetching.git")
+	stuck.UpdatedAt = time.Now().Add(-24 * time.Hour)
+	require.NoError(s.store.Create(stuck))
+
+	// a fetch that just started is not stuck, even if the repository was
+	// last updated long ago
+	started := s.createRepo(model.Pending, "https://foo/started.git")
+	started.UpdatedAt = time.Now().Add(-24 * time.Hour)
+	require.NoError(s.store.Create(started))
+	require.NoError(s.store.SetStatus(started, model.Fetching))
+
+	var res []*adminRepository
+	s.do("GET", "/repositories?status=not_found", "", http.StatusOK, &res)
+	require.Len(res, 1)
+	require.Equal([]string{"https://foo/notfound.git"}, res[0].Endpoints)
+
+	s.do("GET", "/repositories?status=failed", "", http.StatusOK, &res)
+	require.Len(res, 1)
+	require.Equal([]string{"https://foo/notfound.git"}, res[0].Endpoints)
+
+	s.do("GET", "/repositories?status=stuck", "", http.StatusOK, &res)
+	require.Len(res, 1)
+	require.Equal([]string{"https://foo/fetching.git"}, res[0].Endpoints)
+
+	var errRes map[string]string
+	s.do("GET", "/repositories", "", http.StatusBadRequest, &errRes)
+}
+
+func (s *AdminSuite) TestRequeue() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetching, "https://foo/bar.git")
+	ID := uuid.UUID(repo.ID)
+
+	var res map[string]string
+	s.do("POST", "/repositories/"+ID.String()+"/requeue", "", http.StatusAccepted, &res)
+	require.Equal(ID, s.nextJob().RepositoryID)
+
+	repo, err := s.store.Get(repo.ID)
+	require.NoError(err)
+	require.Equal(model.Pending, repo.Status)
+}
+
+func (s *AdminSuite) createRepo(status model.FetchStatus, endpoint string) *model.Repository {
+	repo := model.NewRepository()
+	repo.Status = status
+	repo.Endpoints = []string{endpoint}
+	s.Require().NoError(s.store.Create(repo))
+	return repo
+}
+
+func (s *AdminSuite) do(method, path, body string, status int, res interface{}) {
+	require := s.Require()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.admin.ServeHTTP(w, req)
+
+	require.Equal(status, w.Code, w.Body.String())
+	require.Equal("application/json", w.Header().Get("Content-Type"))
+	require.NoError(json.NewDecoder(w.Body).Decode(res))
+}
+
+func (s *AdminSuite) nextJob() *Job {
+	require := s.Require()
+
+	qj, err := s.iter.Next()
+	require.NoError(err)
+	require.NoError(qj.Ack())
+
+	var j Job
+	require.NoError(qj.Decode(&j))
+	return &j
+}
@@ -3,6 +3,7 @@ package borges
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -54,6 +55,54 @@ type Archiver struct {
 	// LockSession is a locker service to prevent concurrent access to the same
 	// rooted reporitories.
 	LockSession lock.Session
+
+	// Batcher, if it is not nil, batches the pushes to the same rooted
+	// repository of all the archivers sharing it.
+	Batcher *PushBatcher
+
+	ArchiverOptions
+}
+
+// ArchiverOptions are the optional settings of an Archiver.
+type ArchiverOptions struct {
+	// RefFilter selects the references that are archived, jobs with their
+	// own filter use it instead. References of a repository that are not
+	// selected are neither fetched nor updated, but the ones already
+	// archived are kept.
+	RefFilter *RefFilter
+	// Depth limits the history fetched to the given number of commits from
+	// the tip of each reference. If it is 0 the history is not limited.
+	Depth int
+	// Since limits the history archived to the commits after the given
+	// date. If it is zero the history is not limited.
+	//
+	// References with a limited history are not archived in the rooted
+	// repositories of their init commits, but in a rooted repository of
+	// their repository, given by ShallowInit. The history of repositories
+	// already archived with their whole history is not limited, so their
+	// references are kept in the rooted repositories of their init commits.
+	Since time.Time
+	// BatchWindow is the time the pushes of a job to a rooted repository
+	// wait for the pushes of other jobs to the same rooted repository, to do
+	// them all in a single transaction. If it is 0 they are not batched.
+	// The pushes of jobs with changes in several rooted repositories are
+	// never batched, so they are committed in two phases. It is only used
+	// by NewArchiverWorkerPool, which shares a PushBatcher between all its
+	// archivers.
+	BatchWindow time.Duration
+	// HistoryRefs is the namespace where the previous tip of a reference
+	// updated to a commit that does not descend from it, such as a force
+	// push, is kept in the rooted repository where it was archived, as
+	// <namespace>/<repository id>/<reference>/<unix time>. If it is empty,
+	// the previous tips are not kept and their history can be collected.
+	HistoryRefs string
+	// DeletedRefs is the namespace where references deleted from a
+	// repository are kept in the rooted repository where they were
+	// archived, as <namespace>/<repository id>/<reference>/<unix time>.
+	// They are also kept in the store as deleted references, until their
+	// tombstones are dropped by the garbage collection after a retention
+	// period. If it is empty, deleted references are removed.
+	DeletedRefs string
 }
 
 func NewArchiver(log log15.Logger, r storage.RepoStore,
@@ -111,6 +160,15 @@ func (a *Archiver) do(log log15.Logger, j *Job) (err error) {
 		return ErrSetStatus.Wrap(err, model.Fetching)
 	}
 
+	if err := a.recoverPendingCommit(log, r, j.ID, now); err != nil {
+		log.Error("error recovering pending commit", "error", err)
+		if err := a.Store.UpdateFailed(r, model.Pending); err != nil {
+			log15.Error("error setting repo as failed", "id", r.ID, "err", err)
+		}
+
+		return err
+	}
+
 	endpoint, err := selectEndpoint(r.Endpoints)
 	if err != nil {
 		if err := a.Store.UpdateFailed(r, model.Pending); err != nil {
@@ -121,10 +179,31 @@ func (a *Archiver) do(log log15.Logger, j *Job) (err error) {
 
 	log = log.New("endpoint", endpoint)
 
+	filter := a.RefFilter
+	if j.RefFilter != nil {
+		filter = j.RefFilter
+	}
+
+	depth, since, err := a.historyLimit(log, r)
+	if err != nil {
+		log.Error("error getting repository shallow commits", "error", err)
+		if err := a.Store.UpdateFailed(r, model.Pending); err != nil {
+			log15.Error("error setting repo as failed", "id", r.ID, "err", err)
+		}
+
+		return err
+	}
+
 	gr, err := a.TemporaryCloner.Clone(
 		ctx,
 		j.RepositoryID.String(),
-		endpoint)
+		endpoint,
+		&CloneOptions{
+			KnownReferences: a.knownReferences(r, depth > 0 || !since.IsZero()),
+			RefFilter:       filter,
+			Depth:           depth,
+			Since:           since,
+		})
 	if err != nil {
 		var finalErr error
 		if err != transport.ErrEmptyUploadPackRequest {
@@ -153,8 +232,17 @@ func (a *Archiver) do(log log15.Logger, j *Job) (err error) {
 	}()
 	log.Debug("remote repository cloned")
 
-	oldRefs := NewModelReferencer(r)
-	newRefs := gr
+	shallow, err := gr.Shallow()
+	if err != nil {
+		if err := a.Store.UpdateFailed(r, model.Pending); err != nil {
+			log15.Error("error setting repo as failed", "id", r.ID, "err", err)
+		}
+
+		return err
+	}
+
+	oldRefs := NewFilteredReferencer(NewModelReferencer(r), filter)
+	newRefs := NewShallowReferencer(NewFilteredReferencer(gr, filter), r.ID, shallow)
 	changes, err := NewChanges(oldRefs, newRefs)
 	if err != nil {
 		log.Error("error computing changes", "error", err)
@@ -166,7 +254,19 @@ func (a *Archiver) do(log log15.Logger, j *Job) (err error) {
 	}
 
 	log.Debug("changes obtained", "roots", len(changes))
-	if err := a.pushChangesToRootedRepositories(ctx, log, j, r, gr, changes, now); err != nil {
+	ffs := fastForwards(log, gr, changesCommands(changes))
+	applied, pushErr := a.pushChangesToRootedRepositories(ctx, log, r, gr, changes, ffs, now)
+	history := referenceChanges(applied, ffs, j.ID, now)
+	if err := updateAppliedReferences(a.Store, r, applied, history); err != nil {
+		log.Error("error storing references, keeping pending commit", "error", err)
+		if updateErr := a.Store.UpdateFailed(r, model.Pending); updateErr != nil {
+			log15.Error("error setting repo as failed", "id", r.ID, "err", updateErr)
+		}
+
+		return err
+	}
+
+	if err := pushErr; err != nil {
 		log.Error("repository processed with errors", "error", err)
 
 		r.FetchErrorAt = &now
@@ -174,13 +274,185 @@ func (a *Archiver) do(log log15.Logger, j *Job) (err error) {
 			return ErrSetStatus.Wrap(updateErr, model.Pending)
 		}
 
+		a.clearPendingCommit(log, r)
 		return err
 	}
 
+	if err := a.Store.UpdateFetched(r, now); err != nil {
+		return ErrSetStatus.Wrap(err, model.Fetched)
+	}
+
+	a.clearPendingCommit(log, r)
+
+	if err := a.Store.SetShallow(r, a.shallow(shallow)); err != nil {
+		log.Error("error storing repository shallow commits", "error", err)
+	}
+
+	if head, ok := gr.Head(); ok {
+		if err := a.updateHead(log, r, head, changes); err != nil {
+			log.Error("error storing repository HEAD", "head", head, "error", err)
+		}
+	}
+
 	log.Debug("repository processed")
 	return nil
 }
 
+// updateHead stores the HEAD of the repository if it is not the stored one.
+// The rooted repositories with changes already got it when the changes were
+// pushed, so it is only stored in the rest of rooted repositories of the
+// repository, where the symbolic reference is updated or removed.
+func (a *Archiver) updateHead(
+	log log15.Logger,
+	r *model.Repository,
+	head plumbing.ReferenceName,
+	changes Changes,
+) error {
+	stored, err := a.Store.Head(r.ID)
+	if err != nil {
+		return err
+	}
+
+	if stored == head.String() {
+		return nil
+	}
+
+	for _, ic := range repositoryInits(r.References) {
+		if _, ok := changes[ic]; ok {
+			continue
+		}
+
+		if err := a.storeRootedHead(log.New("root", ic.String()), r.ID, ic, head); err != nil {
+			return ErrPushToRootedRepository.Wrap(err, ic.String())
+		}
+	}
+
+	return a.Store.SetHead(r, head.String())
+}
+
+// storeRootedHead stores the HEAD of the repository with the given ID in the
+// rooted repository with the given init commit.
+func (a *Archiver) storeRootedHead(
+	log log15.Logger,
+	id kallax.ULID,
+	ic model.SHA1,
+	head plumbing.ReferenceName,
+) error {
+	t, err := a.lockRootedRepository(ic)
+	if err != nil {
+		return err
+	}
+	defer t.unlock(log)
+
+	t.tx, err = a.RootedTransactioner.Begin(plumbing.Hash(ic))
+	if err != nil {
+		return err
+	}
+
+	rr, err := git.Open(t.tx.Storer(), nil)
+	if err != nil {
+		return err
+	}
+
+	if err := StoreHead(rr, id, head); err != nil {
+		return err
+	}
+
+	return t.commit(log)
+}
+
+// repositoryInits returns the sorted init commits of the given references.
+func repositoryInits(refs []*model.Reference) []model.SHA1 {
+	seen := make(map[model.SHA1]bool)
+	var inits []model.SHA1
+	for _, ref := range refs {
+		if !seen[ref.Init] {
+			seen[ref.Init] = true
+			inits = append(inits, ref.Init)
+		}
+	}
+
+	sort.Slice(inits, func(i, j int) bool {
+		return inits[i].String() < inits[j].String()
+	})
+
+	return inits
+}
+
+// historyLimit returns the depth and the date the history of the repository
+// is limited to. The history of a repository already archived with its whole
+// history is not limited: its references would get the init commit given by
+// ShallowInit, and be deleted from the rooted repositories of their init
+// commits along with the history archived there.
+func (a *Archiver) historyLimit(
+	log log15.Logger,
+	r *model.Repository,
+) (int, time.Time, error) {
+	if a.Depth == 0 && a.Since.IsZero() {
+		return 0, time.Time{}, nil
+	}
+
+	init := ShallowInit(r.ID)
+	var whole bool
+	for _, ref := range r.References {
+		if ref.Init != init {
+			whole = true
+			break
+		}
+	}
+
+	if !whole {
+		return a.Depth, a.Since, nil
+	}
+
+	shallow, err := a.Store.Shallow(r.ID)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	if shallow != nil {
+		return a.Depth, a.Since, nil
+	}
+
+	log.Warn("repository archived with its whole history, its history is not limited")
+	return 0, time.Time{}, nil
+}
+
+// knownReferences returns the references of the repository whose roots can
+// be reused to find the roots of the new references. Roots are not reused if
+// the history is limited, or if the history of the references was limited,
+// as roots are shallow commits then.
+func (a *Archiver) knownReferences(r *model.Repository, limited bool) []*model.Reference {
+	if limited {
+		return nil
+	}
+
+	init := ShallowInit(r.ID)
+	var known []*model.Reference
+	for _, ref := range r.References {
+		if ref.Init != init {
+			known = append(known, ref)
+		}
+	}
+
+	return known
+}
+
+// shallow returns the description of the limited history of a repository
+// with the given shallow commits, or nil if there are none.
+func (a *Archiver) shallow(commits []plumbing.Hash) *storage.Shallow {
+	if len(commits) == 0 {
+		return nil
+	}
+
+	s := &storage.Shallow{Depth: a.Depth, Since: a.Since}
+	for _, h := range commits {
+		s.Commits = append(s.Commits, model.SHA1(h))
+	}
+
+	return s
+}
+
 func (a *Archiver) canProcessRepository(repo *model.Repository) error {
 	if repo.Status == model.Fetching {
 		return ErrAlreadyFetching.New(repo.ID)
@@ -216,101 +488,373 @@ func selectEndpoint(endpoints []string) (string, error) {
 	return endpoints[0], nil
 }
 
+// pushChangesToRootedRepositories pushes the changes of the repository to
+// their rooted repositories and applies to its references the changes that
+// are committed, without updating it in the store. It returns the commands
+// that were committed. The references they delete that are kept in
+// tombstones are stored before, so no tombstone is committed without them,
+// and the ones of the rooted repositories that fail are removed after. The
+// previous tips of the updates that are not fast-forwards,
+// as given by fastForwards, are kept in history references. The init commits
+// of the rooted repositories are recorded as a pending commit before, so
+// that the references can be recovered if the job is interrupted before the
+// repository is updated.
+//
+// The transactions of all the rooted repositories are prepared first and
+// then committed, so either all the changes are committed or none, unless a
+// commit fails. Only the changes of repositories with a single rooted
+// repository are batched, so this holds for batched pushes as well.
 func (a *Archiver) pushChangesToRootedRepositories(ctx context.Context, ctxLog log15.Logger,
-	j *Job, r *model.Repository, tr TemporaryRepository, changes Changes,
-	now time.Time) error {
+	r *model.Repository, tr TemporaryRepository, changes Changes,
+	fastForwards map[string]bool, now time.Time,
+) ([]*Command, error) {
+
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	inits := sortedInits(changes)
+	if err := a.Store.SetPendingCommit(r, inits); err != nil {
+		return nil, err
+	}
+
+	var history map[model.SHA1][]*plumbing.Reference
+	if a.HistoryRefs != "" {
+		history = historyReferences(a.HistoryRefs, r.ID, changes, fastForwards, now)
+	}
+
+	deleted := make(map[model.SHA1][]*storage.DeletedReference)
+	if a.DeletedRefs != "" {
+		tombstones := deletedReferences(a.DeletedRefs, r.ID, changes, now)
+		if err := a.Store.AddDeletedReferences(r, tombstones); err != nil {
+			return nil, err
+		}
+
+		for _, ref := range tombstones {
+			deleted[ref.Init] = append(deleted[ref.Init], ref)
+		}
+	}
+
+	pushes := make(map[model.SHA1]*rootedPush, len(inits))
+	for _, ic := range inits {
+		pushes[ic] = &rootedPush{
+			ctx:     ctx,
+			log:     ctxLog.New("root", ic.String()),
+			r:       r,
+			tr:      tr,
+			changes: changes[ic],
+			history: history[ic],
+			deleted: deleted[ic],
+		}
+	}
+
+	if a.Batcher != nil && len(inits) == 1 {
+		ic := inits[0]
+		a.Batcher.do(ic, pushes[ic], func(ps []*rootedPush) {
+			a.pushBatchToRootedRepository(ic, ps)
+		})
+	} else {
+		a.pushToRootedRepositories(ctxLog, inits, pushes)
+	}
 
 	var failedInits []model.SHA1
-	for ic, cs := range changes {
-		log := ctxLog.New("root", ic.String())
-		lock := a.LockSession.NewLocker(fmt.Sprintf("borges/%s", ic.String()))
-		ch, err := lock.Lock()
-		if err != nil {
+	var applied []*Command
+	var dropped []*storage.DeletedReference
+	for _, ic := range inits {
+		if pushes[ic].err != nil {
 			failedInits = append(failedInits, ic)
-			log.Warn("failed to acquire lock", "root", ic.String(), "error", err)
+			dropped = append(dropped, deleted[ic]...)
 			continue
 		}
 
-		log.Debug("push changes to rooted repository started")
-		if err := a.pushChangesToRootedRepository(ctx, log, r, tr, ic, cs); err != nil {
+		r.References = updateRepositoryReferences(r.References, changes[ic], ic)
+		applied = append(applied, changes[ic]...)
+	}
+
+	// a deleted reference left without tombstone is harmless, gc drops it
+	// once it expires
+	if len(dropped) > 0 {
+		if err := a.Store.RemoveDeletedReferences(dropped); err != nil {
+			ctxLog.Error("error removing deleted references", "error", err)
+		}
+	}
+
+	return applied, checkFailedInits(changes, failedInits)
+}
+
+// sortedInits returns the init commits of the changes sorted, which is the
+// order their rooted repositories are locked in.
+func sortedInits(changes Changes) []model.SHA1 {
+	inits := make([]model.SHA1, 0, len(changes))
+	for ic := range changes {
+		inits = append(inits, ic)
+	}
+
+	sort.Slice(inits, func(i, j int) bool {
+		return inits[i].String() < inits[j].String()
+	})
+
+	return inits
+}
+
+// pushToRootedRepositories pushes the changes of a repository to all their
+// rooted repositories in two phases. The transactions of all the rooted
+// repositories are prepared, holding their locks, and they are only committed
+// if all of them succeed. Otherwise, all the pushes get the error.
+func (a *Archiver) pushToRootedRepositories(
+	log log15.Logger,
+	inits []model.SHA1,
+	pushes map[model.SHA1]*rootedPush,
+) {
+	var prepared []*rootedTx
+	defer func() {
+		for _, t := range prepared {
+			t.unlock(pushes[t.ic].log)
+		}
+	}()
+
+	for _, ic := range inits {
+		p := pushes[ic]
+		t, err := a.lockRootedRepository(ic)
+		if err == nil {
+			prepared = append(prepared, t)
+			p.log.Debug("push changes to rooted repository started")
+			_, err = t.prepare(a, p.log, []*rootedPush{p})
+		}
+
+		if err != nil {
 			err = ErrPushToRootedRepository.Wrap(err, ic.String())
-			log.Error("error pushing changes to rooted repository", "error", err)
-			failedInits = append(failedInits, ic)
-			if err := lock.Unlock(); err != nil {
-				log.Warn("failed to release lock", "root", ic.String(), "error", err)
+			p.log.Error("error pushing changes to rooted repository", "error", err)
+			for _, t := range prepared {
+				t.rollback()
 			}
 
-			continue
+			for _, p := range pushes {
+				p.err = err
+			}
+
+			return
 		}
-		log.Debug("push changes to rooted repository finished")
 
-		log.Debug("update repository references started")
-		r.References = updateRepositoryReferences(r.References, cs, ic)
-		if err := a.Store.UpdateFetched(r, now); err != nil {
-			err = ErrPushToRootedRepository.Wrap(err, ic.String())
-			log.Error("error updating repository in database", "error", err)
-			failedInits = append(failedInits, ic)
+		p.log.Debug("push changes to rooted repository finished")
+	}
+
+	for _, t := range prepared {
+		p := pushes[t.ic]
+		if err := t.commit(p.log); err != nil {
+			p.err = ErrPushToRootedRepository.Wrap(err, t.ic.String())
+			p.log.Error("error committing changes to rooted repository", "error", p.err)
 		}
-		log.Debug("update repository references finished")
+	}
+}
 
-		select {
-		case <-ch:
-			log.Error("lost the lock", "root", ic.String())
-		default:
+// pushBatchToRootedRepository pushes the changes of all the given pushes to
+// the rooted repository with the given init commit in a single transaction,
+// setting the error of the pushes that fail. If a push fails, the
+// transaction is rolled back and retried without it.
+func (a *Archiver) pushBatchToRootedRepository(ic model.SHA1, pushes []*rootedPush) {
+	log := a.log.New("root", ic.String(), "repositories", len(pushes))
+	t, err := a.lockRootedRepository(ic)
+	if err != nil {
+		for _, p := range pushes {
+			p.err = err
+		}
+
+		return
+	}
+	defer t.unlock(log)
+
+	pending := pushes
+	for len(pending) > 0 {
+		log.Debug("push changes to rooted repository started")
+		failed, err := t.prepare(a, log, pending)
+		if err == nil {
+			err = t.commit(log)
 		}
 
-		if err := lock.Unlock(); err != nil {
-			log.Warn("failed to release lock", "root", ic.String(), "error", err)
+		if err == nil {
+			log.Debug("push changes to rooted repository finished")
+			return
 		}
+
+		err = ErrPushToRootedRepository.Wrap(err, ic.String())
+		if failed == nil {
+			log.Error("error pushing changes to rooted repository", "error", err)
+			for _, p := range pending {
+				p.err = err
+			}
+
+			return
+		}
+
+		failed.log.Error("error pushing changes to rooted repository", "error", err)
+		failed.err = err
+		pending = withoutPush(pending, failed)
 	}
+}
 
-	if len(changes) == 0 {
-		if err := a.Store.UpdateFetched(r, now); err != nil {
-			ctxLog.Error("error updating repository in databbase", "error", err)
+func withoutPush(pushes []*rootedPush, p *rootedPush) []*rootedPush {
+	var result []*rootedPush
+	for _, other := range pushes {
+		if other != p {
+			result = append(result, other)
 		}
 	}
 
-	return checkFailedInits(changes, failedInits)
+	return result
+}
+
+// rootedTx is a transaction of a locked rooted repository.
+type rootedTx struct {
+	ic     model.SHA1
+	locker lock.Locker
+	lost   <-chan struct{}
+	tx     repository.Tx
 }
 
-func (a *Archiver) pushChangesToRootedRepository(ctx context.Context, log log15.Logger, r *model.Repository, tr TemporaryRepository, ic model.SHA1, changes []*Command) error {
+func (a *Archiver) lockRootedRepository(ic model.SHA1) (*rootedTx, error) {
+	locker := a.LockSession.NewLocker(fmt.Sprintf("borges/%s", ic.String()))
+	ch, err := locker.Lock()
+	if err != nil {
+		a.log.Warn("failed to acquire lock", "root", ic.String(), "error", err)
+		return nil, err
+	}
+
+	return &rootedTx{ic: ic, locker: locker, lost: ch}, nil
+}
+
+// prepare pushes the changes of the given pushes to the rooted repository in
+// a new transaction, which is not committed. If one of them fails, the
+// transaction is rolled back and it is returned with the error. If the
+// transaction fails, only the error is returned.
+func (t *rootedTx) prepare(a *Archiver, log log15.Logger, pushes []*rootedPush) (*rootedPush, error) {
 	var rootedRepoCpStart = time.Now()
-	tx, err := a.RootedTransactioner.Begin(plumbing.Hash(ic))
+	tx, err := a.RootedTransactioner.Begin(plumbing.Hash(t.ic))
 	sivaCpFromDuration := time.Now().Sub(rootedRepoCpStart)
-	log.Debug("Copy siva file from HDFS", "RootedRepository", ic, "copyFromRemote", int64(sivaCpFromDuration/time.Second))
+	log.Debug("Copy siva file from remote", "RootedRepository", t.ic, "copyFromRemote", int64(sivaCpFromDuration/time.Second))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	t.tx = tx
 	rr, err := git.Open(tx.Storer(), nil)
 	if err != nil {
-		_ = tx.Rollback()
+		t.rollback()
+		return nil, err
+	}
+
+	var failed *rootedPush
+	err = WithInProcRepository(rr, func(url string) error {
+		for _, p := range pushes {
+			if err := a.push(rr, url, p); err != nil {
+				failed = p
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.rollback()
+	}
+
+	return failed, err
+}
+
+// commit commits the prepared transaction, unless the lock was lost.
+func (t *rootedTx) commit(log log15.Logger) error {
+	select {
+	case <-t.lost:
+		log.Error("lost the lock", "root", t.ic.String())
+		t.rollback()
+		return ErrLockLost.New(t.ic.String())
+	default:
+	}
+
+	var rootedRepoCpStart = time.Now()
+	err := t.tx.Commit()
+	t.tx = nil
+	sivaCpToDuration := time.Now().Sub(rootedRepoCpStart)
+	log.Debug("Copy siva file to remote", "RootedRepository", t.ic, "copyToRemote", int64(sivaCpToDuration/time.Second))
+	return err
+}
+
+func (t *rootedTx) rollback() {
+	if t.tx != nil {
+		_ = t.tx.Rollback()
+		t.tx = nil
+	}
+}
+
+func (t *rootedTx) unlock(log log15.Logger) {
+	t.rollback()
+	if err := t.locker.Unlock(); err != nil {
+		log.Warn("failed to release lock", "root", t.ic.String(), "error", err)
+	}
+}
+
+// push pushes the changes of a repository to the rooted repository, served
+// at the given URL.
+func (a *Archiver) push(rr *git.Repository, url string, p *rootedPush) error {
+	if err := StoreConfig(rr, p.r); err != nil {
 		return err
 	}
 
-	return WithInProcRepository(rr, func(url string) error {
-		if err := StoreConfig(rr, r); err != nil {
-			_ = tx.Rollback()
+	for _, ref := range p.history {
+		if err := rr.Storer.SetReference(ref); err != nil {
 			return err
 		}
+	}
 
-		refspecs := a.changesToPushRefSpec(r.ID, changes)
-		pushStart := time.Now()
-		if err := tr.Push(ctx, url, refspecs); err != nil {
-			onlyPushDurationSec := int64(time.Now().Sub(pushStart) / time.Second)
-			log.Error("error pushing 1 change for", "refs", refspecs, "error", err, "took", onlyPushDurationSec)
-			_ = tx.Rollback()
+	for _, ref := range p.deleted {
+		tombstone := plumbing.NewHashReference(
+			plumbing.ReferenceName(ref.Tombstone),
+			plumbing.Hash(ref.Hash),
+		)
+		if err := rr.Storer.SetReference(tombstone); err != nil {
 			return err
 		}
+	}
+
+	refspecs := a.changesToPushRefSpec(p.r.ID, p.changes)
+	pushStart := time.Now()
+	if err := pushToRootedRepository(p.ctx, p.tr, url, rr, refspecs); err != nil {
 		onlyPushDurationSec := int64(time.Now().Sub(pushStart) / time.Second)
-		log.Debug("1 change pushed", "took", onlyPushDurationSec)
+		p.log.Error("error pushing 1 change for", "refs", refspecs, "error", err, "took", onlyPushDurationSec)
+		return err
+	}
+	onlyPushDurationSec := int64(time.Now().Sub(pushStart) / time.Second)
+	p.log.Debug("1 change pushed", "took", onlyPushDurationSec)
 
-		var rootedRepoCpStart = time.Now()
-		err = tx.Commit()
-		sivaCpToDuration := time.Now().Sub(rootedRepoCpStart)
-		log.Debug("Copy siva file to HDFS", "RootedRepository", ic, "copyToRemote", int64(sivaCpToDuration/time.Second))
+	head, ok := p.tr.Head()
+	if !ok {
+		return nil
+	}
+
+	return StoreHead(rr, p.r.ID, head)
+}
+
+// pushToRootedRepository pushes the references of the temporary repository
+// to the rooted repository. Shallow repositories cannot be pushed, so their
+// objects are copied to the storage of the rooted repository instead.
+func pushToRootedRepository(
+	ctx context.Context,
+	tr TemporaryRepository,
+	url string,
+	rr *git.Repository,
+	refspecs []config.RefSpec,
+) error {
+	shallow, err := tr.Shallow()
+	if err != nil {
 		return err
-	})
+	}
+
+	if len(shallow) > 0 {
+		return tr.CopyTo(rr.Storer, refspecs)
+	}
+
+	return tr.Push(ctx, url, refspecs)
 }
 
 func (a *Archiver) changesToPushRefSpec(id kallax.ULID, changes []*Command) []config.RefSpec {
@@ -389,14 +933,25 @@ func checkFailedInits(changes Changes, failed []model.SHA1) error {
 
 // NewArchiverWorkerPool creates a new WorkerPool that uses an Archiver to
 // process jobs. It takes optional start, stop and warn notifier functions that
-// are equal to the Archiver notifiers but with additional WorkerContext.
+// are equal to the Archiver notifiers but with additional WorkerContext. The
+// given options, which can be nil, are used by all the archivers.
 func NewArchiverWorkerPool(
 	log log15.Logger,
 	r storage.RepoStore,
 	tx repository.RootedTransactioner,
 	tc TemporaryCloner,
 	ls lock.Service,
-	to time.Duration) *WorkerPool {
+	to time.Duration,
+	opts *ArchiverOptions) *WorkerPool {
+
+	if opts == nil {
+		opts = &ArchiverOptions{}
+	}
+
+	var batcher *PushBatcher
+	if opts.BatchWindow > 0 {
+		batcher = NewPushBatcher(opts.BatchWindow)
+	}
 
 	do := func(log log15.Logger, j *Job) error {
 		lsess, err := ls.NewSession(&lock.SessionConfig{TTL: 10 * time.Second})
@@ -405,6 +960,8 @@ func NewArchiverWorkerPool(
 		}
 
 		a := NewArchiver(log, r, tx, tc, lsess, to)
+		a.ArchiverOptions = *opts
+		a.Batcher = batcher
 		return a.Do(j)
 	}
 
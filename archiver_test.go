@@ -23,6 +23,8 @@ import (
 	"gopkg.in/src-d/go-billy.v3"
 	"gopkg.in/src-d/go-billy.v3/osfs"
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 	"gopkg.in/src-d/go-git.v4/storage/memory"
 	"gopkg.in/src-d/go-kallax.v1"
 )
@@ -52,6 +54,7 @@ func (s *ArchiverSuite) SetupTest() {
 
 	s.rawStore = model.NewRepositoryStore(s.DB)
 	s.store = storage.FromDatabase(s.DB)
+	s.NoError(storage.CreateSchema(s.DB))
 
 	var err error
 	s.tmpPath, err = ioutil.TempDir(os.TempDir(),
@@ -80,6 +83,7 @@ func (s *ArchiverSuite) SetupTest() {
 func (s *ArchiverSuite) TearDownTest() {
 	s.NoError(os.RemoveAll(s.tmpPath))
 
+	s.NoError(storage.DropSchema(s.DB))
 	s.Suite.TearDown()
 	fixtures.Clean()
 }
@@ -161,7 +165,7 @@ func (s *ArchiverSuite) TestFixtures() {
 			checkReferences(t, nr, ct.NewReferences)
 
 			// check references in database
-			mr, err := s.rawStore.FindOne(model.NewRepositoryQuery().FindByID(rid))
+			mr, err := s.store.Get(rid)
 			require.NoError(err)
 			checkReferencesInDB(t, mr, ct.NewReferences)
 
@@ -185,6 +189,237 @@ func (s *ArchiverSuite) TestFixtures() {
 	}
 }
 
+func (s *ArchiverSuite) TestHead() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, nil)
+	require.NoError(err)
+
+	var rid kallax.ULID
+	err = WithInProcRepository(r, func(url string) error {
+		rid = s.newRepositoryModel(url)
+		return s.a.Do(&Job{RepositoryID: uuid.UUID(rid)})
+	})
+	require.NoError(err)
+
+	head, err := s.store.Head(rid)
+	require.NoError(err)
+	require.Equal(plumbing.Master.String(), head)
+
+	master, err := r.Reference(plumbing.Master, false)
+	require.NoError(err)
+
+	mr, err := s.store.Get(rid)
+	require.NoError(err)
+	for _, ref := range mr.References {
+		require.NotEqual("refs/heads/HEAD", ref.Name)
+	}
+
+	init := refsByName(mr.References)[plumbing.Master.String()].Init
+	tx, err := s.tx.Begin(plumbing.Hash(init))
+	require.NoError(err)
+	defer func() { require.NoError(tx.Rollback()) }()
+
+	rr, err := git.Open(tx.Storer(), nil)
+	require.NoError(err)
+
+	ref, err := rr.Reference(plumbing.ReferenceName(
+		fmt.Sprintf("refs/remotes/%s/HEAD", rid)), true)
+	require.NoError(err)
+	require.Equal(master.Hash(), ref.Hash())
+}
+
+func (s *ArchiverSuite) TestHead_Changed() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, nil)
+	require.NoError(err)
+
+	branch := plumbing.ReferenceName("refs/heads/branch")
+	master, err := r.Reference(plumbing.Master, false)
+	require.NoError(err)
+
+	var rid kallax.ULID
+	do := func(head *plumbing.Reference) {
+		require.NoError(sto.SetReference(head))
+		err := WithInProcRepository(r, func(url string) error {
+			if rid.IsEmpty() {
+				rid = s.newRepositoryModel(url)
+			}
+
+			return s.a.Do(&Job{RepositoryID: uuid.UUID(rid)})
+		})
+		require.NoError(err)
+	}
+
+	rootedHead := func() (*plumbing.Reference, error) {
+		mr, err := s.store.Get(rid)
+		require.NoError(err)
+
+		init := refsByName(mr.References)[plumbing.Master.String()].Init
+		tx, err := s.tx.Begin(plumbing.Hash(init))
+		require.NoError(err)
+		defer func() { require.NoError(tx.Rollback()) }()
+
+		return tx.Storer().Reference(plumbing.ReferenceName(
+			fmt.Sprintf("refs/remotes/%s/HEAD", rid)))
+	}
+
+	do(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master))
+
+	// HEAD changes without changes in the references
+	do(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+
+	head, err := s.store.Head(rid)
+	require.NoError(err)
+	require.Equal(branch.String(), head)
+
+	ref, err := rootedHead()
+	require.NoError(err)
+	require.Equal(plumbing.ReferenceName(fmt.Sprintf("%s/%s", branch, rid)), ref.Target())
+
+	// detached HEAD
+	do(plumbing.NewHashReference(plumbing.HEAD, master.Hash()))
+
+	head, err = s.store.Head(rid)
+	require.NoError(err)
+	require.Equal("", head)
+
+	_, err = rootedHead()
+	require.Equal(plumbing.ErrReferenceNotFound, err)
+}
+
+func (s *ArchiverSuite) TestRefFilter() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, nil)
+	require.NoError(err)
+
+	s.a.RefFilter = &RefFilter{Exclude: []string{"refs/tags/*"}}
+	defer func() { s.a.RefFilter = nil }()
+
+	var rid kallax.ULID
+	err = WithInProcRepository(r, func(url string) error {
+		rid = s.newRepositoryModel(url)
+		if err := s.a.Do(&Job{RepositoryID: uuid.UUID(rid)}); err != nil {
+			return err
+		}
+
+		mr, err := s.store.Get(rid)
+		require.NoError(err)
+		require.Len(mr.References, 2)
+		_, ok := refsByName(mr.References)["refs/tags/v1.0.0"]
+		require.False(ok)
+
+		// the filter of the job replaces the one of the archiver, the
+		// references that are not selected are kept
+		return s.a.Do(&Job{
+			RepositoryID: uuid.UUID(rid),
+			RefFilter:    &RefFilter{Include: []string{"refs/tags/*"}},
+		})
+	})
+	require.NoError(err)
+
+	mr, err := s.store.Get(rid)
+	require.NoError(err)
+	require.Len(mr.References, 3)
+	_, ok := refsByName(mr.References)["refs/tags/v1.0.0"]
+	require.True(ok)
+}
+
+func (s *ArchiverSuite) TestShallow() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, nil)
+	require.NoError(err)
+
+	master, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	require.NoError(err)
+
+	s.a.Since = master.Committer.When
+	defer func() { s.a.Since = time.Time{} }()
+
+	var rid kallax.ULID
+	err = WithInProcRepository(r, func(url string) error {
+		rid = s.newRepositoryModel(url)
+		return s.a.Do(&Job{RepositoryID: uuid.UUID(rid)})
+	})
+	require.NoError(err)
+
+	mr, err := s.store.Get(rid)
+	require.NoError(err)
+	require.NotEmpty(mr.References)
+	for _, ref := range mr.References {
+		require.Equal(ShallowInit(rid), ref.Init)
+	}
+
+	shallow, err := s.store.Shallow(rid)
+	require.NoError(err)
+	require.NotNil(shallow)
+	require.Equal(master.Committer.When, shallow.Since)
+	require.Contains(shallow.Commits, model.SHA1(master.Hash))
+
+	_, err = s.rootedFs.Stat(ShallowInit(rid).String() + ".siva")
+	require.NoError(err)
+}
+
+func (s *ArchiverSuite) TestShallow_WholeHistory() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, nil)
+	require.NoError(err)
+
+	master, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	require.NoError(err)
+
+	var rid kallax.ULID
+	err = WithInProcRepository(r, func(url string) error {
+		rid = s.newRepositoryModel(url)
+		if err := s.a.Do(&Job{RepositoryID: uuid.UUID(rid)}); err != nil {
+			return err
+		}
+
+		// the repository is archived with its whole history, so its
+		// history is not limited afterwards
+		s.a.Since = master.Committer.When
+		defer func() { s.a.Since = time.Time{} }()
+		return s.a.Do(&Job{RepositoryID: uuid.UUID(rid)})
+	})
+	require.NoError(err)
+
+	mr, err := s.store.Get(rid)
+	require.NoError(err)
+	require.NotEmpty(mr.References)
+	for _, ref := range mr.References {
+		require.NotEqual(ShallowInit(rid), ref.Init)
+	}
+
+	shallow, err := s.store.Shallow(rid)
+	require.NoError(err)
+	require.Nil(shallow)
+
+	_, err = s.rootedFs.Stat(ShallowInit(rid).String() + ".siva")
+	require.True(os.IsNotExist(err))
+
+	_, err = s.rootedFs.Stat(mr.References[0].Init.String() + ".siva")
+	require.NoError(err)
+}
+
 func (s *ArchiverSuite) TestNotExistingRepository() {
 	rid := s.newRepositoryModel("file:///this/repository/does/not/exists")
 	err := s.a.Do(&Job{RepositoryID: uuid.UUID(rid)})
@@ -0,0 +1,114 @@
+package borges
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/src-d/borges/storage"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// PushBatcher coalesces the pushes of several jobs to the same rooted
+// repository, so they are all done in a single transaction, instead of
+// copying the rooted repository once per job. It can be shared by any number
+// of archivers.
+//
+// The first push to a rooted repository starts a batch, which waits for the
+// pushes of other jobs during the batching window, and then it is processed
+// by the job that started it, while the others wait for it.
+type PushBatcher struct {
+	window time.Duration
+
+	mut     sync.Mutex
+	batches map[model.SHA1]*pushBatch
+}
+
+type pushBatch struct {
+	pushes []*rootedPush
+	done   chan struct{}
+}
+
+// rootedPush is the push of the changes of a repository to a rooted
+// repository, and its result.
+type rootedPush struct {
+	ctx     context.Context
+	log     log15.Logger
+	r       *model.Repository
+	tr      TemporaryRepository
+	changes []*Command
+	// history are the references keeping the previous tips of the forced
+	// updates of the changes.
+	history []*plumbing.Reference
+	// deleted are the references deleted by the changes that are kept in
+	// tombstones.
+	deleted []*storage.DeletedReference
+
+	err error
+}
+
+// NewPushBatcher returns a PushBatcher with the given batching window.
+func NewPushBatcher(window time.Duration) *PushBatcher {
+	return &PushBatcher{
+		window:  window,
+		batches: make(map[model.SHA1]*pushBatch),
+	}
+}
+
+// do adds the push to the pending batch of the rooted repository with the
+// given init commit, starting one if there is none, and returns once the
+// batch is processed with the given function. If the context of the push is
+// cancelled before the batch is processed, it is withdrawn from it with the
+// error of the context.
+func (b *PushBatcher) do(ic model.SHA1, p *rootedPush, process func([]*rootedPush)) {
+	b.mut.Lock()
+	if batch, ok := b.batches[ic]; ok {
+		batch.pushes = append(batch.pushes, p)
+		b.mut.Unlock()
+		select {
+		case <-batch.done:
+		case <-p.ctx.Done():
+			b.cancel(ic, batch, p)
+		}
+
+		return
+	}
+
+	batch := &pushBatch{
+		pushes: []*rootedPush{p},
+		done:   make(chan struct{}),
+	}
+
+	b.batches[ic] = batch
+	b.mut.Unlock()
+
+	select {
+	case <-time.After(b.window):
+	case <-p.ctx.Done():
+	}
+
+	b.mut.Lock()
+	delete(b.batches, ic)
+	b.mut.Unlock()
+
+	defer close(batch.done)
+	process(batch.pushes)
+}
+
+// cancel withdraws the push from the batch, unless it is already being
+// processed, in which case it waits for it. The push to the rooted repository
+// uses the cancelled context, so it fails as well.
+func (b *PushBatcher) cancel(ic model.SHA1, batch *pushBatch, p *rootedPush) {
+	b.mut.Lock()
+	if b.batches[ic] != batch {
+		b.mut.Unlock()
+		<-batch.done
+		return
+	}
+
+	batch.pushes = withoutPush(batch.pushes, p)
+	b.mut.Unlock()
+	p.err = ErrPushToRootedRepository.Wrap(p.ctx.Err(), ic.String())
+}
@@ -0,0 +1,89 @@
+package borges
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+)
+
+func TestPushBatcher(t *testing.T) {
+	require := require.New(t)
+	b := NewPushBatcher(50 * time.Millisecond)
+
+	var mut sync.Mutex
+	var batches [][]*rootedPush
+	process := func(ps []*rootedPush) {
+		mut.Lock()
+		defer mut.Unlock()
+		batches = append(batches, ps)
+	}
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	other := model.NewSHA1("0000000000000000000000000000000000000002")
+	inits := []model.SHA1{a, a, a, other}
+
+	var wg sync.WaitGroup
+	for _, ic := range inits {
+		wg.Add(1)
+		go func(ic model.SHA1) {
+			defer wg.Done()
+			b.do(ic, &rootedPush{ctx: context.Background()}, process)
+		}(ic)
+	}
+
+	wg.Wait()
+	require.Len(batches, 2)
+	require.ElementsMatch([]int{3, 1}, []int{len(batches[0]), len(batches[1])})
+
+	b.do(a, &rootedPush{ctx: context.Background()}, process)
+	require.Len(batches, 3)
+	require.Len(batches[2], 1)
+}
+
+func TestPushBatcher_Cancel(t *testing.T) {
+	require := require.New(t)
+	b := NewPushBatcher(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var processed bool
+	b.do(model.SHA1{}, &rootedPush{ctx: ctx}, func(ps []*rootedPush) {
+		processed = true
+	})
+
+	require.True(processed)
+}
+
+func TestPushBatcher_CancelFollower(t *testing.T) {
+	require := require.New(t)
+	b := NewPushBatcher(100 * time.Millisecond)
+
+	var processed []*rootedPush
+	process := func(ps []*rootedPush) {
+		processed = ps
+	}
+
+	leader := &rootedPush{ctx: context.Background()}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.do(model.SHA1{}, leader, process)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	follower := &rootedPush{ctx: ctx}
+	b.do(model.SHA1{}, follower, process)
+	require.Error(follower.err)
+	require.True(ErrPushToRootedRepository.Is(follower.err))
+
+	<-done
+	require.Equal([]*rootedPush{leader}, processed)
+}
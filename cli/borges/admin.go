@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+)
+
+const (
+	adminCmdName      = "admin"
+	adminCmdShortDesc = "serve an HTTP API to enqueue and inspect repositories"
+	adminCmdLongDesc  = ""
+)
+
+type adminCmd struct {
+	cmd
+	Addr         string `long:"addr" default:"0.0.0.0:8090" description:"address the admin API listens on"`
+	StuckTimeout string `long:"stuck-timeout" default:"12h" description:"time after which a repository being fetched is considered stuck"`
+}
+
+func (c *adminCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+	c.startProfilingHTTPServerMaybe(c.ProfilerPort + 2)
+
+	stuckTimeout, err := time.ParseDuration(c.StuckTimeout)
+	if err != nil {
+		return err
+	}
+
+	b := core.Broker()
+	defer b.Close()
+	q, err := b.Queue(c.Queue)
+	if err != nil {
+		return err
+	}
+
+	admin := borges.NewAdmin(log, storage.FromDatabase(core.Database()), q)
+	admin.StuckTimeout = stuckTimeout
+
+	log.Info("admin API listening", "address", c.Addr)
+	return http.ListenAndServe(c.Addr, admin)
+}
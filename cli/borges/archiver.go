@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/src-d/borges"
+)
+
+type archiverCmd struct {
+	IncludeRefs  []string `long:"include-refs" description:"pattern of the names of the references to archive, where * matches any sequence of characters, can be given several times"`
+	ExcludeRefs  []string `long:"exclude-refs" description:"pattern of the names of the references not to archive, such as refs/pull/*, can be given several times"`
+	Depth        int      `long:"depth" default:"0" description:"number of commits fetched from the tip of each reference, 0 fetches the whole history"`
+	ShallowSince string   `long:"shallow-since" description:"date (YYYY-MM-DD) of the oldest commits archived, by default the whole history is archived"`
+	HistoryRefs  string   `long:"history-refs" default:"refs/borges/history" description:"namespace where the previous tips of force pushed references are kept in the rooted repositories, empty to not keep them"`
+	DeletedRefs  string   `long:"deleted-refs" description:"namespace where deleted references are kept in the rooted repositories, such as refs/borges/deleted, by default they are removed"`
+}
+
+func (c *archiverCmd) archiverOptions() (*borges.ArchiverOptions, error) {
+	if c.Depth < 0 {
+		return nil, fmt.Errorf("invalid `--depth` flag: it cannot be negative")
+	}
+
+	if !validNamespace(c.HistoryRefs) {
+		return nil, fmt.Errorf("invalid `--history-refs` flag: it must start with refs/ and not end with /")
+	}
+
+	if !validNamespace(c.DeletedRefs) {
+		return nil, fmt.Errorf("invalid `--deleted-refs` flag: it must start with refs/ and not end with /")
+	}
+
+	if c.DeletedRefs != "" && c.DeletedRefs == c.HistoryRefs {
+		return nil, fmt.Errorf("invalid `--deleted-refs` flag: it must be different from `--history-refs`")
+	}
+
+	opts := &borges.ArchiverOptions{
+		Depth:       c.Depth,
+		HistoryRefs: c.HistoryRefs,
+		DeletedRefs: c.DeletedRefs,
+	}
+	if len(c.IncludeRefs) > 0 || len(c.ExcludeRefs) > 0 {
+		opts.RefFilter = &borges.RefFilter{
+			Include: c.IncludeRefs,
+			Exclude: c.ExcludeRefs,
+		}
+	}
+
+	if c.ShallowSince != "" {
+		since, err := time.Parse("2006-01-02", c.ShallowSince)
+		if err != nil {
+			return nil, fmt.Errorf("invalid format in the given `--shallow-since` flag: %s", err)
+		}
+
+		opts.Since = since
+	}
+
+	return opts, nil
+}
+
+// validNamespace returns whether ns is empty or a namespace of references.
+func validNamespace(ns string) bool {
+	return ns == "" || (strings.HasPrefix(ns, "refs/") && !strings.HasSuffix(ns, "/"))
+}
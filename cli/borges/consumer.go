@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/src-d/borges"
+	"github.com/src-d/borges/sivacache"
 	"github.com/src-d/borges/storage"
 
 	"gopkg.in/src-d/core-retrieval.v0"
@@ -17,8 +18,12 @@ const (
 
 type consumerCmd struct {
 	cmd
-	WorkersCount int    `long:"workers" default:"8" description:"number of workers"`
-	Timeout      string `long:"timeout" default:"10h" description:"deadline to process a job"`
+	rootedCmd
+	archiverCmd
+	WorkersCount int           `long:"workers" default:"8" description:"number of workers"`
+	Timeout      string        `long:"timeout" default:"10h" description:"deadline to process a job"`
+	BatchWindow  time.Duration `long:"batch-window" default:"0s" description:"time to wait for the jobs of other repositories with changes in the same rooted repository, to push all their changes in a single transaction, 0 disables batching"`
+	CacheSize    int64         `long:"cache-size" default:"0" description:"maximum size in MB of the siva files of rooted repositories cached in the temporary directory, 0 disables the cache"`
 }
 
 func (c *consumerCmd) Execute(args []string) error {
@@ -37,13 +42,33 @@ func (c *consumerCmd) Execute(args []string) error {
 		return err
 	}
 
+	opts, err := c.archiverOptions()
+	if err != nil {
+		return err
+	}
+
+	opts.BatchWindow = c.BatchWindow
+
+	var cache *sivacache.Cache
+	if c.CacheSize > 0 {
+		if cache, err = newCache(c.CacheSize); err != nil {
+			return err
+		}
+	}
+
+	tx, err := c.cachedRootedTransactioner(cache)
+	if err != nil {
+		return err
+	}
+
 	wp := borges.NewArchiverWorkerPool(
 		log,
 		storage.FromDatabase(core.Database()),
-		core.RootedTransactioner(),
+		tx,
 		borges.NewTemporaryCloner(core.TemporaryFilesystem()),
 		core.Locking(),
 		timeout,
+		opts,
 	)
 	wp.SetWorkerCount(c.WorkersCount)
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/go-billy.v3/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+const (
+	exportCmdName      = "export"
+	exportCmdShortDesc = "export a repository as a bare git repository"
+	exportCmdLongDesc  = "Reassembles the repository with the given ID or endpoint from its rooted repositories into a new bare git repository at the given path, which must not exist, with the original names of its references."
+)
+
+type exportCmd struct {
+	cmd
+	rootedCmd
+	Args struct {
+		Repository string `positional-arg-name:"repository-id|url" required:"yes"`
+		Dest       string `positional-arg-name:"dest" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func (c *exportCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+
+	store := storage.FromDatabase(core.Database())
+	repos, err := findRepositories(store, c.Args.Repository)
+	if err != nil {
+		return err
+	}
+
+	switch len(repos) {
+	case 0:
+		return fmt.Errorf("repository not found: %s", c.Args.Repository)
+	case 1:
+	default:
+		return fmt.Errorf("%d repositories found with endpoint %s, export one of them by ID",
+			len(repos), c.Args.Repository)
+	}
+
+	if _, err := os.Stat(c.Args.Dest); err == nil {
+		return fmt.Errorf("destination already exists: %s", c.Args.Dest)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	tx, err := c.rootedTransactioner()
+	if err != nil {
+		return err
+	}
+
+	r := repos[0]
+	sto, err := filesystem.NewStorage(osfs.New(c.Args.Dest))
+	if err != nil {
+		return err
+	}
+
+	if _, err := git.Init(sto, nil); err != nil {
+		return err
+	}
+
+	if err := borges.Export(tx, r, sto); err != nil {
+		_ = os.RemoveAll(c.Args.Dest)
+		return err
+	}
+
+	log.Info("repository exported",
+		"id", uuid.UUID(r.ID),
+		"references", len(r.References),
+		"path", c.Args.Dest,
+	)
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/framework.v0/lock"
+)
+
+const (
+	gcCmdName      = "gc"
+	gcCmdShortDesc = "repack rooted repositories and prune their unreachable objects"
+	gcCmdLongDesc  = "Rewrites the siva files of the rooted repositories of the given init commits, or the ones of all the fetched repositories if none is given, with their references and a single packfile with the objects reachable from them. With --deleted-retention, the tombstones of the references deleted before the retention period are dropped first. With --dry-run, only the objects and bytes that would be reclaimed are reported."
+)
+
+type gcCmd struct {
+	cmd
+	rootedCmd
+	DryRun           bool          `long:"dry-run" description:"report what would be reclaimed without modifying the rooted repositories"`
+	DeletedRetention time.Duration `long:"deleted-retention" default:"0s" description:"time deleted references are kept in their tombstones, 0 keeps them forever"`
+}
+
+func (c *gcCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+
+	store := storage.FromDatabase(core.Database())
+	tombstones, err := c.expiredTombstones(store)
+	if err != nil {
+		return err
+	}
+
+	inits, err := c.inits(store, args, tombstones)
+	if err != nil {
+		return err
+	}
+
+	ls, err := core.Locking().NewSession(&lock.SessionConfig{TTL: 10 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer ls.Close()
+
+	w, err := c.rootedRewriter()
+	if err != nil {
+		return err
+	}
+
+	var failed, unreachable int
+	var reclaimed int64
+	for _, init := range inits {
+		var names []string
+		for _, ref := range tombstones[init] {
+			names = append(names, ref.Tombstone)
+		}
+
+		stats, err := borges.CollectGarbage(w, ls, init, names, c.DryRun)
+		if err != nil {
+			log.Error("error collecting garbage", "init", init.String(), "error", err)
+			failed++
+			continue
+		}
+
+		if !c.DryRun {
+			if err := store.RemoveDeletedReferences(tombstones[init]); err != nil {
+				log.Error("error removing deleted references", "init", init.String(), "error", err)
+				failed++
+				continue
+			}
+		}
+
+		log.Info("rooted repository collected",
+			"init", init.String(),
+			"objects", stats.Objects,
+			"unreachable", stats.Unreachable,
+			"bytes", stats.ReclaimedBytes,
+			"tombstones", len(names),
+			"dry-run", c.DryRun,
+		)
+
+		unreachable += stats.Unreachable
+		reclaimed += stats.ReclaimedBytes
+	}
+
+	log.Info("garbage collected",
+		"rooted", len(inits),
+		"failed", failed,
+		"unreachable", unreachable,
+		"bytes", reclaimed,
+		"dry-run", c.DryRun,
+	)
+
+	if failed > 0 {
+		return fmt.Errorf("%d rooted repositories could not be collected", failed)
+	}
+
+	return nil
+}
+
+// expiredTombstones returns by init commit the deleted references whose
+// retention period is over.
+func (c *gcCmd) expiredTombstones(store storage.RepoStore) (map[model.SHA1][]*storage.DeletedReference, error) {
+	if c.DeletedRetention <= 0 {
+		return nil, nil
+	}
+
+	refs, err := store.ExpiredDeletedReferences(time.Now().Add(-c.DeletedRetention))
+	if err != nil {
+		return nil, err
+	}
+
+	tombstones := make(map[model.SHA1][]*storage.DeletedReference)
+	for _, ref := range refs {
+		tombstones[ref.Init] = append(tombstones[ref.Init], ref)
+	}
+
+	return tombstones, nil
+}
+
+// inits returns the init commits given as arguments or, if there are none,
+// the ones of the references of all the fetched repositories and of the
+// given tombstones.
+func (c *gcCmd) inits(
+	store storage.RepoStore,
+	args []string,
+	tombstones map[model.SHA1][]*storage.DeletedReference,
+) ([]model.SHA1, error) {
+	var inits []model.SHA1
+	if len(args) > 0 {
+		for _, arg := range args {
+			init := model.NewSHA1(arg)
+			if init.String() != arg {
+				return nil, fmt.Errorf("invalid init commit: %s", arg)
+			}
+
+			inits = append(inits, init)
+		}
+
+		return inits, nil
+	}
+
+	repos, err := store.GetByStatus(model.Fetched)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[model.SHA1]bool)
+	for _, r := range repos {
+		for _, ref := range r.References {
+			if !seen[ref.Init] {
+				seen[ref.Init] = true
+				inits = append(inits, ref.Init)
+			}
+		}
+	}
+
+	for init := range tombstones {
+		if !seen[init] {
+			seen[init] = true
+			inits = append(inits, init)
+		}
+	}
+
+	return inits, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+)
+
+const (
+	historyCmdName      = "history"
+	historyCmdShortDesc = "show the history of the references of a repository"
+	historyCmdLongDesc  = "Prints every change archived of the references of the repository with the given ID or endpoint, or of just the given reference, oldest first. Updates to a commit that does not descend from the previous one are flagged as forced. With --deleted, the deleted references kept in tombstones are printed instead."
+)
+
+type historyCmd struct {
+	loggerCmd
+	Deleted bool `long:"deleted" description:"print the deleted references kept in tombstones, with the commit they pointed to and their tombstone"`
+	Args    struct {
+		Repository string `positional-arg-name:"repository-id|url" required:"yes"`
+		Reference  string `positional-arg-name:"reference"`
+	} `positional-args:"yes"`
+}
+
+func (c *historyCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+
+	store := storage.FromDatabase(core.Database())
+	repos, err := findRepositories(store, c.Args.Repository)
+	if err != nil {
+		return err
+	}
+
+	switch len(repos) {
+	case 0:
+		return fmt.Errorf("repository not found: %s", c.Args.Repository)
+	case 1:
+	default:
+		return fmt.Errorf("%d repositories found with endpoint %s, show one of them by ID",
+			len(repos), c.Args.Repository)
+	}
+
+	if c.Deleted {
+		return c.printDeleted(store, repos[0])
+	}
+
+	changes, err := store.ReferenceHistory(repos[0].ID, c.Args.Reference)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, ch := range changes {
+		var forced string
+		if ch.Forced {
+			forced = "forced"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			ch.Time.Format(time.RFC3339), ch.Action, ch.Name,
+			historyHash(ch.OldHash), historyHash(ch.NewHash), forced, ch.Job)
+	}
+
+	return w.Flush()
+}
+
+// printDeleted prints the deleted references of the repository, or just the
+// given one, that are kept in tombstones.
+func (c *historyCmd) printDeleted(store storage.RepoStore, r *model.Repository) error {
+	refs, err := store.DeletedReferences(r.ID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, ref := range refs {
+		if c.Args.Reference != "" && ref.Name != c.Args.Reference {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			ref.DeletedAt.Format(time.RFC3339), ref.Name,
+			historyHash(ref.Hash), ref.Tombstone)
+	}
+
+	return w.Flush()
+}
+
+func historyHash(h model.SHA1) string {
+	if h.IsZero() {
+		return "-"
+	}
+
+	return h.String()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/inconshreveable/log15"
+	"github.com/src-d/borges/storage"
 
 	"gopkg.in/src-d/core-retrieval.v0/schema"
 	"gopkg.in/src-d/framework.v0/database"
@@ -17,6 +18,7 @@ const (
 
 type initCmd struct {
 	loggerCmd
+	MigrateReferences bool `long:"migrate-references" description:"copy the references stored in the repositories table by previous versions to the repository_references table"`
 }
 
 func (c *initCmd) Execute(args []string) error {
@@ -31,6 +33,19 @@ func (c *initCmd) Execute(args []string) error {
 		return fmt.Errorf("unable to create database schema: %s", err)
 	}
 
+	if err := storage.CreateSchema(db); err != nil {
+		return fmt.Errorf("unable to create borges database schema: %s", err)
+	}
+
+	if c.MigrateReferences {
+		n, err := storage.MigrateReferences(db)
+		if err != nil {
+			return fmt.Errorf("unable to migrate references: %s", err)
+		}
+
+		log15.Info("references copied to their own table", "repositories", n)
+	}
+
 	log15.Info("database was successfully initialized")
 	return nil
 }
@@ -91,6 +91,38 @@ func main() {
 		panic(err)
 	}
 
+	if _, err := parser.AddCommand(adminCmdName, adminCmdShortDesc, adminCmdLongDesc, new(adminCmd)); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.AddCommand(verifyInitCmdName, verifyInitCmdShortDesc, verifyInitCmdLongDesc, new(verifyInitCmd)); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.AddCommand(gcCmdName, gcCmdShortDesc, gcCmdLongDesc, new(gcCmd)); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.AddCommand(purgeCmdName, purgeCmdShortDesc, purgeCmdLongDesc, new(purgeCmd)); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.AddCommand(exportCmdName, exportCmdShortDesc, exportCmdLongDesc, new(exportCmd)); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.AddCommand(verifyCmdName, verifyCmdShortDesc, verifyCmdLongDesc, new(verifyCmd)); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.AddCommand(reindexCmdName, reindexCmdShortDesc, reindexCmdLongDesc, new(reindexCmd)); err != nil {
+		panic(err)
+	}
+
+	if _, err := parser.AddCommand(historyCmdName, historyCmdShortDesc, historyCmdLongDesc, new(historyCmd)); err != nil {
+		panic(err)
+	}
+
 	if _, err := parser.Parse(); err != nil {
 		if err, ok := err.(*flags.Error); ok {
 			if err.Type == flags.ErrHelp {
@@ -22,7 +22,10 @@ const (
 
 type packerCmd struct {
 	loggerCmd
-	File      string `long:"file" short:"f" required:"true" description:"file with the repositories to pack (one per line)"`
+	dirSourceCmd
+	archiverCmd
+	Source    string `long:"source" default:"file" description:"source to get the repositories to pack from (file, dir)"`
+	File      string `long:"file" short:"f" description:"file with the repositories to pack (one per line), used with --source=file"`
 	OutputDir string `long:"to" default:"repositories" description:"path to store the packed siva files"`
 	Timeout   string `long:"timeout" default:"30m" description:"time to wait to consider a job failed"`
 	Workers   int    `long:"workers" default:"0" description:"number of workers to use, defaults to number of available processors"`
@@ -31,7 +34,7 @@ type packerCmd struct {
 func (c *packerCmd) Execute(args []string) error {
 	c.ChangeLogLevel()
 
-	log.Info("initializing pack process", "file", c.File, "output", c.OutputDir)
+	log.Info("initializing pack process", "source", c.Source, "output", c.OutputDir)
 
 	broker := queue.NewMemoryBroker()
 	q, err := broker.Queue("jobs")
@@ -50,6 +53,11 @@ func (c *packerCmd) Execute(args []string) error {
 		return fmt.Errorf("unable to initialize rooted transactioner: %s", err)
 	}
 
+	opts, err := c.archiverOptions()
+	if err != nil {
+		return err
+	}
+
 	wp := borges.NewArchiverWorkerPool(
 		log,
 		store,
@@ -57,6 +65,7 @@ func (c *packerCmd) Execute(args []string) error {
 		borges.NewTemporaryCloner(core.TemporaryFilesystem()),
 		core.Locking(),
 		timeout,
+		opts,
 	)
 
 	if c.Workers <= 0 {
@@ -64,9 +73,9 @@ func (c *packerCmd) Execute(args []string) error {
 	}
 	wp.SetWorkerCount(c.Workers)
 
-	f, err := os.Open(c.File)
+	iter, err := c.jobIter(store)
 	if err != nil {
-		return fmt.Errorf("unable to open file %q with repositories: %s", c.File, err)
+		return err
 	}
 
 	executor := borges.NewExecutor(
@@ -74,12 +83,28 @@ func (c *packerCmd) Execute(args []string) error {
 		q,
 		wp,
 		store,
-		borges.NewLineJobIter(f, store),
+		iter,
 	)
 
 	return executor.Execute()
 }
 
+func (c *packerCmd) jobIter(store storage.RepoStore) (borges.JobIter, error) {
+	switch c.Source {
+	case "file":
+		f, err := os.Open(c.File)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open file %q with repositories: %s", c.File, err)
+		}
+
+		return borges.NewLineJobIter(f, store), nil
+	case "dir":
+		return c.dirJobIter(store), nil
+	default:
+		return nil, fmt.Errorf("invalid source: %s", c.Source)
+	}
+}
+
 func (c *packerCmd) newRootedTransactioner() (repository.RootedTransactioner, error) {
 	tmpFs, err := core.TemporaryFilesystem().Chroot("borges-packer")
 	if err != nil {
@@ -20,9 +20,12 @@ const (
 
 type producerCmd struct {
 	cmd
-	Source          string `long:"source" default:"mentions" description:"source to produce jobs from (mentions, file)"`
+	dirSourceCmd
+	forgeSourceCmd
+	webhookSourceCmd
+	Source          string `long:"source" default:"mentions" description:"source to produce jobs from (mentions, file, jsonl, dir, forge, webhook)"`
 	MentionsQueue   string `long:"mentionsqueue" default:"rovers" description:"queue name used to obtain mentions if the source type is 'mentions'"`
-	File            string `long:"file" description:"path to a file to read URLs from, used with --source=file"`
+	File            string `long:"file" description:"path to a file to read URLs from, used with --source=file or --source=jsonl"`
 	RepublishBuried bool   `long:"republish-buried" description:"republishes again all buried jobs before starting to listen for mentions, used with --source=mentions"`
 }
 
@@ -71,6 +74,18 @@ func (c *producerCmd) jobIter(b queue.Broker) (borges.JobIter, error) {
 			return nil, err
 		}
 		return borges.NewLineJobIter(f, storer), nil
+	case "jsonl":
+		f, err := os.Open(c.File)
+		if err != nil {
+			return nil, err
+		}
+		return borges.NewJSONLJobIter(f, storer), nil
+	case "dir":
+		return c.dirJobIter(storer), nil
+	case "forge":
+		return c.forgeJobIter(storer)
+	case "webhook":
+		return c.webhookJobIter(storer)
 	default:
 		return nil, fmt.Errorf("invalid source: %s", c.Source)
 	}
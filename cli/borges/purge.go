@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/framework.v0/lock"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+const (
+	purgeCmdName      = "purge"
+	purgeCmdShortDesc = "remove repositories from the archive"
+	purgeCmdLongDesc  = "Removes the given repositories, by ID or endpoint, from their rooted repositories, prunes the objects no other repository references and deletes them from the database."
+)
+
+type purgeCmd struct {
+	cmd
+	rootedCmd
+	Args struct {
+		Repositories []string `positional-arg-name:"repository-id|url" required:"1"`
+	} `positional-args:"yes"`
+}
+
+func (c *purgeCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+
+	store := storage.FromDatabase(core.Database())
+	var repos []*model.Repository
+	for _, arg := range c.Args.Repositories {
+		rs, err := findRepositories(store, arg)
+		if err != nil {
+			return err
+		}
+
+		if len(rs) == 0 {
+			return fmt.Errorf("repository not found: %s", arg)
+		}
+
+		repos = append(repos, rs...)
+	}
+
+	ls, err := core.Locking().NewSession(&lock.SessionConfig{TTL: 10 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer ls.Close()
+
+	w, err := c.rootedRewriter()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, r := range repos {
+		id := uuid.UUID(r.ID)
+		if err := borges.Purge(store, w, ls, r); err != nil {
+			log.Error("error purging repository", "id", id, "error", err)
+			failed++
+			continue
+		}
+
+		log.Info("repository purged", "id", id, "endpoints", r.Endpoints)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d repositories could not be purged", failed)
+	}
+
+	return nil
+}
+
+// findRepositories returns the repository with the given ID or the ones with
+// the given endpoint.
+func findRepositories(store storage.RepoStore, arg string) ([]*model.Repository, error) {
+	id, err := uuid.FromString(arg)
+	if err != nil {
+		return store.GetByEndpoints(arg)
+	}
+
+	r, err := store.Get(kallax.ULID(id))
+	if err == kallax.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return []*model.Repository{r}, nil
+}
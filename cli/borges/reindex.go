@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/go-billy.v3/osfs"
+)
+
+const (
+	reindexCmdName      = "reindex"
+	reindexCmdShortDesc = "rebuild the repositories in the database from the rooted repositories"
+	reindexCmdLongDesc  = "Scans the siva files of the rooted repositories in the given directory, or in the object store given with --s3-endpoint, and creates in the database the repositories archived in them, with their endpoints and references. None of them can be in the database already."
+)
+
+type reindexCmd struct {
+	loggerCmd
+	rootedCmd
+	RootedDir string `long:"rooted-repositories-dir" description:"path to the directory with the siva files of the rooted repositories, required unless --s3-endpoint is given"`
+}
+
+func (c *reindexCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+
+	inits, tx, err := c.rootedRepositories()
+	if err != nil {
+		return err
+	}
+
+	log.Info("reindexing rooted repositories", "rooted", len(inits))
+	n, err := borges.Reindex(storage.FromDatabase(core.Database()), tx, inits)
+	if err != nil {
+		return err
+	}
+
+	log.Info("repositories reindexed", "repositories", n)
+	return nil
+}
+
+// rootedRepositories returns the init commits of the rooted repositories in
+// the rooted repositories directory or, if it is not given, in the object
+// store, along with their transactioner.
+func (c *reindexCmd) rootedRepositories() ([]model.SHA1, repository.RootedTransactioner, error) {
+	if c.RootedDir == "" {
+		if c.S3Endpoint == "" {
+			return nil, nil, fmt.Errorf("either --rooted-repositories-dir or --s3-endpoint is required")
+		}
+
+		names, err := c.s3Copier(nil).Files()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		inits, err := sivaInits(names)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tx, err := c.rootedTransactioner()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return inits, tx, nil
+	}
+
+	inits, err := c.inits()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpFs, err := core.TemporaryFilesystem().Chroot("borges-reindex")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx := repository.NewSivaRootedTransactioner(
+		repository.NewLocalCopier(osfs.New(c.RootedDir)),
+		tmpFs,
+	)
+
+	return inits, tx, nil
+}
+
+// inits returns the init commits of the siva files in the rooted repositories
+// directory.
+func (c *reindexCmd) inits() ([]model.SHA1, error) {
+	files, err := ioutil.ReadDir(c.RootedDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() {
+			names = append(names, f.Name())
+		}
+	}
+
+	return sivaInits(names)
+}
+
+// sivaInits returns the init commits of the siva files with the given names,
+// which are named after them. Other files are ignored.
+func sivaInits(names []string) ([]model.SHA1, error) {
+	var inits []model.SHA1
+	for _, name := range names {
+		if filepath.Ext(name) != ".siva" {
+			continue
+		}
+
+		hash := strings.TrimSuffix(name, ".siva")
+		init := model.NewSHA1(hash)
+		if init.String() != hash {
+			return nil, fmt.Errorf("siva file not named after an init commit: %s", name)
+		}
+
+		inits = append(inits, init)
+	}
+
+	return inits, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/s3"
+	"github.com/src-d/borges/sivacache"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/framework.v0/configurable"
+	"gopkg.in/src-d/go-billy.v3/osfs"
+	"gopkg.in/src-d/go-billy.v3/util"
+)
+
+type rootedCmd struct {
+	S3Endpoint  string `long:"s3-endpoint" env:"BORGES_S3_ENDPOINT" description:"URL of an S3-compatible object store to keep the rooted repositories in, such as https://s3.amazonaws.com, instead of the default location"`
+	S3Region    string `long:"s3-region" env:"BORGES_S3_REGION" default:"us-east-1" description:"region of the bucket, used with --s3-endpoint"`
+	S3Bucket    string `long:"s3-bucket" env:"BORGES_S3_BUCKET" description:"bucket of the rooted repositories, used with --s3-endpoint"`
+	S3Prefix    string `long:"s3-prefix" env:"BORGES_S3_PREFIX" description:"prefix of the names of the siva files in the bucket, used with --s3-endpoint"`
+	S3AccessKey string `long:"s3-access-key" env:"AWS_ACCESS_KEY_ID" description:"access key of the object store, used with --s3-endpoint"`
+	S3SecretKey string `long:"s3-secret-key" env:"AWS_SECRET_ACCESS_KEY" description:"secret key of the object store, used with --s3-endpoint"`
+}
+
+// rootedTransactioner returns the transactioner of the rooted repositories in
+// the object store, if one is given, or the one of core-retrieval otherwise.
+func (c *rootedCmd) rootedTransactioner() (repository.RootedTransactioner, error) {
+	return c.cachedRootedTransactioner(nil)
+}
+
+// cachedRootedTransactioner returns the transactioner of the rooted
+// repositories as rootedTransactioner does, but caching their siva files in
+// the given cache if it is not nil.
+func (c *rootedCmd) cachedRootedTransactioner(cache *sivacache.Cache) (repository.RootedTransactioner, error) {
+	if c.S3Endpoint == "" && cache == nil {
+		return core.RootedTransactioner(), nil
+	}
+
+	copier, err := c.copier(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	txFs, err := core.TemporaryFilesystem().Chroot("borges-rooted/transactions")
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.NewSivaRootedTransactioner(copier, txFs), nil
+}
+
+// rootedRewriter returns the rewriter of the siva files of the rooted
+// repositories given by rootedTransactioner.
+func (c *rootedCmd) rootedRewriter() (*borges.RootedRewriter, error) {
+	copier, err := c.copier(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, err := core.TemporaryFilesystem().Chroot("borges-rewrite")
+	if err != nil {
+		return nil, err
+	}
+
+	return borges.NewRootedRewriter(copier, fs), nil
+}
+
+// copier returns the copier of the siva files of the rooted repositories in
+// the object store, if one is given, or in the location configured for
+// core-retrieval otherwise, using the given cache if it is not nil.
+func (c *rootedCmd) copier(cache *sivacache.Cache) (repository.Copier, error) {
+	if c.S3Endpoint != "" {
+		return c.s3Copier(cache), nil
+	}
+
+	remote, err := defaultCopier()
+	if err != nil {
+		return nil, err
+	}
+
+	if cache == nil {
+		return remote, nil
+	}
+
+	return sivacache.NewCopier(remote, cache), nil
+}
+
+// s3Copier returns the copier of the siva files in the object store, using
+// the given cache if it is not nil.
+func (c *rootedCmd) s3Copier(cache *sivacache.Cache) *s3.Copier {
+	client := &s3.Client{
+		Endpoint:  c.S3Endpoint,
+		Region:    c.S3Region,
+		Bucket:    c.S3Bucket,
+		AccessKey: c.S3AccessKey,
+		SecretKey: c.S3SecretKey,
+	}
+
+	return s3.NewCopier(client, c.S3Prefix, cache)
+}
+
+// newCache returns a cache of siva files of the given size in MB, in the
+// temporary directory. Caches are not kept between runs, the files left by
+// previous ones are removed.
+func newCache(size int64) (*sivacache.Cache, error) {
+	tmpFs := core.TemporaryFilesystem()
+	const dir = "borges-rooted/cache"
+	if err := util.RemoveAll(tmpFs, dir); err != nil {
+		return nil, err
+	}
+
+	fs, err := tmpFs.Chroot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return sivacache.NewCache(fs, size<<20), nil
+}
+
+// rootedConfig is the location of the rooted repositories configured for
+// core-retrieval, which is read from the environment the same way.
+type rootedConfig struct {
+	configurable.BasicConfiguration
+	RootRepositoriesDir string `envconfig:"ROOT_REPOSITORIES_DIR" default:"/tmp/root-repositories"`
+	HDFS                string `envconfig:"HDFS" default:""`
+}
+
+// defaultCopier returns a copier of the rooted repositories in the location
+// configured for core-retrieval, in HDFS or in the local filesystem.
+func defaultCopier() (sivacache.VersionedCopier, error) {
+	config := &rootedConfig{}
+	configurable.InitConfig(config)
+
+	if config.HDFS != "" {
+		return sivacache.NewHDFSCopier(config.HDFS, config.RootRepositoriesDir)
+	}
+
+	return sivacache.NewLocalCopier(osfs.New(config.RootRepositoriesDir)), nil
+}
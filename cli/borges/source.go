@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+)
+
+type dirSourceCmd struct {
+	Dir             string `long:"dir" description:"path to a directory to look for repositories in, used with --source=dir"`
+	FollowSymlinks  bool   `long:"follow-symlinks" description:"follow symbolic links to directories, used with --source=dir"`
+	MaxSymlinkDepth int    `long:"max-symlink-depth" default:"8" description:"maximum number of symbolic links followed in a path, used with --source=dir"`
+}
+
+func (c *dirSourceCmd) dirJobIter(storer storage.RepoStore) borges.JobIter {
+	return borges.NewDirJobIter(c.Dir, borges.DirJobIterOptions{
+		FollowSymlinks:  c.FollowSymlinks,
+		MaxSymlinkDepth: c.MaxSymlinkDepth,
+	}, storer)
+}
+
+type forgeSourceCmd struct {
+	Forge      string `long:"forge" default:"github" description:"kind of forge API (github, gitlab, gitea), used with --source=forge"`
+	ForgeURL   string `long:"forge-url" default:"https://api.github.com" description:"base URL of the forge API, used with --source=forge"`
+	ForgeOwner string `long:"forge-owner" description:"organization, group or user whose repositories are listed, used with --source=forge"`
+	ForgeToken string `long:"forge-token" env:"BORGES_FORGE_TOKEN" description:"token used to authenticate with the forge API, used with --source=forge"`
+}
+
+func (c *forgeSourceCmd) forgeJobIter(storer storage.RepoStore) (borges.JobIter, error) {
+	return borges.NewForgeJobIter(borges.ForgeOptions{
+		Kind:  borges.ForgeKind(c.Forge),
+		URL:   c.ForgeURL,
+		Owner: c.ForgeOwner,
+		Token: c.ForgeToken,
+	}, storer)
+}
+
+type webhookSourceCmd struct {
+	WebhookAddr     string        `long:"webhook-addr" default:"0.0.0.0:8080" description:"address the webhook receiver listens on, used with --source=webhook"`
+	WebhookSecret   string        `long:"webhook-secret" env:"BORGES_WEBHOOK_SECRET" description:"secret of the webhooks, used to verify the requests, used with --source=webhook"`
+	WebhookDebounce time.Duration `long:"webhook-debounce" default:"10s" description:"time to wait for more push events of a repository before queueing it, used with --source=webhook"`
+}
+
+func (c *webhookSourceCmd) webhookJobIter(storer storage.RepoStore) (borges.JobIter, error) {
+	if c.WebhookSecret == "" {
+		log.Warn("no webhook secret given, requests will not be verified")
+	}
+
+	l, err := net.Listen("tcp", c.WebhookAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := borges.NewWebhookJobIter(borges.WebhookOptions{
+		Secret:   c.WebhookSecret,
+		Debounce: c.WebhookDebounce,
+	}, storer)
+
+	go func() {
+		log.Info("listening for webhooks", "address", c.WebhookAddr)
+		if err := http.Serve(l, iter); err != nil {
+			log.Error("webhook receiver stopped", "error", err)
+		}
+	}()
+
+	return iter, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/framework.v0/lock"
+	"gopkg.in/src-d/framework.v0/queue"
+)
+
+const (
+	verifyCmdName      = "verify"
+	verifyCmdShortDesc = "check the references of the fetched repositories against their rooted repositories"
+	verifyCmdLongDesc  = "Checks that every reference of the fetched repositories is in its rooted repository, pointing to the stored hash, with all the objects reachable from it. With --repair, the inconsistent references are removed from the rooted repositories and the database, and their repositories are set as pending and queued again, so they are archived again."
+)
+
+type verifyCmd struct {
+	cmd
+	rootedCmd
+	Repair bool `long:"repair" description:"remove the inconsistent references and queue their repositories again"`
+}
+
+func (c *verifyCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+
+	store := storage.FromDatabase(core.Database())
+	repos, err := store.GetByStatus(model.Fetched)
+	if err != nil {
+		return err
+	}
+
+	var q queue.Queue
+	var ls lock.Session
+	if c.Repair {
+		b := core.Broker()
+		defer b.Close()
+		if q, err = b.Queue(c.Queue); err != nil {
+			return err
+		}
+
+		ls, err = core.Locking().NewSession(&lock.SessionConfig{TTL: 10 * time.Second})
+		if err != nil {
+			return err
+		}
+		defer ls.Close()
+	}
+
+	tx, err := c.rootedTransactioner()
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, r := range repos {
+		id := uuid.UUID(r.ID)
+		incs, err := borges.VerifyReferences(tx, r)
+		if err != nil {
+			log.Error("error verifying repository", "id", id, "error", err)
+			failed++
+			continue
+		}
+
+		if len(incs) == 0 {
+			continue
+		}
+
+		failed++
+		for _, inc := range incs {
+			log.Warn("inconsistent reference",
+				"id", id,
+				"reference", inc.Reference.Name,
+				"init", inc.Reference.Init.String(),
+				"stored", inc.Reference.Hash.String(),
+				"rooted", inc.Hash.String(),
+				"error", inc.Err,
+			)
+		}
+
+		if c.Repair {
+			if err := repair(store, tx, ls, q, r, incs); err != nil {
+				log.Error("error repairing repository", "id", id, "error", err)
+			}
+		}
+	}
+
+	log.Info("references verified", "repositories", len(repos), "failed", failed)
+	if failed > 0 && !c.Repair {
+		return fmt.Errorf("%d repositories could not be verified or have inconsistent references", failed)
+	}
+
+	return nil
+}
+
+func repair(
+	store storage.RepoStore,
+	tx repository.RootedTransactioner,
+	ls lock.Session,
+	q queue.Queue,
+	r *model.Repository,
+	incs []*borges.RefInconsistency,
+) error {
+	previous := r.References
+	if err := borges.RepairReferences(tx, ls, r, incs); err != nil {
+		return err
+	}
+
+	if err := borges.UpdateReferences(store, r, previous); err != nil {
+		return err
+	}
+
+	if err := store.UpdateFailed(r, model.Pending); err != nil {
+		return err
+	}
+
+	return publish(q, r)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/framework.v0/queue"
+)
+
+const (
+	verifyInitCmdName      = "verify-init"
+	verifyInitCmdShortDesc = "find references whose stored init commit is not their first-parent root"
+	verifyInitCmdLongDesc  = "Checks every reference of the fetched repositories against its rooted repository. With --requeue, repositories with mismatches are set as pending and queued again, so their references are moved to the right rooted repositories."
+)
+
+type verifyInitCmd struct {
+	cmd
+	rootedCmd
+	Requeue bool `long:"requeue" description:"set the repositories with mismatches as pending and queue them again"`
+}
+
+func (c *verifyInitCmd) Execute(args []string) error {
+	c.ChangeLogLevel()
+
+	store := storage.FromDatabase(core.Database())
+	repos, err := store.GetByStatus(model.Fetched)
+	if err != nil {
+		return err
+	}
+
+	var q queue.Queue
+	if c.Requeue {
+		b := core.Broker()
+		defer b.Close()
+		if q, err = b.Queue(c.Queue); err != nil {
+			return err
+		}
+	}
+
+	tx, err := c.rootedTransactioner()
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, r := range repos {
+		id := uuid.UUID(r.ID)
+		mismatches, err := borges.VerifyInits(tx, r)
+		if err != nil {
+			log.Error("error verifying repository", "id", id, "error", err)
+			failed++
+			continue
+		}
+
+		if len(mismatches) == 0 {
+			continue
+		}
+
+		failed++
+		for _, m := range mismatches {
+			log.Warn("init commit mismatch",
+				"id", id,
+				"reference", m.Reference.Name,
+				"stored", m.Reference.Init.String(),
+				"computed", m.Init.String(),
+			)
+		}
+
+		if c.Requeue {
+			if err := requeue(store, q, r); err != nil {
+				log.Error("error queueing repository", "id", id, "error", err)
+			}
+		}
+	}
+
+	log.Info("init commits verified", "repositories", len(repos), "failed", failed)
+	if failed > 0 && !c.Requeue {
+		return fmt.Errorf("%d repositories could not be verified or have init commit mismatches", failed)
+	}
+
+	return nil
+}
+
+func requeue(store storage.RepoStore, q queue.Queue, r *model.Repository) error {
+	if err := store.SetStatus(r, model.Pending); err != nil {
+		return err
+	}
+
+	return publish(q, r)
+}
+
+func publish(q queue.Queue, r *model.Repository) error {
+	j := queue.NewJob()
+	if err := j.Encode(&borges.Job{RepositoryID: uuid.UUID(r.ID)}); err != nil {
+		return err
+	}
+
+	return q.Publish(j)
+}
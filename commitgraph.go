@@ -0,0 +1,586 @@
+package borges
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/inconshreveable/log15"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+)
+
+var errMmapNotSupported = errors.New("memory mapped files are not supported")
+
+// commitParents returns the parents of commits.
+type commitParents interface {
+	parents(h plumbing.Hash) ([]plumbing.Hash, error)
+}
+
+// objectParents gets the parents of commits decoding them from a storer.
+type objectParents struct {
+	storer storer.EncodedObjectStorer
+}
+
+func (p objectParents) parents(h plumbing.Hash) ([]plumbing.Hash, error) {
+	obj, err := p.storer.EncodedObject(plumbing.CommitObject, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return readCommitParents(obj)
+}
+
+// readCommitParents reads the parents of a commit from its header, without
+// decoding the rest of it.
+func readCommitParents(obj plumbing.EncodedObject) ([]plumbing.Hash, error) {
+	rd, err := obj.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	var parents []plumbing.Hash
+	r := bufio.NewReader(rd)
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if bytes.HasPrefix(line, []byte("parent ")) {
+			parents = append(parents, plumbing.NewHash(string(bytes.TrimSpace(line[7:]))))
+		} else if !bytes.HasPrefix(line, []byte("tree ")) {
+			// parents always come after the tree and before anything else
+			return parents, nil
+		}
+
+		if err == io.EOF {
+			return parents, nil
+		}
+	}
+}
+
+// isAncestor returns whether the commit a is reachable from b walking the
+// given parents, which is true if they are the same commit. Parents that are
+// not found, such as the ones of shallow commits, are not walked.
+func isAncestor(graph commitParents, a, b plumbing.Hash) (bool, error) {
+	seen := map[plumbing.Hash]bool{b: true}
+	pending := []plumbing.Hash{b}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if h == a {
+			return true, nil
+		}
+
+		parents, err := graph.parents(h)
+		if err == plumbing.ErrObjectNotFound && h != b {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		for _, p := range parents {
+			if !seen[p] {
+				seen[p] = true
+				pending = append(pending, p)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// commitGraphThreshold is the number of commits decoded by a lazyCommitGraph
+// before it builds a commit graph.
+const commitGraphThreshold = 1000
+
+// lazyCommitGraph gets the parents of commits decoding them from a storer,
+// so walking the few new commits of a fetch does not read every commit. Once
+// more than commitGraphThreshold commits are decoded, it builds a commit
+// graph with all the commits of the storer and uses it instead. If the graph
+// cannot be built, commits are still decoded.
+type lazyCommitGraph struct {
+	storer  storer.EncodedObjectStorer
+	decoded int
+	graph   *commitGraph
+	failed  bool
+}
+
+func newLazyCommitGraph(s storer.EncodedObjectStorer) *lazyCommitGraph {
+	return &lazyCommitGraph{storer: s}
+}
+
+func (g *lazyCommitGraph) parents(h plumbing.Hash) ([]plumbing.Hash, error) {
+	if g.build() {
+		return g.graph.parents(h)
+	}
+
+	g.decoded++
+	return objectParents{g.storer}.parents(h)
+}
+
+// isAncestor returns whether the commit a is reachable from b.
+func (g *lazyCommitGraph) isAncestor(a, b plumbing.Hash) (bool, error) {
+	if g.build() {
+		return g.graph.isAncestor(a, b)
+	}
+
+	return isAncestor(g, a, b)
+}
+
+// build builds the commit graph if it is time to, and returns whether it is
+// available.
+func (g *lazyCommitGraph) build() bool {
+	if g.graph != nil {
+		return true
+	}
+
+	if g.failed || g.decoded < commitGraphThreshold {
+		return false
+	}
+
+	graph, err := buildCommitGraph(g.storer, "")
+	if err != nil {
+		log15.Warn("unable to build commit graph", "error", err)
+		g.failed = true
+		return false
+	}
+
+	g.graph = graph
+	return true
+}
+
+// Close releases the commit graph, if it was built.
+func (g *lazyCommitGraph) Close() error {
+	if g.graph == nil {
+		return nil
+	}
+
+	return g.graph.Close()
+}
+
+// Commit graph file layout, all numbers are big endian uint32:
+//
+//   header   magic, number of commits (N), number of extra parents (E)
+//   hashes   N sorted commit hashes
+//   commits  N entries of first parent, second parent and generation
+//   extra    E parent positions of octopus merges
+//
+// Parents are positions in the hashes table. If a commit has more than two
+// parents, its second parent is the position in the extra table of its
+// parents after the first one, with the high bit set. The last one of them
+// also has the high bit set.
+const (
+	commitGraphMagic      = "BCG1"
+	commitGraphHeaderSize = 12
+	commitGraphEntrySize  = 12
+
+	graphNoParent      = 0x7fffffff
+	graphMissingCommit = 0x7ffffffe
+	graphExtraEdges    = 0x80000000
+)
+
+// commitGraph is a compact index of the commits of a repository, with their
+// parents and generation numbers. The generation number of a root commit is
+// 1, and for any other commit it is one more than the maximum generation
+// number of its parents. Commits referenced as parents that are not in the
+// repository are in the index, but they have no data.
+type commitGraph struct {
+	data   []byte
+	n      int
+	close  func() error
+	hashes []byte
+	index  []byte
+	extra  []byte
+}
+
+// buildCommitGraph writes a commit graph with all the commits of the given
+// storer in a temporary file in dir, or in the default directory for
+// temporary files if dir is empty, and opens it. The file is removed once it
+// is open and is released with Close.
+func buildCommitGraph(s storer.EncodedObjectStorer, dir string) (*commitGraph, error) {
+	f, err := ioutil.TempFile(dir, "borges-commit-graph")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	w := bufio.NewWriter(f)
+	if err := writeCommitGraph(w, s); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	return openCommitGraph(f.Name())
+}
+
+// openCommitGraph opens the commit graph in the given file. It is memory
+// mapped if the platform allows it.
+func openCommitGraph(path string) (*commitGraph, error) {
+	data, closer, err := mmapFile(path)
+	if err == errMmapNotSupported {
+		data, err = ioutil.ReadFile(path)
+		closer = func() error { return nil }
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := newCommitGraph(data)
+	if err != nil {
+		_ = closer()
+		return nil, err
+	}
+
+	g.close = closer
+	return g, nil
+}
+
+func newCommitGraph(data []byte) (*commitGraph, error) {
+	if len(data) < commitGraphHeaderSize || string(data[:4]) != commitGraphMagic {
+		return nil, fmt.Errorf("invalid commit graph header")
+	}
+
+	n := int(binary.BigEndian.Uint32(data[4:]))
+	e := int(binary.BigEndian.Uint32(data[8:]))
+	hashesEnd := commitGraphHeaderSize + n*20
+	indexEnd := hashesEnd + n*commitGraphEntrySize
+	if len(data) != indexEnd+e*4 {
+		return nil, fmt.Errorf("invalid commit graph size")
+	}
+
+	return &commitGraph{
+		data:   data,
+		n:      n,
+		hashes: data[commitGraphHeaderSize:hashesEnd],
+		index:  data[hashesEnd:indexEnd],
+		extra:  data[indexEnd:],
+	}, nil
+}
+
+// Close releases the commit graph.
+func (g *commitGraph) Close() error {
+	g.data, g.hashes, g.index, g.extra = nil, nil, nil, nil
+	if g.close == nil {
+		return nil
+	}
+
+	return g.close()
+}
+
+func (g *commitGraph) hash(pos uint32) plumbing.Hash {
+	var h plumbing.Hash
+	copy(h[:], g.hashes[pos*20:])
+	return h
+}
+
+func (g *commitGraph) position(h plumbing.Hash) (uint32, bool) {
+	i := sort.Search(g.n, func(i int) bool {
+		return bytes.Compare(g.hashes[i*20:i*20+20], h[:]) >= 0
+	})
+
+	if i < g.n && bytes.Equal(g.hashes[i*20:i*20+20], h[:]) {
+		return uint32(i), true
+	}
+
+	return 0, false
+}
+
+func (g *commitGraph) entry(pos uint32) (p1, p2, generation uint32) {
+	e := g.index[pos*commitGraphEntrySize:]
+	return binary.BigEndian.Uint32(e),
+		binary.BigEndian.Uint32(e[4:]),
+		binary.BigEndian.Uint32(e[8:])
+}
+
+// parentPositions returns the positions of the parents of the commit in the
+// given position, or plumbing.ErrObjectNotFound if it is not in the
+// repository.
+func (g *commitGraph) parentPositions(pos uint32) ([]uint32, error) {
+	p1, p2, _ := g.entry(pos)
+	switch {
+	case p1 == graphMissingCommit:
+		return nil, plumbing.ErrObjectNotFound
+	case p1 == graphNoParent:
+		return nil, nil
+	case p2 == graphNoParent:
+		return []uint32{p1}, nil
+	case p2&graphExtraEdges == 0:
+		return []uint32{p1, p2}, nil
+	}
+
+	parents := []uint32{p1}
+	for i := p2 &^ graphExtraEdges; ; i++ {
+		p := binary.BigEndian.Uint32(g.extra[i*4:])
+		parents = append(parents, p&^graphExtraEdges)
+		if p&graphExtraEdges != 0 {
+			return parents, nil
+		}
+	}
+}
+
+func (g *commitGraph) parents(h plumbing.Hash) ([]plumbing.Hash, error) {
+	pos, ok := g.position(h)
+	if !ok {
+		return nil, plumbing.ErrObjectNotFound
+	}
+
+	positions, err := g.parentPositions(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []plumbing.Hash
+	for _, p := range positions {
+		parents = append(parents, g.hash(p))
+	}
+
+	return parents, nil
+}
+
+// generation returns the generation number of a commit, or 0 if it is not in
+// the graph.
+func (g *commitGraph) generation(h plumbing.Hash) uint32 {
+	pos, ok := g.position(h)
+	if !ok {
+		return 0
+	}
+
+	_, _, gen := g.entry(pos)
+	return gen
+}
+
+// isAncestor returns whether the commit a is reachable from b, which is true
+// if they are the same commit. Commits with a generation number lower or
+// equal than the one of a are not walked, as a cannot be reached from them.
+func (g *commitGraph) isAncestor(a, b plumbing.Hash) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+
+	target, ok := g.position(a)
+	if !ok {
+		return false, plumbing.ErrObjectNotFound
+	}
+
+	start, ok := g.position(b)
+	if !ok {
+		return false, plumbing.ErrObjectNotFound
+	}
+
+	_, _, minGen := g.entry(target)
+	seen := map[uint32]bool{start: true}
+	pending := []uint32{start}
+	for len(pending) > 0 {
+		pos := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		parents, err := g.parentPositions(pos)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		for _, p := range parents {
+			if p == target {
+				return true, nil
+			}
+
+			if _, _, gen := g.entry(p); seen[p] || gen <= minGen {
+				continue
+			}
+
+			seen[p] = true
+			pending = append(pending, p)
+		}
+	}
+
+	return false, nil
+}
+
+// graphCommit is a commit being added to a commit graph.
+type graphCommit struct {
+	hash    plumbing.Hash
+	parents []uint32
+	missing bool
+}
+
+func writeCommitGraph(w io.Writer, s storer.EncodedObjectStorer) error {
+	commits, err := readGraphCommits(s)
+	if err != nil {
+		return err
+	}
+
+	generations := graphGenerations(commits)
+
+	var extra []uint32
+	index := make([]byte, len(commits)*commitGraphEntrySize)
+	for i, c := range commits {
+		p1, p2 := uint32(graphNoParent), uint32(graphNoParent)
+		switch {
+		case c.missing:
+			p1 = graphMissingCommit
+		case len(c.parents) == 1:
+			p1 = c.parents[0]
+		case len(c.parents) == 2:
+			p1, p2 = c.parents[0], c.parents[1]
+		case len(c.parents) > 2:
+			p1, p2 = c.parents[0], uint32(len(extra))|graphExtraEdges
+			for _, p := range c.parents[1 : len(c.parents)-1] {
+				extra = append(extra, p)
+			}
+			extra = append(extra, c.parents[len(c.parents)-1]|graphExtraEdges)
+		}
+
+		e := index[i*commitGraphEntrySize:]
+		binary.BigEndian.PutUint32(e, p1)
+		binary.BigEndian.PutUint32(e[4:], p2)
+		binary.BigEndian.PutUint32(e[8:], generations[i])
+	}
+
+	header := make([]byte, commitGraphHeaderSize)
+	copy(header, commitGraphMagic)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(commits)))
+	binary.BigEndian.PutUint32(header[8:], uint32(len(extra)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, c := range commits {
+		if _, err := w.Write(c.hash[:]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.Write(index); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4)
+	for _, p := range extra {
+		binary.BigEndian.PutUint32(buf, p)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readGraphCommits reads all the commits in the storer, sorted by hash, with
+// the positions of their parents.
+func readGraphCommits(s storer.EncodedObjectStorer) ([]*graphCommit, error) {
+	iter, err := s.IterEncodedObjects(plumbing.CommitObject)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[plumbing.Hash][]plumbing.Hash)
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		ps, err := readCommitParents(obj)
+		if err != nil {
+			return err
+		}
+
+		parents[obj.Hash()] = ps
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]plumbing.Hash, 0, len(parents))
+	for h, ps := range parents {
+		hashes = append(hashes, h)
+		for _, p := range ps {
+			if _, ok := parents[p]; !ok {
+				hashes = append(hashes, p)
+			}
+		}
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	positions := make(map[plumbing.Hash]uint32, len(hashes))
+	var commits []*graphCommit
+	for _, h := range hashes {
+		if _, ok := positions[h]; ok {
+			// missing parents can appear several times
+			continue
+		}
+
+		positions[h] = uint32(len(commits))
+		_, ok := parents[h]
+		commits = append(commits, &graphCommit{hash: h, missing: !ok})
+	}
+
+	for _, c := range commits {
+		for _, p := range parents[c.hash] {
+			c.parents = append(c.parents, positions[p])
+		}
+	}
+
+	return commits, nil
+}
+
+// graphGenerations returns the generation numbers of the commits. Missing
+// commits have generation 0.
+func graphGenerations(commits []*graphCommit) []uint32 {
+	generations := make([]uint32, len(commits))
+	for i := range commits {
+		if generations[i] != 0 || commits[i].missing {
+			continue
+		}
+
+		stack := []uint32{uint32(i)}
+		for len(stack) > 0 {
+			pos := stack[len(stack)-1]
+			if generations[pos] != 0 {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			c := commits[pos]
+
+			var gen uint32
+			done := true
+			for _, p := range c.parents {
+				if generations[p] == 0 && !commits[p].missing {
+					stack = append(stack, p)
+					done = false
+				} else if generations[p] > gen {
+					gen = generations[p]
+				}
+			}
+
+			if done {
+				generations[pos] = gen + 1
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	return generations
+}
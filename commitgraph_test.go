@@ -0,0 +1,147 @@
+package borges
+
+import (
+	"testing"
+
+	"github.com/src-d/go-git-fixtures"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestCommitGraph(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	missing := plumbing.NewHash("0000000000000000000000000000000000000001")
+	a := h.commit()
+	b := h.commit()
+	c := h.commit(a)
+	octopus := h.commit(c, b, a)
+	broken := h.commit(missing)
+	head := h.commit(octopus, broken)
+
+	g, err := buildCommitGraph(h.storage, "")
+	require.NoError(err)
+	defer func() { require.NoError(g.Close()) }()
+
+	objects := objectParents{h.storage}
+	for _, commit := range []plumbing.Hash{a, b, c, octopus, broken, head} {
+		expected, err := objects.parents(commit)
+		require.NoError(err)
+
+		parents, err := g.parents(commit)
+		require.NoError(err)
+		require.Equal(expected, parents)
+	}
+
+	_, err = g.parents(missing)
+	require.Equal(plumbing.ErrObjectNotFound, err)
+
+	_, err = g.parents(plumbing.NewHash("0000000000000000000000000000000000000002"))
+	require.Equal(plumbing.ErrObjectNotFound, err)
+
+	require.Equal(uint32(1), g.generation(a))
+	require.Equal(uint32(2), g.generation(c))
+	require.Equal(uint32(3), g.generation(octopus))
+	require.Equal(uint32(1), g.generation(broken))
+	require.Equal(uint32(4), g.generation(head))
+	require.Equal(uint32(0), g.generation(missing))
+
+	for _, tc := range []struct {
+		a, b     plumbing.Hash
+		ancestor bool
+	}{
+		{a, head, true},
+		{b, octopus, true},
+		{broken, head, true},
+		{head, head, true},
+		{c, b, false},
+		{octopus, broken, false},
+		{head, a, false},
+	} {
+		ok, err := g.isAncestor(tc.a, tc.b)
+		require.NoError(err)
+		require.Equal(tc.ancestor, ok, "%s is ancestor of %s", tc.a, tc.b)
+	}
+}
+
+func TestCommitGraph_Roots(t *testing.T) {
+	require := require.New(t)
+	h, head, expected := manyRoots(100)
+
+	g, err := buildCommitGraph(h.storage, "")
+	require.NoError(err)
+	defer func() { require.NoError(g.Close()) }()
+
+	roots, err := newRootsFinder(g).find(head)
+	require.NoError(err)
+	require.Equal(expected, roots)
+}
+
+func TestGitReferencer_CommitGraph(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+
+	for _, ct := range ChangesFixtures {
+		t.Run(ct.TestName, func(t *testing.T) {
+			require := require.New(t)
+			r, err := ct.NewRepository()
+			require.NoError(err)
+
+			expected, err := NewGitReferencer(r).References()
+			require.NoError(err)
+
+			g, err := buildCommitGraph(r.Storer, "")
+			require.NoError(err)
+			defer func() { require.NoError(g.Close()) }()
+
+			refs, err := gitReferencer{Repository: r, graph: g}.References()
+			require.NoError(err)
+			require.Equal(refsByName(expected), refsByName(refs))
+		})
+	}
+}
+
+func BenchmarkRootsFinder_CommitGraphManyRoots5000(b *testing.B) {
+	h, head, _ := manyRoots(5000)
+	g, err := buildCommitGraph(h.storage, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer g.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := newRootsFinder(g).find(head); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLazyCommitGraph(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	commits := []plumbing.Hash{h.commit()}
+	for i := 0; i < commitGraphThreshold; i++ {
+		commits = append(commits, h.commit(commits[len(commits)-1]))
+	}
+
+	g := newLazyCommitGraph(h.storage)
+	defer func() { require.NoError(g.Close()) }()
+
+	head := commits[len(commits)-1]
+	parents, err := g.parents(head)
+	require.NoError(err)
+	require.Equal([]plumbing.Hash{commits[len(commits)-2]}, parents)
+	require.Nil(g.graph)
+
+	ok, err := g.isAncestor(commits[0], head)
+	require.NoError(err)
+	require.True(ok)
+	require.NotNil(g.graph)
+
+	parents, err = g.parents(head)
+	require.NoError(err)
+	require.Equal([]plumbing.Hash{commits[len(commits)-2]}, parents)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/satori/go.uuid"
 	"github.com/src-d/borges/storage"
 	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/framework.v0/queue"
 	"gopkg.in/src-d/go-errors.v0"
 )
 
@@ -22,7 +23,18 @@ var (
 
 // Job represents a borges job to fetch and archive a repository.
 type Job struct {
+	// ID identifies the job in the queue. It is set when the job is read
+	// from the queue, so it is not encoded in the payload.
+	ID           string `json:"-" msgpack:"-"`
 	RepositoryID uuid.UUID
+	// Priority is the priority used to publish the job. If it is 0 the job
+	// is published with the default priority of the queue.
+	Priority queue.Priority
+	// Labels are arbitrary tags given to the job by its source.
+	Labels []string
+	// RefFilter selects the references archived for this job. If it is nil
+	// the filter of the archiver is used.
+	RefFilter *RefFilter
 }
 
 // JobIter is an iterator of Job.
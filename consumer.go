@@ -119,6 +119,7 @@ func (c *Consumer) consumeJob(j *queue.Job) error {
 		return err
 	}
 
+	job.ID = j.ID
 	c.WorkerPool.Do(&WorkerJob{job, j})
 	return nil
 }
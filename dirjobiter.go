@@ -0,0 +1,236 @@
+package borges
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/src-d/borges/storage"
+)
+
+// DirJobIterOptions are the options used to discover repositories in a
+// directory tree.
+type DirJobIterOptions struct {
+	// FollowSymlinks makes the iterator visit directories pointed by
+	// symbolic links.
+	FollowSymlinks bool
+	// MaxSymlinkDepth is the maximum number of symbolic links that can be
+	// followed in a single path. It is only used if FollowSymlinks is true.
+	MaxSymlinkDepth int
+}
+
+type dirEntry struct {
+	path     string
+	symlinks int
+}
+
+type dirJobIter struct {
+	storer  storage.RepoStore
+	opts    DirJobIterOptions
+	pending []dirEntry
+	found   []string
+	visited map[string]bool
+	emitted map[string]bool
+	// err is returned by the first call to Next, if the root cannot be
+	// made absolute
+	err error
+}
+
+// NewDirJobIter returns a JobIter that walks the directory tree under root and
+// returns a job for every git repository found, with a file:// endpoint.
+// Bare repositories, regular repositories (including repositories nested in
+// the working tree of others) and worktrees are detected. A worktree or a
+// submodule checkout is archived through the repository that holds its git
+// directory, and each repository is only returned once. A relative root is
+// taken from the current directory, as endpoints are absolute paths.
+func NewDirJobIter(root string, opts DirJobIterOptions, storer storage.RepoStore) JobIter {
+	iter := &dirJobIter{
+		storer:  storer,
+		opts:    opts,
+		visited: make(map[string]bool),
+		emitted: make(map[string]bool),
+	}
+
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		iter.err = err
+		return iter
+	}
+
+	iter.pending = []dirEntry{{path: abs}}
+	return iter
+}
+
+func (i *dirJobIter) Next() (*Job, error) {
+	if err := i.err; err != nil {
+		i.err = nil
+		return nil, err
+	}
+
+	for len(i.found) == 0 {
+		if len(i.pending) == 0 {
+			return nil, io.EOF
+		}
+
+		last := len(i.pending) - 1
+		dir := i.pending[last]
+		i.pending = i.pending[:last]
+		if err := i.visit(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	endpoint := i.found[0]
+	i.found = i.found[1:]
+
+	ID, err := RepositoryID([]string{endpoint}, nil, i.storer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{RepositoryID: ID}, nil
+}
+
+// visit looks for a repository in the given directory and queues its
+// subdirectories to be visited, unless it is a bare repository.
+func (i *dirJobIter) visit(dir dirEntry) error {
+	resolved, err := filepath.EvalSymlinks(dir.path)
+	if err != nil {
+		return err
+	}
+
+	if i.visited[resolved] {
+		return nil
+	}
+	i.visited[resolved] = true
+
+	if isBareRepository(dir.path) {
+		i.emit(dir.path)
+		return nil
+	}
+
+	dotGit := filepath.Join(dir.path, ".git")
+	fi, err := os.Stat(dotGit)
+	if err == nil {
+		if fi.IsDir() {
+			i.emit(dotGit)
+		} else {
+			gitDir, err := resolveGitDirFile(dotGit)
+			if err != nil {
+				return err
+			}
+
+			i.emit(gitDir)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	fis, err := ioutil.ReadDir(dir.path)
+	if err != nil {
+		return err
+	}
+
+	// push them in reverse order so they are visited in lexical order
+	for j := len(fis) - 1; j >= 0; j-- {
+		fi := fis[j]
+		if fi.Name() == ".git" {
+			continue
+		}
+
+		path := filepath.Join(dir.path, fi.Name())
+		switch {
+		case fi.IsDir():
+			i.pending = append(i.pending, dirEntry{path, dir.symlinks})
+		case fi.Mode()&os.ModeSymlink != 0:
+			if !i.opts.FollowSymlinks || dir.symlinks >= i.opts.MaxSymlinkDepth {
+				continue
+			}
+
+			target, err := os.Stat(path)
+			if err != nil || !target.IsDir() {
+				continue
+			}
+
+			i.pending = append(i.pending, dirEntry{path, dir.symlinks + 1})
+		}
+	}
+
+	return nil
+}
+
+func (i *dirJobIter) emit(gitDir string) {
+	key := gitDir
+	if resolved, err := filepath.EvalSymlinks(gitDir); err == nil {
+		key = resolved
+	}
+
+	if i.emitted[key] {
+		return
+	}
+
+	i.emitted[key] = true
+	i.found = append(i.found, fmt.Sprintf("file://%s", gitDir))
+}
+
+// Close does nothing, the directory tree is not kept open.
+func (i *dirJobIter) Close() error {
+	return nil
+}
+
+// isBareRepository reports whether the given directory looks like a git
+// directory.
+func isBareRepository(path string) bool {
+	head, err := os.Stat(filepath.Join(path, "HEAD"))
+	if err != nil || head.IsDir() {
+		return false
+	}
+
+	for _, dir := range []string{"objects", "refs"} {
+		fi, err := os.Stat(filepath.Join(path, dir))
+		if err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// resolveGitDirFile returns the git directory with the objects of a .git file,
+// as the ones found in worktrees and submodules. Worktrees point to a
+// directory inside the git directory of the main repository, which is found
+// in its commondir file.
+func resolveGitDirFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(content))
+	const prefix = "gitdir:"
+	if !strings.HasPrefix(line, prefix) {
+		return "", fmt.Errorf("invalid .git file %q", path)
+	}
+
+	gitDir := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(path), gitDir)
+	}
+
+	common, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if os.IsNotExist(err) {
+		return filepath.Clean(gitDir), nil
+	} else if err != nil {
+		return "", err
+	}
+
+	commonDir := strings.TrimSpace(string(common))
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(gitDir, commonDir)
+	}
+
+	return filepath.Clean(commonDir), nil
+}
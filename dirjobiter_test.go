@@ -0,0 +1,157 @@
+package borges
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/src-d/borges/storage"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestDirJobIter(t *testing.T) {
+	suite.Run(t, new(DirJobIterSuite))
+}
+
+type DirJobIterSuite struct {
+	suite.Suite
+	dir     string
+	outside string
+	store   storage.RepoStore
+}
+
+func (s *DirJobIterSuite) SetupTest() {
+	require := s.Require()
+
+	var err error
+	s.dir, err = ioutil.TempDir("", "dirjobiter")
+	require.NoError(err)
+	s.outside, err = ioutil.TempDir("", "dirjobiter-outside")
+	require.NoError(err)
+
+	s.store = storage.Local()
+
+	s.gitDir(filepath.Join(s.dir, "bare"))
+	s.gitDir(filepath.Join(s.dir, "a", "repo", ".git"))
+	s.gitDir(filepath.Join(s.dir, "a", "repo", "nested", ".git"))
+	require.NoError(os.MkdirAll(filepath.Join(s.dir, "a", "empty"), 0755))
+
+	// worktree of a/repo
+	worktreeGitDir := filepath.Join(s.dir, "a", "repo", ".git", "worktrees", "wt")
+	require.NoError(os.MkdirAll(worktreeGitDir, 0755))
+	s.writeFile(filepath.Join(worktreeGitDir, "commondir"), "../..\n")
+	s.writeFile(filepath.Join(s.dir, "wt", ".git"), fmt.Sprintf("gitdir: %s\n", worktreeGitDir))
+
+	// submodule checkout
+	s.gitDir(filepath.Join(s.dir, "b", ".git"))
+	s.gitDir(filepath.Join(s.dir, "b", ".git", "modules", "sub"))
+	s.writeFile(filepath.Join(s.dir, "b", "sub", ".git"), "gitdir: ../.git/modules/sub\n")
+
+	s.gitDir(filepath.Join(s.outside, "linked"))
+	require.NoError(os.Symlink(s.outside, filepath.Join(s.dir, "link")))
+	require.NoError(os.Symlink(s.dir, filepath.Join(s.dir, "a", "loop")))
+}
+
+func (s *DirJobIterSuite) TearDownTest() {
+	s.NoError(os.RemoveAll(s.dir))
+	s.NoError(os.RemoveAll(s.outside))
+}
+
+func (s *DirJobIterSuite) TestNoSymlinks() {
+	endpoints := s.endpoints(DirJobIterOptions{})
+	s.Equal([]string{
+		s.endpoint("a", "repo", ".git"),
+		s.endpoint("a", "repo", "nested", ".git"),
+		s.endpoint("b", ".git"),
+		s.endpoint("b", ".git", "modules", "sub"),
+		s.endpoint("bare"),
+	}, endpoints)
+}
+
+func (s *DirJobIterSuite) TestFollowSymlinks() {
+	endpoints := s.endpoints(DirJobIterOptions{
+		FollowSymlinks:  true,
+		MaxSymlinkDepth: 1,
+	})
+	s.Equal([]string{
+		s.endpoint("a", "repo", ".git"),
+		s.endpoint("a", "repo", "nested", ".git"),
+		s.endpoint("b", ".git"),
+		s.endpoint("b", ".git", "modules", "sub"),
+		s.endpoint("bare"),
+		s.endpoint("link", "linked"),
+	}, endpoints)
+}
+
+func (s *DirJobIterSuite) TestNotADirectory() {
+	iter := NewDirJobIter(filepath.Join(s.dir, "not-exists"), DirJobIterOptions{}, s.store)
+	_, err := iter.Next()
+	s.Error(err)
+
+	_, err = iter.Next()
+	s.Equal(io.EOF, err)
+}
+
+func (s *DirJobIterSuite) TestRelativeRoot() {
+	require := s.Require()
+	wd, err := os.Getwd()
+	require.NoError(err)
+	require.NoError(os.Chdir(filepath.Dir(s.dir)))
+	defer func() { require.NoError(os.Chdir(wd)) }()
+
+	endpoints := s.iterEndpoints(NewDirJobIter(filepath.Base(s.dir), DirJobIterOptions{}, s.store))
+	s.Equal([]string{
+		s.endpoint("a", "repo", ".git"),
+		s.endpoint("a", "repo", "nested", ".git"),
+		s.endpoint("b", ".git"),
+		s.endpoint("b", ".git", "modules", "sub"),
+		s.endpoint("bare"),
+	}, endpoints)
+}
+
+func (s *DirJobIterSuite) endpoints(opts DirJobIterOptions) []string {
+	return s.iterEndpoints(NewDirJobIter(s.dir, opts, s.store))
+}
+
+func (s *DirJobIterSuite) iterEndpoints(iter JobIter) []string {
+	require := s.Require()
+
+	var endpoints []string
+	for {
+		j, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(err)
+
+		repo, err := s.store.Get(kallax.ULID(j.RepositoryID))
+		require.NoError(err)
+		endpoints = append(endpoints, repo.Endpoints...)
+	}
+
+	require.NoError(iter.Close())
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+func (s *DirJobIterSuite) endpoint(path ...string) string {
+	return fmt.Sprintf("file://%s", filepath.Join(append([]string{s.dir}, path...)...))
+}
+
+func (s *DirJobIterSuite) gitDir(path string) {
+	require := s.Require()
+	require.NoError(os.MkdirAll(filepath.Join(path, "objects"), 0755))
+	require.NoError(os.MkdirAll(filepath.Join(path, "refs"), 0755))
+	s.writeFile(filepath.Join(path, "HEAD"), "ref: refs/heads/master\n")
+}
+
+func (s *DirJobIterSuite) writeFile(path, content string) {
+	require := s.Require()
+	require.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(ioutil.WriteFile(path, []byte(content), 0644))
+}
@@ -15,4 +15,18 @@
 //
 // When borges fetches a repository, it groups all references by init commit
 // and pushes each group of references to a repository for its init commit.
+// References are stored as <name>/<repository id>. The HEAD of a repository
+// is stored as the symbolic reference refs/remotes/<repository id>/HEAD, in
+// the same rooted repository as the reference it points to.
+//
+// The changes of a repository are pushed to all its rooted repositories
+// before committing any of them, and the repository is updated once they are
+// committed. The rooted repositories being committed are recorded before, so
+// if the job is interrupted, the next job of the repository recovers its
+// references from them.
+//
+// Repositories can be archived with a limited history. The roots of their
+// references are then shallow commits, whose parents are not archived, and
+// are not grouped by init commit but by a key derived from the repository,
+// see ShallowInit.
 package borges
@@ -74,13 +74,13 @@ func (p *Executor) queueJobs() error {
 			return nil
 		}
 
-		p.log.Debug("got job", "id", job.RepositoryID)
-
 		if err != nil {
 			p.logError(err)
 			continue
 		}
 
+		p.log.Debug("got job", "id", job.RepositoryID)
+
 		qj := queue.NewJob()
 		if err := qj.Encode(&job); err != nil {
 			return err
@@ -127,6 +127,7 @@ func (p *Executor) consumeJobs() error {
 				p.logError(err)
 			}
 		} else {
+			job.ID = j.ID
 			p.wp.Do(&WorkerJob{&job, j})
 		}
 	}
@@ -0,0 +1,227 @@
+package borges
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/go-errors.v0"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/packfile"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+// ErrReferenceNotArchived is returned when a reference of a repository is not
+// in the rooted repository of its init commit.
+var ErrReferenceNotArchived = errors.NewKind("reference %s not found in rooted repository %s")
+
+// Export writes the given repository to dst as a regular git repository,
+// reassembling it from the rooted repositories of the init commits of its
+// references. References get their original names, HEAD points to the same
+// reference as in the remote repository if it is known, and the endpoints of
+// the repository are configured as the origin remote, mirroring all its
+// references.
+func Export(tx repository.RootedTransactioner, r *model.Repository, dst storage.Storer) error {
+	inits, byInit := referencesByInit(r.References)
+	for _, init := range inits {
+		if err := exportReferences(tx, r, init, byInit[init], dst); err != nil {
+			return err
+		}
+	}
+
+	if len(r.Endpoints) == 0 {
+		return nil
+	}
+
+	c, err := dst.Config()
+	if err != nil {
+		return err
+	}
+
+	c.Remotes[git.DefaultRemoteName] = &config.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  r.Endpoints,
+		Fetch: []config.RefSpec{"+refs/*:refs/*"},
+	}
+
+	return dst.SetConfig(c)
+}
+
+// exportReferences copies the given references of the repository, all of them
+// with the given init commit, from its rooted repository to dst.
+func exportReferences(
+	tx repository.RootedTransactioner,
+	r *model.Repository,
+	init model.SHA1,
+	refs []*model.Reference,
+	dst storage.Storer,
+) (err error) {
+	t, err := tx.Begin(plumbing.Hash(init))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if rErr := t.Rollback(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}()
+
+	src := t.Storer()
+	suffix := fmt.Sprintf("/%s", r.ID)
+
+	var tips []plumbing.Hash
+	var exported []*plumbing.Reference
+	for _, ref := range refs {
+		name := plumbing.ReferenceName(ref.Name + suffix)
+		stored, err := src.Reference(name)
+		if err == plumbing.ErrReferenceNotFound {
+			return ErrReferenceNotArchived.New(ref.Name, init.String())
+		} else if err != nil {
+			return err
+		}
+
+		tips = append(tips, stored.Hash())
+		exported = append(exported, plumbing.NewHashReference(
+			plumbing.ReferenceName(ref.Name),
+			stored.Hash(),
+		))
+	}
+
+	shallow, err := shallowCommits(src)
+	if err != nil {
+		return err
+	}
+
+	objects, err := reachableFrom(src, tips, shallow)
+	if err != nil {
+		return err
+	}
+
+	if err := copyMissingObjects(src, dst, objects); err != nil {
+		return err
+	}
+
+	if err := exportShallow(dst, objects, shallow); err != nil {
+		return err
+	}
+
+	for _, ref := range exported {
+		if err := dst.SetReference(ref); err != nil {
+			return err
+		}
+	}
+
+	head, err := src.Reference(plumbing.ReferenceName(
+		fmt.Sprintf("refs/remotes/%s/HEAD", r.ID),
+	))
+	if err == plumbing.ErrReferenceNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	target := strings.TrimSuffix(head.Target().String(), suffix)
+	return dst.SetReference(plumbing.NewSymbolicReference(
+		plumbing.HEAD,
+		plumbing.ReferenceName(target),
+	))
+}
+
+// packWindow is the number of objects considered as delta bases when objects
+// are packed, the same as git default.
+const packWindow = 10
+
+// copyMissingObjects copies the given objects from src to dst, skipping the
+// ones dst already has. They are written as a packfile if dst supports it.
+func copyMissingObjects(
+	src storer.EncodedObjectStorer,
+	dst storage.Storer,
+	objects map[plumbing.Hash]bool,
+) error {
+	missing := make(map[plumbing.Hash]bool, len(objects))
+	for h := range objects {
+		if err := dst.HasEncodedObject(h); err == plumbing.ErrObjectNotFound {
+			missing[h] = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if pfw, ok := dst.(storer.PackfileWriter); ok {
+		_, err := writePack(src, pfw, missing)
+		return err
+	}
+
+	for h := range missing {
+		obj, err := src.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return err
+		}
+
+		if _, err := dst.SetEncodedObject(obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writePack writes the given objects of src as a packfile to dst.
+func writePack(
+	src storer.EncodedObjectStorer,
+	dst storer.PackfileWriter,
+	objects map[plumbing.Hash]bool,
+) (h plumbing.Hash, err error) {
+	hashes := make([]plumbing.Hash, 0, len(objects))
+	for h := range objects {
+		hashes = append(hashes, h)
+	}
+
+	w, err := dst.PackfileWriter()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	defer func() {
+		if cErr := w.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+
+	return packfile.NewEncoder(w, src, false).Encode(hashes, packWindow)
+}
+
+// exportShallow adds to the shallow commits of dst the ones of the given
+// objects.
+func exportShallow(
+	dst storer.ShallowStorer,
+	objects map[plumbing.Hash]bool,
+	shallow map[plumbing.Hash]bool,
+) error {
+	var commits []plumbing.Hash
+	for h := range shallow {
+		if objects[h] {
+			commits = append(commits, h)
+		}
+	}
+
+	if len(commits) == 0 {
+		return nil
+	}
+
+	current, err := dst.Shallow()
+	if err != nil {
+		return err
+	}
+
+	return dst.SetShallow(append(current, commits...))
+}
@@ -0,0 +1,110 @@
+package borges
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/src-d/go-git-fixtures"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-billy.v3/memfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+func TestExport(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	r := model.NewRepository()
+	r.Endpoints = []string{"git://foo", "https://foo"}
+
+	basic, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	it, err := basic.IterReferences()
+	require.NoError(err)
+	require.NoError(it.ForEach(func(ref *plumbing.Reference) error {
+		return basic.RemoveReference(ref.Name())
+	}))
+
+	master := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	masterName := plumbing.ReferenceName(fmt.Sprintf("refs/heads/master/%s", r.ID))
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewHashReference(masterName, master),
+		plumbing.NewHashReference(plumbing.ReferenceName(fmt.Sprintf("refs/tags/v1.0.0/%s", r.ID)), master),
+		plumbing.NewSymbolicReference(plumbing.ReferenceName(fmt.Sprintf("refs/remotes/%s/HEAD", r.ID)), masterName),
+	} {
+		require.NoError(basic.SetReference(ref))
+	}
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit(a)
+	other := h.commit(b)
+	require.NoError(h.storage.SetShallow([]plumbing.Hash{b}))
+	syntheticRepository(t, h, map[string]plumbing.Hash{
+		fmt.Sprintf("refs/heads/other/%s", r.ID): other,
+	})
+
+	basicInit := model.NewSHA1("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	shallowInit := ShallowInit(r.ID)
+	tx := memoryTransactioner{
+		plumbing.Hash(basicInit):   basic,
+		plumbing.Hash(shallowInit): h.storage,
+	}
+
+	r.References = []*model.Reference{
+		{Name: "refs/heads/master", Hash: model.SHA1(master), Init: basicInit},
+		{Name: "refs/tags/v1.0.0", Hash: model.SHA1(master), Init: basicInit},
+		{Name: "refs/heads/other", Hash: model.SHA1(other), Init: shallowInit},
+	}
+
+	dst, err := filesystem.NewStorage(memfs.New())
+	require.NoError(err)
+	_, err = git.Init(dst, nil)
+	require.NoError(err)
+
+	require.NoError(Export(tx, r, dst))
+
+	expected := map[string]plumbing.Hash{
+		"refs/heads/master": master,
+		"refs/tags/v1.0.0":  master,
+		"refs/heads/other":  other,
+	}
+
+	refs, err := dst.IterReferences()
+	require.NoError(err)
+	require.NoError(refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() == plumbing.HEAD {
+			require.Equal(plumbing.Master, ref.Target())
+			return nil
+		}
+
+		require.Equal(expected[ref.Name().String()], ref.Hash(), ref.Name().String())
+		delete(expected, ref.Name().String())
+		return nil
+	}))
+	require.Empty(expected)
+
+	_, err = reachableObjects(dst)
+	require.NoError(err)
+	require.Equal(plumbing.ErrObjectNotFound, dst.HasEncodedObject(a))
+
+	shallow, err := dst.Shallow()
+	require.NoError(err)
+	require.Equal([]plumbing.Hash{b}, shallow)
+
+	cfg, err := dst.Config()
+	require.NoError(err)
+	require.Equal(r.Endpoints, cfg.Remotes["origin"].URLs)
+
+	r.References = append(r.References, &model.Reference{
+		Name: "refs/heads/missing",
+		Init: basicInit,
+	})
+	err = Export(tx, r, dst)
+	require.True(ErrReferenceNotArchived.Is(err))
+}
@@ -0,0 +1,365 @@
+package borges
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/src-d/borges/storage"
+	"gopkg.in/src-d/go-errors.v0"
+)
+
+var (
+	ErrInvalidForge  = errors.NewKind("invalid forge kind: %s")
+	ErrForgeResponse = errors.NewKind("unexpected response from %s: %s")
+)
+
+// ForgeKind is the kind of API a forge exposes.
+type ForgeKind string
+
+const (
+	// GitHub is the GitHub REST API v3, e.g. https://api.github.com.
+	GitHub ForgeKind = "github"
+	// GitLab is the GitLab REST API v4, e.g. https://gitlab.com/api/v4.
+	GitLab ForgeKind = "gitlab"
+	// Gitea is the Gitea (or Gogs) REST API v1, e.g. https://try.gitea.io/api/v1.
+	Gitea ForgeKind = "gitea"
+)
+
+// ForgeOptions are the options used to list the repositories of an owner in
+// a forge.
+type ForgeOptions struct {
+	// Kind is the kind of API of the forge.
+	Kind ForgeKind
+	// URL is the base URL of the API.
+	URL string
+	// Owner is the organization, group or user whose repositories will be
+	// listed. Organizations are tried first.
+	Owner string
+	// Token is an optional API token.
+	Token string
+	// Client is the HTTP client used to make requests. If it is nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// forgeRepository is a repository listed by a forge.
+type forgeRepository struct {
+	endpoints []string
+	isFork    bool
+}
+
+type forgeAPI interface {
+	// pages returns the URLs of the first page of the repositories of an
+	// organization and of a user, in the order they must be tried.
+	pages(base, owner string) []string
+	// authorize adds the token to the request.
+	authorize(req *http.Request, token string)
+	// decode reads a page of repositories.
+	decode(r io.Reader) ([]*forgeRepository, error)
+}
+
+type forgeJobIter struct {
+	storer storage.RepoStore
+	opts   ForgeOptions
+	api    forgeAPI
+
+	candidates []string
+	next       string
+	done       bool
+	waitUntil  time.Time
+	repos      []*forgeRepository
+	now        func() time.Time
+}
+
+// NewForgeJobIter returns a JobIter that returns a job for every repository
+// of an organization or user in a forge with a GitHub, GitLab or Gitea
+// compatible API. It follows the pagination of the API and, when the rate
+// limit is exhausted, returns ErrWaitForJobs until it is reset.
+func NewForgeJobIter(opts ForgeOptions, storer storage.RepoStore) (JobIter, error) {
+	var api forgeAPI
+	switch opts.Kind {
+	case GitHub:
+		api = githubAPI{}
+	case GitLab:
+		api = gitlabAPI{}
+	case Gitea:
+		api = giteaAPI{}
+	default:
+		return nil, ErrInvalidForge.New(opts.Kind)
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	candidates := api.pages(strings.TrimRight(opts.URL, "/"), opts.Owner)
+	return &forgeJobIter{
+		storer:     storer,
+		opts:       opts,
+		api:        api,
+		candidates: candidates[1:],
+		next:       candidates[0],
+		now:        time.Now,
+	}, nil
+}
+
+func (i *forgeJobIter) Next() (*Job, error) {
+	for len(i.repos) == 0 {
+		if i.done {
+			return nil, io.EOF
+		}
+
+		if i.now().Before(i.waitUntil) {
+			return nil, ErrWaitForJobs.New()
+		}
+
+		if err := i.fetch(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := i.repos[0]
+	i.repos = i.repos[1:]
+
+	isFork := r.isFork
+	ID, err := RepositoryID(r.endpoints, &isFork, i.storer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{RepositoryID: ID}, nil
+}
+
+// fetch requests the next page of repositories. If the request can be
+// retried later an error of kind ErrWaitForJobs is returned, any other error
+// finishes the iteration.
+func (i *forgeJobIter) fetch() error {
+	req, err := http.NewRequest("GET", i.next, nil)
+	if err != nil {
+		i.done = true
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if i.opts.Token != "" {
+		i.api.authorize(req, i.opts.Token)
+	}
+
+	res, err := i.opts.Client.Do(req)
+	if err != nil {
+		i.waitUntil = i.now().Add(5 * time.Second)
+		return ErrWaitForJobs.Wrap(err)
+	}
+	defer res.Body.Close()
+
+	if wait, limited := i.rateLimit(res); limited {
+		i.waitUntil = wait
+		if res.StatusCode == http.StatusForbidden ||
+			res.StatusCode == http.StatusTooManyRequests {
+			return ErrWaitForJobs.New()
+		}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound && len(i.candidates) > 0:
+		i.next = i.candidates[0]
+		i.candidates = i.candidates[1:]
+		return nil
+	case res.StatusCode != http.StatusOK:
+		i.done = true
+		return ErrForgeResponse.New(i.next, res.Status)
+	}
+
+	repos, err := i.api.decode(res.Body)
+	if err != nil {
+		i.done = true
+		return ErrForgeResponse.Wrap(err, i.next, err)
+	}
+
+	i.repos = repos
+	i.candidates = nil
+	i.next = nextPage(res)
+	i.done = i.next == "" || len(repos) == 0
+	return nil
+}
+
+// rateLimit returns the time until the requests have to wait if the rate
+// limit was exhausted.
+func (i *forgeJobIter) rateLimit(res *http.Response) (time.Time, bool) {
+	if s := res.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return i.now().Add(time.Duration(secs) * time.Second), true
+		}
+	}
+
+	remaining := firstHeader(res.Header, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	if remaining != "0" {
+		return time.Time{}, false
+	}
+
+	reset, err := strconv.ParseInt(firstHeader(res.Header, "X-RateLimit-Reset", "RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return i.now().Add(time.Minute), true
+	}
+
+	return time.Unix(reset, 0), true
+}
+
+// Close does nothing.
+func (i *forgeJobIter) Close() error {
+	return nil
+}
+
+func firstHeader(h http.Header, names ...string) string {
+	for _, n := range names {
+		if v := h.Get(n); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+var linkNextRegexp = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// nextPage returns the URL of the next page using the Link header or, if
+// there is none, the X-Next-Page header sent by GitLab.
+func nextPage(res *http.Response) string {
+	for _, link := range res.Header["Link"] {
+		if m := linkNextRegexp.FindStringSubmatch(link); m != nil {
+			return m[1]
+		}
+	}
+
+	page := res.Header.Get("X-Next-Page")
+	if page == "" {
+		return ""
+	}
+
+	u := *res.Request.URL
+	q := u.Query()
+	q.Set("page", page)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func nonEmpty(strs ...string) []string {
+	var result []string
+	for _, s := range strs {
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+type githubAPI struct{}
+
+func (githubAPI) pages(base, owner string) []string {
+	owner = url.PathEscape(owner)
+	return []string{
+		fmt.Sprintf("%s/orgs/%s/repos?per_page=100", base, owner),
+		fmt.Sprintf("%s/users/%s/repos?per_page=100&type=owner", base, owner),
+	}
+}
+
+func (githubAPI) authorize(req *http.Request, token string) {
+	req.Header.Set("Authorization", "token "+token)
+}
+
+func (githubAPI) decode(r io.Reader) ([]*forgeRepository, error) {
+	var repos []struct {
+		CloneURL string `json:"clone_url"`
+		GitURL   string `json:"git_url"`
+		Fork     bool   `json:"fork"`
+	}
+	if err := json.NewDecoder(r).Decode(&repos); err != nil {
+		return nil, err
+	}
+
+	var result []*forgeRepository
+	for _, r := range repos {
+		result = append(result, &forgeRepository{
+			endpoints: nonEmpty(r.CloneURL, r.GitURL),
+			isFork:    r.Fork,
+		})
+	}
+
+	return result, nil
+}
+
+type gitlabAPI struct{}
+
+func (gitlabAPI) pages(base, owner string) []string {
+	owner = url.PathEscape(owner)
+	return []string{
+		fmt.Sprintf("%s/groups/%s/projects?per_page=100&include_subgroups=true", base, owner),
+		fmt.Sprintf("%s/users/%s/projects?per_page=100", base, owner),
+	}
+}
+
+func (gitlabAPI) authorize(req *http.Request, token string) {
+	req.Header.Set("Private-Token", token)
+}
+
+func (gitlabAPI) decode(r io.Reader) ([]*forgeRepository, error) {
+	var repos []struct {
+		HTTPURL    string          `json:"http_url_to_repo"`
+		ForkedFrom json.RawMessage `json:"forked_from_project"`
+	}
+	if err := json.NewDecoder(r).Decode(&repos); err != nil {
+		return nil, err
+	}
+
+	var result []*forgeRepository
+	for _, r := range repos {
+		result = append(result, &forgeRepository{
+			endpoints: nonEmpty(r.HTTPURL),
+			isFork:    len(r.ForkedFrom) > 0 && string(r.ForkedFrom) != "null",
+		})
+	}
+
+	return result, nil
+}
+
+type giteaAPI struct{}
+
+func (giteaAPI) pages(base, owner string) []string {
+	owner = url.PathEscape(owner)
+	return []string{
+		fmt.Sprintf("%s/orgs/%s/repos?limit=50", base, owner),
+		fmt.Sprintf("%s/users/%s/repos?limit=50", base, owner),
+	}
+}
+
+func (giteaAPI) authorize(req *http.Request, token string) {
+	req.Header.Set("Authorization", "token "+token)
+}
+
+func (giteaAPI) decode(r io.Reader) ([]*forgeRepository, error) {
+	var repos []struct {
+		CloneURL string `json:"clone_url"`
+		Fork     bool   `json:"fork"`
+	}
+	if err := json.NewDecoder(r).Decode(&repos); err != nil {
+		return nil, err
+	}
+
+	var result []*forgeRepository
+	for _, r := range repos {
+		result = append(result, &forgeRepository{
+			endpoints: nonEmpty(r.CloneURL),
+			isFork:    r.Fork,
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package borges
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/src-d/borges/storage"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/core-retrieval.v0/test"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestForgeJobIter(t *testing.T) {
+	suite.Run(t, new(ForgeJobIterSuite))
+}
+
+type ForgeJobIterSuite struct {
+	test.Suite
+	storer storage.RepoStore
+}
+
+func (s *ForgeJobIterSuite) SetupTest() {
+	s.Suite.Setup()
+	s.storer = storage.FromDatabase(s.DB)
+}
+
+func (s *ForgeJobIterSuite) TearDownTest() {
+	s.Suite.TearDown()
+}
+
+func (s *ForgeJobIterSuite) TestGitHub() {
+	require := s.Require()
+
+	var reset time.Time
+	mux := http.NewServeMux()
+	mux.HandleFunc("/orgs/src-d/repos", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal("token foo", r.Header.Get("Authorization"))
+
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/orgs/src-d/repos?page=2>; rel="next", <http://%s/orgs/src-d/repos?page=2>; rel="last"`, r.Host, r.Host))
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			fmt.Fprint(w, `[{"clone_url": "https://github.com/src-d/a.git", "git_url": "git://github.com/src-d/a.git", "fork": false}]`)
+		case "2":
+			fmt.Fprint(w, `[{"clone_url": "https://github.com/src-d/b.git", "git_url": "git://github.com/src-d/b.git", "fork": true}]`)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	iter, err := NewForgeJobIter(ForgeOptions{
+		Kind:  GitHub,
+		URL:   srv.URL,
+		Owner: "src-d",
+		Token: "foo",
+	}, s.storer)
+	require.NoError(err)
+
+	now := time.Now()
+	reset = now.Add(time.Hour)
+	iter.(*forgeJobIter).now = func() time.Time { return now }
+
+	s.assertJob(iter, []string{"https://github.com/src-d/a.git", "git://github.com/src-d/a.git"}, false)
+
+	_, err = iter.Next()
+	require.True(ErrWaitForJobs.Is(err))
+
+	now = reset
+	s.assertJob(iter, []string{"https://github.com/src-d/b.git", "git://github.com/src-d/b.git"}, true)
+
+	_, err = iter.Next()
+	require.Equal(io.EOF, err)
+	require.NoError(iter.Close())
+}
+
+func (s *ForgeJobIterSuite) TestGitLabUser() {
+	require := s.Require()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/groups/foo/projects", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/users/foo/projects", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal("bar", r.Header.Get("Private-Token"))
+
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprint(w, `[{"http_url_to_repo": "https://gitlab.com/foo/a.git", "forked_from_project": {"id": 1}}]`)
+		case "2":
+			fmt.Fprint(w, `[{"http_url_to_repo": "https://gitlab.com/foo/b.git"}]`)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	iter, err := NewForgeJobIter(ForgeOptions{
+		Kind:  GitLab,
+		URL:   srv.URL + "/",
+		Owner: "foo",
+		Token: "bar",
+	}, s.storer)
+	require.NoError(err)
+
+	s.assertJob(iter, []string{"https://gitlab.com/foo/a.git"}, true)
+	s.assertJob(iter, []string{"https://gitlab.com/foo/b.git"}, false)
+
+	_, err = iter.Next()
+	require.Equal(io.EOF, err)
+}
+
+func (s *ForgeJobIterSuite) TestGiteaError() {
+	require := s.Require()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	iter, err := NewForgeJobIter(ForgeOptions{
+		Kind:  Gitea,
+		URL:   srv.URL,
+		Owner: "foo",
+	}, s.storer)
+	require.NoError(err)
+
+	_, err = iter.Next()
+	require.True(ErrForgeResponse.Is(err))
+
+	_, err = iter.Next()
+	require.Equal(io.EOF, err)
+}
+
+func (s *ForgeJobIterSuite) TestInvalidKind() {
+	_, err := NewForgeJobIter(ForgeOptions{Kind: "foo"}, s.storer)
+	s.True(ErrInvalidForge.Is(err))
+}
+
+func (s *ForgeJobIterSuite) assertJob(iter JobIter, endpoints []string, isFork bool) {
+	require := s.Require()
+
+	j, err := iter.Next()
+	require.NoError(err)
+
+	repo, err := s.storer.Get(kallax.ULID(j.RepositoryID))
+	require.NoError(err)
+	require.Equal(endpoints, repo.Endpoints)
+	require.NotNil(repo.IsFork)
+	require.Equal(isFork, *repo.IsFork)
+}
@@ -0,0 +1,522 @@
+package borges
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/framework.v0/lock"
+	"gopkg.in/src-d/go-billy-siva.v3"
+	"gopkg.in/src-d/go-billy.v3"
+	"gopkg.in/src-d/go-billy.v3/util"
+	"gopkg.in/src-d/go-errors.v0"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+var (
+	ErrLockLost       = errors.NewKind("lost the lock of rooted repository %s")
+	ErrObjectNotFound = errors.NewKind("object %s not found")
+)
+
+// GCStats is the result of the garbage collection of a rooted repository.
+type GCStats struct {
+	// Objects is the number of objects reachable from the references, which
+	// are kept.
+	Objects int
+	// Unreachable is the number of objects not reachable from any reference,
+	// which are pruned.
+	Unreachable int
+	// ReclaimedBytes is the difference between the size of the siva file of
+	// the rooted repository and the size of the fresh siva file with the
+	// objects that are kept.
+	ReclaimedBytes int64
+}
+
+// RootedRewriter rewrites the siva files of rooted repositories. Siva files
+// are append only, so deleting objects from a rooted repository in a
+// transaction makes its siva file grow instead. The objects that are kept are
+// written to a fresh siva file, which replaces the old one.
+type RootedRewriter struct {
+	copier repository.Copier
+	fs     billy.Filesystem
+}
+
+// NewRootedRewriter returns a RootedRewriter of the siva files copied with the
+// given copier, which must be the one used by the transactioner of the rooted
+// repositories. The siva files are written to the temporary filesystem fs.
+func NewRootedRewriter(copier repository.Copier, fs billy.Filesystem) *RootedRewriter {
+	return &RootedRewriter{copier: copier, fs: fs}
+}
+
+// rewrite calls fn with the storage of a local copy of the rooted repository
+// of the given init commit, which can be modified freely, and the storage of
+// a fresh siva file, while the rooted repository is locked the same way the
+// archiver does. The fresh siva file replaces the rooted repository if fn
+// returns true and the lock was not lost meanwhile. It returns the size of
+// the old and the fresh siva files.
+func (w *RootedRewriter) rewrite(
+	ls lock.Session,
+	init model.SHA1,
+	fn func(s storage.Storer, fresh storage.Storer) (bool, error),
+) (oldSize, freshSize int64, err error) {
+	l := ls.NewLocker(fmt.Sprintf("borges/%s", init.String()))
+	ch, err := l.Lock()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer func() {
+		if uErr := l.Unlock(); uErr != nil && err == nil {
+			err = uErr
+		}
+	}()
+
+	dir := fmt.Sprintf("%s_%d", init.String(), time.Now().UnixNano())
+	defer func() { _ = util.RemoveAll(w.fs, dir) }()
+
+	name := fmt.Sprintf("%s.siva", init.String())
+	oldPath := w.fs.Join(dir, "old.siva")
+	if err := w.copier.CopyFromRemote(name, oldPath, w.fs); err != nil {
+		return 0, 0, err
+	}
+
+	if oldSize, err = w.size(oldPath); err != nil {
+		return 0, 0, err
+	}
+
+	s, _, err := w.storage(oldPath, w.fs.Join(dir, "old"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	freshPath := w.fs.Join(dir, "fresh.siva")
+	fresh, fs, err := w.storage(freshPath, w.fs.Join(dir, "fresh"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	commit, err := fn(s, fresh)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := fs.Sync(); err != nil {
+		return 0, 0, err
+	}
+
+	if freshSize, err = w.size(freshPath); err != nil {
+		return 0, 0, err
+	}
+
+	if !commit {
+		return oldSize, freshSize, nil
+	}
+
+	select {
+	case <-ch:
+		return 0, 0, ErrLockLost.New(init.String())
+	default:
+	}
+
+	if err := w.copier.CopyToRemote(freshPath, name, w.fs); err != nil {
+		return 0, 0, err
+	}
+
+	return oldSize, freshSize, nil
+}
+
+// storage returns the storage of the given siva file, which is created if it
+// does not exist, using tmp as temporary directory.
+func (w *RootedRewriter) storage(path, tmp string) (*filesystem.Storage, sivafs.SivaFS, error) {
+	tmpFs, err := w.fs.Chroot(tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fs, err := sivafs.NewFilesystem(w.fs, path, tmpFs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s, err := filesystem.NewStorage(fs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, fs, nil
+}
+
+// size returns the size of the given file, which is 0 if it does not exist.
+func (w *RootedRewriter) size(path string) (int64, error) {
+	fi, err := w.fs.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// CollectGarbage rewrites the rooted repository of the given init commit into
+// a fresh siva file with its references and a single packfile with the
+// objects reachable from them, pruning the rest. The given tombstones of
+// deleted references are dropped before, so the objects only they reach are
+// pruned too. With dryRun, the stats are computed but the rooted repository
+// is not replaced.
+func CollectGarbage(
+	w *RootedRewriter,
+	ls lock.Session,
+	init model.SHA1,
+	tombstones []string,
+	dryRun bool,
+) (*GCStats, error) {
+	var stats *GCStats
+	oldSize, freshSize, err := w.rewrite(ls, init, func(s storage.Storer, fresh storage.Storer) (bool, error) {
+		for _, name := range tombstones {
+			if err := s.RemoveReference(plumbing.ReferenceName(name)); err != nil {
+				return false, err
+			}
+		}
+
+		var err error
+		stats, err = collectGarbage(s, fresh)
+		return !dryRun, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ReclaimedBytes = oldSize - freshSize
+	return stats, nil
+}
+
+// withRootedRepository calls fn with the storage of the rooted repository of
+// the given init commit while it is locked. The rooted repository is
+// committed if fn returns true and the lock was not lost meanwhile.
+func withRootedRepository(
+	tx repository.RootedTransactioner,
+	ls lock.Session,
+	init model.SHA1,
+	fn func(storage.Storer) (bool, error),
+) (err error) {
+	l := ls.NewLocker(fmt.Sprintf("borges/%s", init.String()))
+	ch, err := l.Lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if uErr := l.Unlock(); uErr != nil && err == nil {
+			err = uErr
+		}
+	}()
+
+	t, err := tx.Begin(plumbing.Hash(init))
+	if err != nil {
+		return err
+	}
+
+	commit, err := fn(t.Storer())
+	if err != nil || !commit {
+		if rErr := t.Rollback(); rErr != nil && err == nil {
+			err = rErr
+		}
+
+		return err
+	}
+
+	select {
+	case <-ch:
+		_ = t.Rollback()
+		return ErrLockLost.New(init.String())
+	default:
+	}
+
+	return t.Commit()
+}
+
+// collectGarbage copies to fresh the configuration, references and shallow
+// commits of s, and the objects reachable from its references. The rest of
+// objects are not copied.
+func collectGarbage(s, fresh storage.Storer) (*GCStats, error) {
+	tips, err := referencedObjects(s)
+	if err != nil {
+		return nil, err
+	}
+
+	shallow, err := shallowCommits(s)
+	if err != nil {
+		return nil, err
+	}
+
+	reachable, err := reachableFrom(s, tips, shallow)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &GCStats{Objects: len(reachable)}
+	iter, err := s.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return nil, err
+	}
+
+	unreachable := make(map[plumbing.Hash]bool)
+	err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+		h := obj.Hash()
+		if reachable[h] || unreachable[h] {
+			return nil
+		}
+
+		unreachable[h] = true
+		stats.Unreachable++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fresh.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	if err := copyReachable(s, fresh, tips, shallow); err != nil {
+		return nil, err
+	}
+
+	refs, err := s.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refs.ForEach(fresh.SetReference); err != nil {
+		return nil, err
+	}
+
+	if len(shallow) == 0 {
+		return stats, nil
+	}
+
+	commits, err := s.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := fresh.SetShallow(commits); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// copyReachable copies to fresh the objects of s reachable from the given
+// ones. They are pushed, as the archiver does, so they are written in a
+// single packfile. Shallow histories cannot be pushed, so their objects are
+// copied one by one instead.
+func copyReachable(
+	s, fresh storage.Storer,
+	tips []plumbing.Hash,
+	shallow map[plumbing.Hash]bool,
+) error {
+	if len(shallow) > 0 {
+		for _, h := range tips {
+			if _, err := copyObjects(s, fresh, h, shallow); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(tips) == 0 {
+		return nil
+	}
+
+	// the repositories are only used to push, they do not need a HEAD
+	src := &git.Repository{Storer: s}
+	dst := &git.Repository{Storer: fresh}
+	return WithInProcRepository(dst, func(url string) error {
+		const remoteName = "gc"
+		defer func() { _ = src.DeleteRemote(remoteName) }()
+		remote, err := src.CreateRemote(&config.RemoteConfig{
+			Name: remoteName,
+			URLs: []string{url},
+		})
+		if err != nil {
+			return err
+		}
+
+		err = remote.Push(&git.PushOptions{
+			RemoteName: remoteName,
+			RefSpecs:   []config.RefSpec{FetchRefSpec},
+		})
+		if err == git.NoErrAlreadyUpToDate {
+			return nil
+		}
+
+		return err
+	})
+}
+
+// reachableObjects returns the objects reachable from the references of s.
+// Parents of shallow commits are not followed, as they are not stored.
+func reachableObjects(s storage.Storer) (map[plumbing.Hash]bool, error) {
+	shallow, err := shallowCommits(s)
+	if err != nil {
+		return nil, err
+	}
+
+	tips, err := referencedObjects(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return reachableFrom(s, tips, shallow)
+}
+
+// referencedObjects returns the objects the hash references of s point to.
+func referencedObjects(s storer.ReferenceStorer) ([]plumbing.Hash, error) {
+	refs, err := s.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []plumbing.Hash
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			tips = append(tips, ref.Hash())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tips, nil
+}
+
+func shallowCommits(s storer.ShallowStorer) (map[plumbing.Hash]bool, error) {
+	hashes, err := s.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	shallow := make(map[plumbing.Hash]bool, len(hashes))
+	for _, h := range hashes {
+		shallow[h] = true
+	}
+
+	return shallow, nil
+}
+
+// reachableFrom returns the objects of s reachable from the given ones,
+// without following the parents of shallow commits. It fails with
+// ErrObjectNotFound if any of them is missing.
+func reachableFrom(
+	s storer.EncodedObjectStorer,
+	tips []plumbing.Hash,
+	shallow map[plumbing.Hash]bool,
+) (map[plumbing.Hash]bool, error) {
+	seen := make(map[plumbing.Hash]bool)
+	if err := markReachable(s, tips, shallow, seen); err != nil {
+		return nil, err
+	}
+
+	return seen, nil
+}
+
+// markReachable adds to seen the objects of s reachable from the given ones,
+// as reachableFrom does. Objects already in seen, and the ones reachable from
+// them, are not walked again. Nothing is added to seen if it fails.
+func markReachable(
+	s storer.EncodedObjectStorer,
+	tips []plumbing.Hash,
+	shallow map[plumbing.Hash]bool,
+	seen map[plumbing.Hash]bool,
+) error {
+	pending := append([]plumbing.Hash(nil), tips...)
+	walked := make(map[plumbing.Hash]bool)
+	isSeen := func(h plumbing.Hash) bool { return seen[h] || walked[h] }
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if isSeen(h) {
+			continue
+		}
+
+		obj, err := s.EncodedObject(plumbing.AnyObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			return ErrObjectNotFound.New(h.String())
+		} else if err != nil {
+			return err
+		}
+
+		walked[h] = true
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			c, err := object.DecodeCommit(s, obj)
+			if err != nil {
+				return err
+			}
+
+			pending = append(pending, c.TreeHash)
+			if !shallow[h] {
+				pending = append(pending, c.ParentHashes...)
+			}
+		case plumbing.TreeObject:
+			t, err := object.DecodeTree(s, obj)
+			if err != nil {
+				return err
+			}
+
+			for _, e := range t.Entries {
+				switch e.Mode {
+				case filemode.Submodule:
+				case filemode.Dir:
+					pending = append(pending, e.Hash)
+				default:
+					if isSeen(e.Hash) {
+						continue
+					}
+
+					err := s.HasEncodedObject(e.Hash)
+					if err == plumbing.ErrObjectNotFound {
+						return ErrObjectNotFound.New(e.Hash.String())
+					} else if err != nil {
+						return err
+					}
+
+					walked[e.Hash] = true
+				}
+			}
+		case plumbing.TagObject:
+			t, err := object.DecodeTag(s, obj)
+			if err != nil {
+				return err
+			}
+
+			pending = append(pending, t.Target)
+		}
+	}
+
+	for h := range walked {
+		seen[h] = true
+	}
+
+	return nil
+}
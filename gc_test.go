@@ -0,0 +1,186 @@
+package borges
+
+import (
+	"testing"
+	"time"
+
+	"github.com/src-d/go-git-fixtures"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/framework.v0/lock"
+	"gopkg.in/src-d/go-billy.v3/memfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	fs := fixtures.Basic().One().DotGit()
+	sto, err := filesystem.NewStorage(fs)
+	require.NoError(err)
+
+	obj := sto.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	require.NoError(err)
+	_, err = w.Write([]byte("unreachable"))
+	require.NoError(err)
+	require.NoError(w.Close())
+	blob, err := sto.SetEncodedObject(obj)
+	require.NoError(err)
+
+	fresh, err := filesystem.NewStorage(memfs.New())
+	require.NoError(err)
+
+	stats, err := collectGarbage(sto, fresh)
+	require.NoError(err)
+	require.Equal(&GCStats{Objects: 31, Unreachable: 1}, stats)
+	require.Equal(plumbing.ErrObjectNotFound, fresh.HasEncodedObject(blob))
+
+	objects, err := reachableObjects(fresh)
+	require.NoError(err)
+	require.Len(objects, stats.Objects)
+
+	requireSameReferences(t, sto, fresh)
+}
+
+func TestCollectGarbage_Shallow(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	fs := fixtures.Basic().One().DotGit()
+	sto, err := filesystem.NewStorage(fs)
+	require.NoError(err)
+
+	for _, name := range []string{"refs/heads/branch", "refs/remotes/origin/branch", "refs/tags/v1.0.0"} {
+		require.NoError(sto.RemoveReference(plumbing.ReferenceName(name)))
+	}
+
+	master, err := sto.Reference(plumbing.Master)
+	require.NoError(err)
+	c, err := object.GetCommit(sto, master.Hash())
+	require.NoError(err)
+	require.NoError(sto.SetShallow([]plumbing.Hash{c.Hash}))
+
+	fresh, err := filesystem.NewStorage(memfs.New())
+	require.NoError(err)
+
+	stats, err := collectGarbage(sto, fresh)
+	require.NoError(err)
+	require.NotZero(stats.Unreachable)
+
+	require.NoError(fresh.HasEncodedObject(c.Hash))
+	require.NoError(fresh.HasEncodedObject(c.TreeHash))
+	require.Equal(plumbing.ErrObjectNotFound, fresh.HasEncodedObject(c.ParentHashes[0]))
+
+	shallow, err := fresh.Shallow()
+	require.NoError(err)
+	require.Equal([]plumbing.Hash{c.Hash}, shallow)
+
+	objects, err := reachableObjects(fresh)
+	require.NoError(err)
+	require.Len(objects, stats.Objects)
+}
+
+func TestCollectGarbage_Siva(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	remote := memfs.New()
+	copier := repository.NewLocalCopier(remote)
+	tx := repository.NewSivaRootedTransactioner(copier, memfs.New())
+	ls, err := lock.NewLocal().NewSession(&lock.SessionConfig{TTL: 10 * time.Second})
+	require.NoError(err)
+	defer ls.Close()
+
+	src, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	// the rooted repository gets all the objects of the fixture, but only
+	// the master reference, so the objects of the rest are unreachable
+	init := model.NewSHA1("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	err = withRootedRepository(tx, ls, init, func(s storage.Storer) (bool, error) {
+		iter, err := src.IterEncodedObjects(plumbing.AnyObject)
+		if err != nil {
+			return false, err
+		}
+
+		err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+			_, err := s.SetEncodedObject(obj)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+
+		master, err := src.Reference(plumbing.Master)
+		if err != nil {
+			return false, err
+		}
+
+		return true, s.SetReference(master)
+	})
+	require.NoError(err)
+
+	name := init.String() + ".siva"
+	before, err := remote.Stat(name)
+	require.NoError(err)
+
+	w := NewRootedRewriter(copier, memfs.New())
+	stats, err := CollectGarbage(w, ls, init, nil, true)
+	require.NoError(err)
+	require.NotZero(stats.Unreachable)
+	require.True(stats.ReclaimedBytes > 0)
+
+	fi, err := remote.Stat(name)
+	require.NoError(err)
+	require.Equal(before.Size(), fi.Size())
+
+	stats, err = CollectGarbage(w, ls, init, nil, false)
+	require.NoError(err)
+
+	after, err := remote.Stat(name)
+	require.NoError(err)
+	require.True(after.Size() < before.Size())
+	require.Equal(before.Size()-after.Size(), stats.ReclaimedBytes)
+
+	err = withRootedRepository(tx, ls, init, func(s storage.Storer) (bool, error) {
+		objects, err := reachableObjects(s)
+		require.NoError(err)
+		require.Len(objects, stats.Objects)
+		requireSameReferences(t, src, s, plumbing.Master)
+		return false, nil
+	})
+	require.NoError(err)
+}
+
+// requireSameReferences checks that b has the references of a with the given
+// names, or all of them if none is given.
+func requireSameReferences(t *testing.T, a, b storer.ReferenceStorer, names ...plumbing.ReferenceName) {
+	if len(names) == 0 {
+		iter, err := a.IterReferences()
+		require.NoError(t, err)
+		require.NoError(t, iter.ForEach(func(ref *plumbing.Reference) error {
+			names = append(names, ref.Name())
+			return nil
+		}))
+	}
+
+	for _, name := range names {
+		expected, err := a.Reference(name)
+		require.NoError(t, err)
+
+		ref, err := b.Reference(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, ref)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"math/rand"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"time"
 
@@ -17,26 +18,65 @@ import (
 	"gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
 	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage"
 	"gopkg.in/src-d/go-git.v4/storage/filesystem"
 	"gopkg.in/src-d/go-git.v4/storage/memory"
+	"gopkg.in/src-d/go-kallax.v1"
 )
 
 const (
 	FetchRefSpec = config.RefSpec("refs/*:refs/*")
-	FetchHEAD    = config.RefSpec("HEAD:refs/heads/HEAD")
 )
 
 type TemporaryRepository interface {
 	io.Closer
 	Referencer
+	// Head returns the name of the reference HEAD points to in the remote
+	// repository. The name is empty if HEAD is detached or the repository
+	// is empty, and the returned bool is false if it could not be read.
+	Head() (plumbing.ReferenceName, bool)
+	// Shallow returns the shallow commits of the repository, whose parents
+	// were not fetched. It is empty if the whole history was fetched.
+	Shallow() ([]plumbing.Hash, error)
+	// IsAncestor returns whether the commit a is reachable from the commit
+	// b in the fetched history. It is false if any of them was not fetched.
+	IsAncestor(a, b plumbing.Hash) (bool, error)
 	Push(ctx context.Context, url string, refspecs []config.RefSpec) error
+	// CopyTo updates the references of dst as Push would do, copying the
+	// objects directly to its storage. Unlike Push, it can be used with
+	// shallow repositories, and the shallow commits copied are added to the
+	// shallow commits of dst.
+	CopyTo(dst storage.Storer, refspecs []config.RefSpec) error
 }
 
 type TemporaryCloner interface {
-	Clone(ctx context.Context, id, url string) (TemporaryRepository, error)
+	Clone(ctx context.Context, id, url string, opts *CloneOptions) (TemporaryRepository, error)
+}
+
+// CloneOptions are the options used to clone a repository into a temporary
+// repository.
+type CloneOptions struct {
+	// KnownReferences are the references of the repository from its last
+	// fetch. The roots stored for them are used as a cache, so only the
+	// commits added since then are walked to find the roots of the new
+	// references.
+	KnownReferences []*model.Reference
+	// RefFilter selects the references fetched. Exclude patterns are not
+	// applied to the fetch, so the references of the temporary repository
+	// still need to be filtered.
+	RefFilter *RefFilter
+	// Depth limits the fetch to the given number of commits from the tip of
+	// each reference. If it is 0 the history is not limited.
+	Depth int
+	// Since limits the history to the commits after the given date. The
+	// whole history is fetched, as the git protocol implementation does not
+	// support it, and then it is cut as git does with --shallow-since. If it
+	// is zero the history is not limited.
+	Since time.Time
 }
 
 // NewGitReferencer takes a *git.Repository and returns a Referencer that
@@ -44,11 +84,26 @@ type TemporaryCloner interface {
 // that do not point to commits (possibly through a tag) are silently ignored.
 // It might return an error if any operation fails in the underlying repository.
 func NewGitReferencer(r *git.Repository) Referencer {
-	return gitReferencer{r}
+	return gitReferencer{Repository: r}
+}
+
+// NewIncrementalGitReferencer returns a Referencer like NewGitReferencer, but
+// the roots of the commits pointed by the given known references, which are
+// usually the ones obtained from a previous fetch of the same repository, are
+// not computed again. The history behind these commits is not walked to find
+// their roots, nor their init commits.
+func NewIncrementalGitReferencer(r *git.Repository, known []*model.Reference) Referencer {
+	return gitReferencer{Repository: r, known: known}
 }
 
 type gitReferencer struct {
 	*git.Repository
+	known []*model.Reference
+	// graph is used to get the parents of commits, if it is not nil
+	graph commitParents
+	// filter, if it is not nil, selects the references to return, so the
+	// roots of the others are not computed
+	filter func(plumbing.ReferenceName) bool
 }
 
 func (r gitReferencer) References() ([]*model.Reference, error) {
@@ -57,14 +112,22 @@ func (r gitReferencer) References() ([]*model.Reference, error) {
 		return nil, err
 	}
 
+	finder, err := r.newRootsFinder()
+	if err != nil {
+		return nil, err
+	}
+
 	var refs []*model.Reference
-	var seenRoots = make(map[plumbing.Hash][]model.SHA1)
 	return refs, iter.ForEach(func(ref *plumbing.Reference) error {
 		//TODO: add tags support
 		if ref.Type() != plumbing.HashReference || ref.Name().IsRemote() {
 			return nil
 		}
 
+		if r.filter != nil && !r.filter(ref.Name()) {
+			return nil
+		}
+
 		c, err := ResolveCommit(r.Repository, plumbing.NewHash(ref.Hash().String()))
 		if err == ErrReferencedObjectTypeNotSupported {
 			return nil
@@ -74,7 +137,12 @@ func (r gitReferencer) References() ([]*model.Reference, error) {
 			return err
 		}
 
-		roots, err := rootCommits(r.Repository, c, seenRoots)
+		roots, err := finder.find(c.Hash)
+		if err != nil {
+			return err
+		}
+
+		init, err := finder.init(c.Hash)
 		if err != nil {
 			return err
 		}
@@ -82,7 +150,7 @@ func (r gitReferencer) References() ([]*model.Reference, error) {
 		refs = append(refs, &model.Reference{
 			Name:  ref.Name().String(),
 			Hash:  model.NewSHA1(ref.Hash().String()),
-			Init:  roots[0],
+			Init:  init,
 			Roots: roots,
 			Time:  c.Committer.When,
 		})
@@ -90,124 +158,30 @@ func (r gitReferencer) References() ([]*model.Reference, error) {
 	})
 }
 
-type commitFrame struct {
-	cursor int
-	hashes []plumbing.Hash
-}
-
-func newCommitFrame(hashes ...plumbing.Hash) *commitFrame {
-	return &commitFrame{0, hashes}
-}
-
-// lastHash returns the last visited hash, assuming one has at least been
-// visited before. That is, this should not be called before incrementing
-// the cursor for the first time.
-func (f *commitFrame) lastHash() plumbing.Hash {
-	return f.hashes[f.cursor-1]
-}
-
-// rootCommits returns the commits with no parents reachable from `start`.
-// To do so, all the commits are iterated using a stack where frames are
-// the parent commits of the last visited hash of the previous frame.
-//
-// As we go down, if a commit has parents, we add a new frame to the stack
-// with these parents as hashes.
-// If the commit does not have parents its a root, so we add it to the list
-// found roots and keep going.
-//
-// If we have not visited all the hashes in the current frame it means we have
-// to switch branches. That means caching the roots found so far for the last
-// visited commit in the frame and reset the roots so we can find the ones
-// for the new root. If these branches converge nothing happens, that
-// point will be cached and we'll load them from the cache and continue.
-// If we have visited all the hashes in the current frame we cache the found
-// roots and move all the roots found in all the hashes in the frame to the
-// last visited hash of the previous frame. The found roots now will be the
-// same roots we pushed to the previous frame.
-//
-// After repeating this process, when we get to the root frame, we just have to
-// return the roots cached for it, which will be the roots of all reachable
-// commits from the start.
-func rootCommits(
-	r *git.Repository,
-	start *object.Commit,
-	seenRoots map[plumbing.Hash][]model.SHA1,
-) ([]model.SHA1, error) {
-	stack := []*commitFrame{
-		newCommitFrame(start.Hash),
-	}
-	store := r.Storer
-	var roots []model.SHA1
-
-	for {
-		current := len(stack) - 1
-		if current < 0 {
-			return nil, nil
-		}
-
-		frame := stack[current]
-		if len(frame.hashes) <= frame.cursor {
-			roots = deduplicateHashes(roots)
-			seenRoots[frame.lastHash()] = roots
-
-			// root frame is guaranteed to have just one hash
-			if current == 0 {
-				return seenRoots[frame.lastHash()], nil
-			}
-
-			// move all the roots of all the branches to the last visited
-			// hash of the previous frame
-			prevFrame := stack[current-1]
-
-			prevHash := prevFrame.lastHash()
-			for _, h := range frame.hashes {
-				seenRoots[prevHash] = append(seenRoots[prevHash], seenRoots[h]...)
-			}
-
-			roots = deduplicateHashes(seenRoots[prevHash])
-			seenRoots[prevHash] = roots
-
-			stack = stack[:current]
-			continue
-		} else if frame.cursor > 0 {
-			// if the frame cursor is bigger than 0 and we're not done with it
-			// cache the roots of the previous hash and start anew with the
-			// next branch.
-			seenRoots[frame.lastHash()] = deduplicateHashes(roots)
-			roots = nil
-		}
-
-		frame.cursor++
-		hash := frame.lastHash()
-		// use cached roots for this commit, if any
-		if cachedRoots, ok := seenRoots[hash]; ok {
-			roots = cachedRoots
-			continue
-		}
-
-		var c *object.Commit
-		if hash != start.Hash {
-			obj, err := store.EncodedObject(plumbing.CommitObject, hash)
-			if err != nil {
-				return nil, err
-			}
-
-			do, err := object.DecodeObject(store, obj)
-			if err != nil {
-				return nil, err
-			}
+// newRootsFinder returns a rootsFinder for the repository that already knows
+// the roots of the commits pointed by the known references. The shallow
+// commits of the repository are roots.
+func (r gitReferencer) newRootsFinder() (*rootsFinder, error) {
+	var graph commitParents = objectParents{r.Repository.Storer}
+	if r.graph != nil {
+		graph = r.graph
+	}
 
-			c = do.(*object.Commit)
-		} else {
-			c = start
-		}
+	graph, err := newShallowParents(graph, r.Repository.Storer)
+	if err != nil {
+		return nil, err
+	}
 
-		if c.NumParents() > 0 {
-			stack = append(stack, newCommitFrame(c.ParentHashes...))
-		} else {
-			roots = append(roots, model.SHA1(c.Hash))
+	finder := newRootsFinder(graph)
+	for _, ref := range r.known {
+		// stored init commits are not trusted, as older versions did not
+		// follow first parents, so they are always computed again
+		if len(ref.Roots) > 0 {
+			finder.add(plumbing.Hash(ref.Hash), ref.Roots)
 		}
 	}
+
+	return finder, nil
 }
 
 // ResolveCommit gets the hash of a commit that is referenced by a tag, per example.
@@ -244,12 +218,20 @@ type temporaryRepository struct {
 	Repository     *git.Repository
 	TempFilesystem billy.Filesystem
 	TempPath       string
+	graph          *lazyCommitGraph
+	head           plumbing.ReferenceName
+	headKnown      bool
 }
 
 func (b *temporaryRepositoryBuilder) Clone(
 	ctx context.Context,
 	id, endpoint string,
+	opts *CloneOptions,
 ) (TemporaryRepository, error) {
+	if opts == nil {
+		opts = &CloneOptions{}
+	}
+
 	dir := filepath.Join("local_repos", id,
 		strconv.FormatInt(time.Now().UnixNano(), 10))
 
@@ -278,11 +260,34 @@ func (b *temporaryRepositoryBuilder) Clone(
 		return nil, err
 	}
 
+	// the references advertised by the remote give its HEAD and the names
+	// of the references to fetch when the filter is not a set of refspecs
+	adv, advErr := advertisedReferences(ctx, endpoint)
+	if advErr != nil && advErr != transport.ErrEmptyRemoteRepository &&
+		(ctx.Err() != nil || opts.RefFilter.byName()) {
+		_ = util.RemoveAll(b.TempFilesystem, dir)
+		return nil, advErr
+	}
+
 	o := &git.FetchOptions{
-		RefSpecs: []config.RefSpec{FetchRefSpec, FetchHEAD},
+		RefSpecs: opts.RefFilter.RefSpecs(referenceNames(adv)),
+		// tags are fetched by the refspecs when they are selected
+		Tags:  git.NoTags,
+		Depth: opts.Depth,
 	}
-	err = remote.FetchContext(ctx, o)
-	if err == git.NoErrAlreadyUpToDate || err == transport.ErrEmptyRemoteRepository {
+
+	switch {
+	case advErr == transport.ErrEmptyRemoteRepository:
+		err = advErr
+	case len(o.RefSpecs) == 0:
+		// none of the references is selected, there is nothing to fetch
+		err = git.NoErrAlreadyUpToDate
+	default:
+		err = remote.FetchContext(ctx, o)
+	}
+
+	empty := err == transport.ErrEmptyRemoteRepository
+	if err == git.NoErrAlreadyUpToDate || empty {
 		r, err = git.Init(memory.NewStorage(), nil)
 	}
 
@@ -291,12 +296,193 @@ func (b *temporaryRepositoryBuilder) Clone(
 		return nil, err
 	}
 
-	return &temporaryRepository{
-		Referencer:     NewGitReferencer(r),
+	tr := &temporaryRepository{
 		Repository:     r,
 		TempFilesystem: b.TempFilesystem,
 		TempPath:       dir,
-	}, nil
+		// an empty repository has no HEAD
+		headKnown: empty,
+	}
+
+	if !empty {
+		if advErr != nil {
+			log15.Warn("unable to get remote HEAD", "id", id, "error", advErr)
+		} else {
+			tr.head = remoteHead(adv)
+			tr.headKnown = true
+		}
+	}
+
+	refs := gitReferencer{Repository: r, known: opts.KnownReferences}
+	if r.Storer != s {
+		// empty or up to date repository, there is nothing to index
+		tr.Referencer = refs
+		return tr, nil
+	}
+
+	if !opts.Since.IsZero() {
+		if err := limitHistory(r, opts.Since); err != nil {
+			_ = util.RemoveAll(b.TempFilesystem, dir)
+			return nil, err
+		}
+	}
+
+	if tr.head != "" {
+		err := s.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, tr.head))
+		if err != nil {
+			_ = util.RemoveAll(b.TempFilesystem, dir)
+			return nil, err
+		}
+	}
+
+	// long walks of the history use a commit graph instead of decoding
+	// commits, it is only built if they are needed
+	tr.graph = newLazyCommitGraph(s)
+	refs.graph = tr.graph
+
+	tr.Referencer = refs
+	return tr, nil
+}
+
+// advertisedReferences returns the references advertised by the remote
+// repository. The session is closed if ctx is done before they are
+// advertised.
+func advertisedReferences(ctx context.Context, endpoint string) (memory.ReferenceStorage, error) {
+	ep, err := transport.NewEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.NewClient(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := c.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		ar  *packp.AdvRefs
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		ar, err := sess.AdvertisedReferences()
+		done <- result{ar, err}
+	}()
+
+	var res result
+	select {
+	case <-ctx.Done():
+		_ = sess.Close()
+		return nil, ctx.Err()
+	case res = <-done:
+	}
+
+	if err := sess.Close(); err != nil && res.err == nil {
+		res.err = err
+	}
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	return res.ar.AllReferences()
+}
+
+// referenceNames returns the sorted names of the given references.
+func referenceNames(refs memory.ReferenceStorage) []plumbing.ReferenceName {
+	var names []plumbing.ReferenceName
+	for name := range refs {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// remoteHead returns the name of the reference HEAD points to in the given
+// advertised references. It is empty if the remote has a detached HEAD or
+// does not advertise it.
+func remoteHead(refs memory.ReferenceStorage) plumbing.ReferenceName {
+	head, err := refs.Reference(plumbing.HEAD)
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		return ""
+	}
+
+	return head.Target()
+}
+
+func (r *temporaryRepository) Head() (plumbing.ReferenceName, bool) {
+	return r.head, r.headKnown
+}
+
+func (r *temporaryRepository) Shallow() ([]plumbing.Hash, error) {
+	return r.Repository.Storer.Shallow()
+}
+
+func (r *temporaryRepository) IsAncestor(a, b plumbing.Hash) (bool, error) {
+	graph := r.graph
+	if graph == nil {
+		graph = newLazyCommitGraph(r.Repository.Storer)
+		defer func() { _ = graph.Close() }()
+	}
+
+	return graphAncestry{graph}.IsAncestor(a, b)
+}
+
+func (r *temporaryRepository) CopyTo(dst storage.Storer, refspecs []config.RefSpec) error {
+	commits, err := r.Shallow()
+	if err != nil {
+		return err
+	}
+
+	shallow := make(map[plumbing.Hash]bool, len(commits))
+	for _, h := range commits {
+		shallow[h] = true
+	}
+
+	var copied []plumbing.Hash
+	for _, rs := range refspecs {
+		name := plumbing.ReferenceName(rs.Dst(""))
+		if rs.IsDelete() {
+			if err := dst.RemoveReference(name); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		ref, err := r.Repository.Storer.Reference(plumbing.ReferenceName(rs.Src()))
+		if err != nil {
+			return err
+		}
+
+		cs, err := copyObjects(r.Repository.Storer, dst, ref.Hash(), shallow)
+		if err != nil {
+			return err
+		}
+
+		copied = append(copied, cs...)
+		err = dst.SetReference(plumbing.NewHashReference(name, ref.Hash()))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(copied) == 0 {
+		return nil
+	}
+
+	old, err := dst.Shallow()
+	if err != nil {
+		return err
+	}
+
+	return dst.SetShallow(append(old, copied...))
 }
 
 func (r *temporaryRepository) Push(
@@ -323,6 +509,13 @@ func (r *temporaryRepository) Push(
 
 func (r *temporaryRepository) Close() error {
 	r.Repository = nil
+	if r.graph != nil {
+		if err := r.graph.Close(); err != nil {
+			_ = util.RemoveAll(r.TempFilesystem, r.TempPath)
+			return err
+		}
+	}
+
 	return util.RemoveAll(r.TempFilesystem, r.TempPath)
 }
 
@@ -362,6 +555,28 @@ func StoreConfig(r *git.Repository, mr *model.Repository) error {
 	return storer.SetConfig(c)
 }
 
+// StoreHead stores the HEAD of the repository with the given ID in a rooted
+// repository, as the symbolic reference refs/remotes/<id>/HEAD pointing to
+// the reference head of that repository, <head>/<id>. If that reference is
+// not in the rooted repository the symbolic reference is removed, as HEAD is
+// only kept in the rooted repository with the reference it points to.
+func StoreHead(r *git.Repository, id kallax.ULID, head plumbing.ReferenceName) error {
+	name := plumbing.ReferenceName(fmt.Sprintf("refs/remotes/%s/HEAD", id))
+	if head != "" {
+		target := plumbing.ReferenceName(fmt.Sprintf("%s/%s", head, id))
+		_, err := r.Storer.Reference(target)
+		if err == nil {
+			return r.Storer.SetReference(plumbing.NewSymbolicReference(name, target))
+		}
+
+		if err != plumbing.ErrReferenceNotFound {
+			return err
+		}
+	}
+
+	return r.Storer.RemoveReference(name)
+}
+
 func updateConfigRemote(c *config.Config, id string, mr *model.Repository) bool {
 	remote, ok := c.Remotes[id]
 	if ok {
@@ -420,28 +635,3 @@ func stringSliceEqual(a, b []string) bool {
 
 	return true
 }
-
-func deduplicateHashes(hashes []model.SHA1) []model.SHA1 {
-	var set hashSet
-	for _, h := range hashes {
-		set.add(h)
-	}
-	return []model.SHA1(set)
-}
-
-type hashSet []model.SHA1
-
-func (hs *hashSet) add(hash model.SHA1) {
-	if !hs.contains(hash) {
-		*hs = append(*hs, hash)
-	}
-}
-
-func (hs hashSet) contains(hash model.SHA1) bool {
-	for _, h := range hs {
-		if h == hash {
-			return true
-		}
-	}
-	return false
-}
@@ -50,6 +50,60 @@ func TestNewGitReferencer(t *testing.T) {
 	}
 }
 
+func TestNewIncrementalGitReferencer(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+
+	for _, ct := range ChangesFixtures {
+		t.Run(ct.TestName, func(t *testing.T) {
+			require := require.New(t)
+			r, err := ct.NewRepository()
+			require.NoError(err)
+
+			expected, err := NewGitReferencer(r).References()
+			require.NoError(err)
+
+			refs, err := NewIncrementalGitReferencer(r, expected).References()
+			require.NoError(err)
+			require.Equal(refsByName(expected), refsByName(refs))
+		})
+	}
+}
+
+func TestNewIncrementalGitReferencer_UsesKnownRoots(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, memfs.New())
+	require.NoError(err)
+
+	master, err := r.Reference(plumbing.Master, false)
+	require.NoError(err)
+
+	fakeRoot := model.NewSHA1("0000000000000000000000000000000000000001")
+	known := []*model.Reference{{
+		Name:  "refs/heads/foo",
+		Hash:  model.SHA1(master.Hash()),
+		Init:  fakeRoot,
+		Roots: []model.SHA1{fakeRoot},
+	}}
+
+	refs, err := NewIncrementalGitReferencer(r, known).References()
+	require.NoError(err)
+
+	ref, ok := refsByName(refs)[plumbing.Master.String()]
+	require.True(ok)
+	require.Equal([]model.SHA1{fakeRoot}, ref.Roots)
+
+	// init commits are always computed following first parents
+	require.Equal("b029517f6300c2da0f4b651b8642506cd6aaf45d", ref.Init.String())
+	require.Equal([]model.SHA1{fakeRoot}, known[0].Roots)
+}
+
 func TestNewGitReferencer_ReferenceToTagObject(t *testing.T) {
 	fixtures.Init()
 	defer fixtures.Clean()
@@ -107,16 +161,120 @@ func (s *TemporaryClonerSuite) TestCloneRepository() {
 
 func (s *TemporaryClonerSuite) testBasicRepository(url string) {
 	require := s.Require()
-	gr, err := s.cloner.Clone(context.TODO(), "foo", url)
+	gr, err := s.cloner.Clone(context.TODO(), "foo", url, nil)
 	require.NoError(err)
 	refs, err := gr.References()
 	require.NoError(err)
-	// len(refs) = FetchRefSpec + FetchHEAD = x + 1
-	require.Len(refs, 6)
+	require.Len(refs, 5)
+	head, ok := gr.Head()
+	require.True(ok)
+	require.Equal(plumbing.Master, head)
 	err = gr.Close()
 	require.NoError(err)
 }
 
+func (s *TemporaryClonerSuite) TestCloneHead() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, memfs.New())
+	require.NoError(err)
+
+	err = WithInProcRepository(r, func(url string) error {
+		gr, err := s.cloner.Clone(context.TODO(), "foo", url, nil)
+		require.NoError(err)
+		defer func() { require.NoError(gr.Close()) }()
+
+		head, ok := gr.Head()
+		require.True(ok)
+		require.Equal(plumbing.Master, head)
+
+		refs, err := gr.References()
+		require.NoError(err)
+		for _, ref := range refs {
+			require.NotEqual("refs/heads/HEAD", ref.Name)
+		}
+
+		return nil
+	})
+	require.NoError(err)
+}
+
+func (s *TemporaryClonerSuite) TestCloneRefFilter() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, memfs.New())
+	require.NoError(err)
+
+	err = WithInProcRepository(r, func(url string) error {
+		gr, err := s.cloner.Clone(context.TODO(), "foo", url, &CloneOptions{
+			RefFilter: &RefFilter{Include: []string{"refs/heads/*"}},
+		})
+		require.NoError(err)
+		defer func() { require.NoError(gr.Close()) }()
+
+		refs, err := gr.References()
+		require.NoError(err)
+		require.Len(refs, 2)
+		for _, ref := range refs {
+			require.Contains(ref.Name, "refs/heads/")
+		}
+
+		return nil
+	})
+	require.NoError(err)
+}
+
+func (s *TemporaryClonerSuite) TestCloneRefFilterExclude() {
+	require := s.Require()
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, memfs.New())
+	require.NoError(err)
+
+	err = WithInProcRepository(r, func(url string) error {
+		gr, err := s.cloner.Clone(context.TODO(), "foo", url, &CloneOptions{
+			RefFilter: &RefFilter{
+				Exclude: []string{"refs/heads/branch", "refs/remotes/*"},
+			},
+		})
+		require.NoError(err)
+		defer func() { require.NoError(gr.Close()) }()
+
+		// excluded references are not requested, neither are the objects
+		// only reachable from them
+		tr := gr.(*temporaryRepository)
+		_, err = tr.Repository.Reference("refs/heads/branch", false)
+		require.Equal(plumbing.ErrReferenceNotFound, err)
+		_, err = tr.Repository.Reference("refs/remotes/origin/branch", false)
+		require.Equal(plumbing.ErrReferenceNotFound, err)
+		_, err = tr.Repository.CommitObject(plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+		require.Equal(plumbing.ErrObjectNotFound, err)
+
+		refs, err := gr.References()
+		require.NoError(err)
+		require.Len(refs, 2)
+		for _, ref := range refs {
+			require.NotEqual("refs/heads/branch", ref.Name)
+			require.NotContains(ref.Name, "refs/remotes/")
+		}
+
+		head, ok := gr.Head()
+		require.True(ok)
+		require.Equal(plumbing.Master, head)
+
+		return nil
+	})
+	require.NoError(err)
+}
+
 func (s *TemporaryClonerSuite) TestCloneEmptyRepository() {
 	s.testEmptyRepository("https://github.com/git-fixtures/empty.git")
 	s.testEmptyRepository("git://github.com/git-fixtures/empty.git")
@@ -124,7 +282,7 @@ func (s *TemporaryClonerSuite) TestCloneEmptyRepository() {
 
 func (s *TemporaryClonerSuite) testEmptyRepository(url string) {
 	require := s.Require()
-	gr, err := s.cloner.Clone(context.TODO(), "foo", url)
+	gr, err := s.cloner.Clone(context.TODO(), "foo", url, nil)
 	require.NoError(err)
 	refs, err := gr.References()
 	require.NoError(err)
@@ -140,13 +298,37 @@ func (s *TemporaryClonerSuite) TestCloneNonExistentRepository() {
 
 func (s *TemporaryClonerSuite) testNonExistentRepository(url string) {
 	require := s.Require()
-	gr, err := s.cloner.Clone(context.TODO(), "foo", url)
+	gr, err := s.cloner.Clone(context.TODO(), "foo", url, nil)
 	require.True(err == transport.ErrAuthenticationRequired ||
 		err == transport.ErrRepositoryNotFound)
 
 	require.Nil(gr)
 }
 
+func TestStoreHead(t *testing.T) {
+	require := require.New(t)
+
+	r, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(err)
+
+	id := kallax.NewULID()
+	head := plumbing.ReferenceName("refs/remotes/" + id.String() + "/HEAD")
+	target := plumbing.ReferenceName("refs/heads/main/" + id.String())
+	hash := plumbing.NewHash("0000000000000000000000000000000000000001")
+	require.NoError(r.Storer.SetReference(plumbing.NewHashReference(target, hash)))
+
+	require.NoError(StoreHead(r, id, "refs/heads/main"))
+	ref, err := r.Storer.Reference(head)
+	require.NoError(err)
+	require.Equal(plumbing.SymbolicReference, ref.Type())
+	require.Equal(target, ref.Target())
+
+	// HEAD points to a reference stored in another rooted repository
+	require.NoError(StoreHead(r, id, "refs/heads/other"))
+	_, err = r.Storer.Reference(head)
+	require.Equal(plumbing.ErrReferenceNotFound, err)
+}
+
 func TestStoreConfig(t *testing.T) {
 	require := require.New(t)
 
@@ -202,19 +384,16 @@ func TestRootCommits_NoSkipParents(t *testing.T) {
 	r, err := fixtures.NewRepository()
 	require.NoError(err)
 
-	start, err := r.CommitObject(plumbing.Hash(branchOneHash))
-	require.NoError(err)
-
-	roots, err := rootCommits(
-		r,
-		start,
-		map[plumbing.Hash][]model.SHA1{
-			plumbing.NewHash("a511fa38233896f50bcc8a5f8d0f30b872484852"): {
-				model.NewSHA1("8ec19d64748c54c6d047f30c81b4c444a8232b41"),
-				model.NewSHA1("04fffad6eacd4512554cb22ca3a0d6b8a38a96cc"),
-			},
+	finder := newRootsFinder(objectParents{r.Storer})
+	finder.add(
+		plumbing.NewHash("a511fa38233896f50bcc8a5f8d0f30b872484852"),
+		[]model.SHA1{
+			model.NewSHA1("8ec19d64748c54c6d047f30c81b4c444a8232b41"),
+			model.NewSHA1("04fffad6eacd4512554cb22ca3a0d6b8a38a96cc"),
 		},
 	)
+
+	roots, err := finder.find(plumbing.Hash(branchOneHash))
 	require.NoError(err)
 
 	require.Equal([]model.SHA1{
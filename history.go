@@ -0,0 +1,237 @@
+package borges
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/src-d/borges/storage"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+// referenceChanges returns the changes of the references made by the given
+// commands, as they are kept in their history. A reference whose init
+// commit changed has a command deleting it from the old rooted repository
+// and another creating it in the new one, which are a single update.
+//
+// Updates that are not fast-forwards, as given by fastForwards, are flagged
+// as forced.
+func referenceChanges(
+	commands []*Command,
+	fastForwards map[string]bool,
+	job string,
+	now time.Time,
+) []*storage.ReferenceChange {
+	byName := make(map[string]*storage.ReferenceChange)
+	for _, c := range commands {
+		var name string
+		if c.New != nil {
+			name = c.New.Name
+		} else if c.Old != nil {
+			name = c.Old.Name
+		} else {
+			continue
+		}
+
+		rc, ok := byName[name]
+		if !ok {
+			rc = &storage.ReferenceChange{Name: name, Job: job, Time: now}
+			byName[name] = rc
+		}
+
+		if c.Old != nil {
+			rc.OldHash, rc.OldInit = c.Old.Hash, c.Old.Init
+		}
+
+		if c.New != nil {
+			rc.NewHash, rc.NewInit = c.New.Hash, c.New.Init
+		}
+	}
+
+	changes := make([]*storage.ReferenceChange, 0, len(byName))
+	for _, rc := range byName {
+		switch {
+		case rc.OldHash.IsZero():
+			rc.Action = string(Create)
+		case rc.NewHash.IsZero():
+			rc.Action = string(Delete)
+		default:
+			rc.Action = string(Update)
+			ff, ok := fastForwards[rc.Name]
+			rc.Forced = ok && !ff
+		}
+
+		changes = append(changes, rc)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+// fastForwards returns, by name, whether the references updated by the given
+// commands point to a commit that descends from the previous one. A
+// reference whose init commit changed is updated by a command deleting it
+// and another creating it. Updates whose previous commit was not fetched
+// again are not fast-forwards, and the ones that cannot be checked are left
+// out.
+func fastForwards(log log15.Logger, tr ancestry, commands []*Command) map[string]bool {
+	olds := make(map[string]*model.Reference)
+	news := make(map[string]*model.Reference)
+	for _, c := range commands {
+		if c.Old != nil {
+			olds[c.Old.Name] = c.Old
+		}
+
+		if c.New != nil {
+			news[c.New.Name] = c.New
+		}
+	}
+
+	result := make(map[string]bool)
+	for name, old := range olds {
+		updated, ok := news[name]
+		if !ok {
+			continue
+		}
+
+		ff, err := tr.IsAncestor(plumbing.Hash(old.Hash), plumbing.Hash(updated.Hash))
+		if err != nil {
+			log.Warn("unable to check fast-forward of reference",
+				"reference", name, "error", err)
+			continue
+		}
+
+		result[name] = ff
+	}
+
+	return result
+}
+
+// changesCommands returns the commands of all the changes, in the order of
+// their init commits.
+func changesCommands(changes Changes) []*Command {
+	var commands []*Command
+	for _, ic := range sortedInits(changes) {
+		commands = append(commands, changes[ic]...)
+	}
+
+	return commands
+}
+
+// ancestry tells whether a commit is reachable from another, as
+// TemporaryRepository does.
+type ancestry interface {
+	IsAncestor(a, b plumbing.Hash) (bool, error)
+}
+
+// graphAncestry is the ancestry of the commits in a commit graph, where
+// missing commits are not reachable from any commit.
+type graphAncestry struct {
+	graph *lazyCommitGraph
+}
+
+func (g graphAncestry) IsAncestor(a, b plumbing.Hash) (bool, error) {
+	ok, err := g.graph.isAncestor(a, b)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+
+	return ok, err
+}
+
+// historyReferences returns, by init commit, the references keeping the
+// previous tips of the references updated by the changes that are not
+// fast-forwards, as given by fastForwards, or that could not be checked, in
+// the given namespace. They are kept in the rooted repository of the
+// previous tip, which is the one where the update or, if its init commit
+// changed, the deletion of the reference is pushed.
+func historyReferences(
+	namespace string,
+	id kallax.ULID,
+	changes Changes,
+	fastForwards map[string]bool,
+	now time.Time,
+) map[model.SHA1][]*plumbing.Reference {
+	olds := make(map[string]*model.Reference)
+	news := make(map[string]*model.Reference)
+	for _, c := range changesCommands(changes) {
+		if c.Old != nil {
+			olds[c.Old.Name] = c.Old
+		}
+
+		if c.New != nil {
+			news[c.New.Name] = c.New
+		}
+	}
+
+	history := make(map[model.SHA1][]*plumbing.Reference)
+	for name, old := range olds {
+		if _, ok := news[name]; !ok {
+			continue
+		}
+
+		if ff, ok := fastForwards[name]; ok && ff {
+			continue
+		}
+
+		ref := plumbing.NewHashReference(
+			historyReferenceName(namespace, id, name, now),
+			plumbing.Hash(old.Hash),
+		)
+		history[old.Init] = append(history[old.Init], ref)
+	}
+
+	return history
+}
+
+// historyReferenceName returns the name of the reference keeping the tip of
+// the reference with the given name of a repository, which was replaced or
+// deleted at the given time, in the given namespace.
+func historyReferenceName(namespace string, id kallax.ULID, name string, t time.Time) plumbing.ReferenceName {
+	return plumbing.ReferenceName(fmt.Sprintf("%s/%s/%s/%d", namespace, id, name, t.Unix()))
+}
+
+// deletedReferences returns the references deleted by the changes, that are
+// not created again with another init commit, to keep them in tombstones in
+// the given namespace.
+func deletedReferences(
+	namespace string,
+	id kallax.ULID,
+	changes Changes,
+	now time.Time,
+) []*storage.DeletedReference {
+	created := make(map[string]bool)
+	for _, cmds := range changes {
+		for _, c := range cmds {
+			if c.New != nil {
+				created[c.New.Name] = true
+			}
+		}
+	}
+
+	var deleted []*storage.DeletedReference
+	for _, ic := range sortedInits(changes) {
+		for _, c := range changes[ic] {
+			if c.Action() != Delete || created[c.Old.Name] {
+				continue
+			}
+
+			deleted = append(deleted, &storage.DeletedReference{
+				RepositoryID: id,
+				Name:         c.Old.Name,
+				Hash:         c.Old.Hash,
+				Init:         c.Old.Init,
+				Tombstone:    historyReferenceName(namespace, id, c.Old.Name, now).String(),
+				DeletedAt:    now,
+			})
+		}
+	}
+
+	return deleted
+}
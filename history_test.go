@@ -0,0 +1,196 @@
+package borges
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestReferenceChanges(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	root := h.commit()
+	a := h.commit(root)
+	b := h.commit(a)
+	c := h.commit(root)
+	other := h.commit()
+	r := syntheticRepository(t, h, map[string]plumbing.Hash{
+		"refs/heads/master": b,
+		"refs/heads/forced": c,
+		"refs/heads/moved":  other,
+	})
+
+	tr := &temporaryRepository{Repository: r}
+	ref := func(name string, hash, init plumbing.Hash) *model.Reference {
+		return &model.Reference{Name: name, Hash: model.SHA1(hash), Init: model.SHA1(init)}
+	}
+
+	commands := []*Command{
+		{Old: ref("refs/heads/master", a, root), New: ref("refs/heads/master", b, root)},
+		{Old: ref("refs/heads/forced", b, root), New: ref("refs/heads/forced", c, root)},
+		{Old: ref("refs/heads/moved", a, root)},
+		{New: ref("refs/heads/moved", other, other)},
+		{New: ref("refs/heads/new", a, root)},
+		{Old: ref("refs/heads/old", a, root)},
+	}
+
+	now := time.Now()
+	counting := &countingAncestry{ancestry: tr}
+	ffs := fastForwards(log15.New(), counting, commands)
+	require.Equal(3, counting.calls)
+	require.Equal(map[string]bool{
+		"refs/heads/master": true,
+		"refs/heads/forced": false,
+		"refs/heads/moved":  false,
+	}, ffs)
+
+	changes := referenceChanges(commands, ffs, "job", now)
+	require.Len(changes, 5)
+
+	byName := make(map[string]int)
+	for i, c := range changes {
+		require.Equal("job", c.Job)
+		require.Equal(now, c.Time)
+		byName[c.Name] = i
+	}
+
+	forced := changes[byName["refs/heads/forced"]]
+	require.Equal(string(Update), forced.Action)
+	require.True(forced.Forced)
+
+	master := changes[byName["refs/heads/master"]]
+	require.Equal(string(Update), master.Action)
+	require.False(master.Forced)
+	require.Equal(model.SHA1(a), master.OldHash)
+	require.Equal(model.SHA1(b), master.NewHash)
+
+	moved := changes[byName["refs/heads/moved"]]
+	require.Equal(string(Update), moved.Action)
+	require.True(moved.Forced)
+	require.Equal(model.SHA1(root), moved.OldInit)
+	require.Equal(model.SHA1(other), moved.NewInit)
+
+	require.Equal(string(Create), changes[byName["refs/heads/new"]].Action)
+	require.Equal(string(Delete), changes[byName["refs/heads/old"]].Action)
+}
+
+func TestIsAncestor(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	root := h.commit()
+	a := h.commit(root)
+	b := h.commit(root)
+	merge := h.commit(a, b)
+	parents := objectParents{h.storage}
+
+	ok, err := isAncestor(parents, root, merge)
+	require.NoError(err)
+	require.True(ok)
+
+	ok, err = isAncestor(parents, merge, merge)
+	require.NoError(err)
+	require.True(ok)
+
+	ok, err = isAncestor(parents, a, b)
+	require.NoError(err)
+	require.False(ok)
+
+	_, err = isAncestor(parents, root, plumbing.NewHash("0000000000000000000000000000000000000001"))
+	require.Equal(plumbing.ErrObjectNotFound, err)
+}
+
+func TestHistoryReferences(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	root := h.commit()
+	a := h.commit(root)
+	b := h.commit(a)
+	c := h.commit(root)
+	other := h.commit()
+	r := syntheticRepository(t, h, map[string]plumbing.Hash{
+		"refs/heads/master": b,
+		"refs/heads/forced": c,
+		"refs/heads/moved":  other,
+	})
+
+	tr := &temporaryRepository{Repository: r}
+	ref := func(name string, hash, init plumbing.Hash) *model.Reference {
+		return &model.Reference{Name: name, Hash: model.SHA1(hash), Init: model.SHA1(init)}
+	}
+
+	changes := make(Changes)
+	changes.Update(ref("refs/heads/master", a, root), ref("refs/heads/master", b, root))
+	changes.Update(ref("refs/heads/forced", b, root), ref("refs/heads/forced", c, root))
+	changes.Delete(ref("refs/heads/moved", a, root))
+	changes.Add(ref("refs/heads/moved", other, other))
+	changes.Delete(ref("refs/heads/deleted", a, root))
+
+	id := model.NewRepository().ID
+	now := time.Unix(1500000000, 0)
+	ffs := fastForwards(log15.New(), tr, changesCommands(changes))
+	history := historyReferences("refs/borges/history", id, changes, ffs, now)
+	require.Len(history, 1)
+
+	byName := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for _, ref := range history[model.SHA1(root)] {
+		byName[ref.Name()] = ref.Hash()
+	}
+
+	require.Equal(map[plumbing.ReferenceName]plumbing.Hash{
+		plumbing.ReferenceName(fmt.Sprintf("refs/borges/history/%s/refs/heads/forced/1500000000", id)): b,
+		plumbing.ReferenceName(fmt.Sprintf("refs/borges/history/%s/refs/heads/moved/1500000000", id)):  a,
+	}, byName)
+
+	// the previous tips of the updates that could not be checked are kept
+	delete(ffs, "refs/heads/master")
+	history = historyReferences("refs/borges/history", id, changes, ffs, now)
+	require.Len(history[model.SHA1(root)], 3)
+}
+
+// countingAncestry counts the calls to IsAncestor.
+type countingAncestry struct {
+	ancestry
+	calls int
+}
+
+func (a *countingAncestry) IsAncestor(x, y plumbing.Hash) (bool, error) {
+	a.calls++
+	return a.ancestry.IsAncestor(x, y)
+}
+
+func TestDeletedReferences(t *testing.T) {
+	require := require.New(t)
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	changes := make(Changes)
+	changes.Delete(&model.Reference{Name: "refs/heads/deleted", Hash: a, Init: a})
+	changes.Delete(&model.Reference{Name: "refs/heads/moved", Hash: a, Init: a})
+	changes.Add(&model.Reference{Name: "refs/heads/moved", Hash: b, Init: b})
+	changes.Update(
+		&model.Reference{Name: "refs/heads/master", Hash: a, Init: a},
+		&model.Reference{Name: "refs/heads/master", Hash: b, Init: a},
+	)
+
+	id := model.NewRepository().ID
+	now := time.Unix(1500000000, 0)
+	deleted := deletedReferences("refs/borges/deleted", id, changes, now)
+	require.Len(deleted, 1)
+	require.Equal(id, deleted[0].RepositoryID)
+	require.Equal("refs/heads/deleted", deleted[0].Name)
+	require.Equal(a, deleted[0].Hash)
+	require.Equal(a, deleted[0].Init)
+	require.Equal(now, deleted[0].DeletedAt)
+	require.Equal(
+		fmt.Sprintf("refs/borges/deleted/%s/refs/heads/deleted/1500000000", id),
+		deleted[0].Tombstone,
+	)
+}
@@ -0,0 +1,105 @@
+package borges
+
+import (
+	"fmt"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// InitMismatch is a reference whose stored init commit is not the one
+// obtained following the first parents of its commit.
+type InitMismatch struct {
+	// Reference is the reference as stored in the repository model.
+	Reference *model.Reference
+	// Init is the init commit computed from the rooted repository. It is
+	// zero if the reference could not be found there.
+	Init model.SHA1
+}
+
+// VerifyInits checks that the init commit stored for every reference of the
+// given repository is the root commit reached following the first parent of
+// each commit, as it is defined in the package documentation. References are
+// read from the rooted repositories of their stored init commits, which are
+// never modified. References archived with a limited history have no init
+// commit, so they are not checked. It returns the references that do not
+// match.
+func VerifyInits(tx repository.RootedTransactioner, r *model.Repository) ([]*InitMismatch, error) {
+	shallow := ShallowInit(r.ID)
+	byInit := make(map[model.SHA1][]*model.Reference)
+	for _, ref := range r.References {
+		if ref.Init == shallow {
+			continue
+		}
+
+		byInit[ref.Init] = append(byInit[ref.Init], ref)
+	}
+
+	var mismatches []*InitMismatch
+	for init, refs := range byInit {
+		m, err := verifyInits(tx, r, init, refs)
+		if err != nil {
+			return nil, err
+		}
+
+		mismatches = append(mismatches, m...)
+	}
+
+	return mismatches, nil
+}
+
+func verifyInits(
+	tx repository.RootedTransactioner,
+	r *model.Repository,
+	init model.SHA1,
+	refs []*model.Reference,
+) (mismatches []*InitMismatch, err error) {
+	t, err := tx.Begin(plumbing.Hash(init))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if rErr := t.Rollback(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}()
+
+	rr, err := git.Open(t.Storer(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	finder := newRootsFinder(objectParents{rr.Storer})
+	for _, ref := range refs {
+		name := plumbing.ReferenceName(fmt.Sprintf("%s/%s", ref.Name, r.ID))
+		stored, err := rr.Reference(name, true)
+		if err == plumbing.ErrReferenceNotFound {
+			mismatches = append(mismatches, &InitMismatch{Reference: ref})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		c, err := ResolveCommit(rr, stored.Hash())
+		if err != nil {
+			return nil, err
+		}
+
+		computed, err := finder.init(c.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if computed != ref.Init {
+			mismatches = append(mismatches, &InitMismatch{
+				Reference: ref,
+				Init:      computed,
+			})
+		}
+	}
+
+	return mismatches, nil
+}
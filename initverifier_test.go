@@ -0,0 +1,87 @@
+package borges
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+type memoryTransactioner map[plumbing.Hash]storage.Storer
+
+func (t memoryTransactioner) Begin(h plumbing.Hash) (repository.Tx, error) {
+	s, ok := t[h]
+	if !ok {
+		return nil, fmt.Errorf("rooted repository not found: %s", h)
+	}
+
+	return memoryTx{s}, nil
+}
+
+type memoryTx struct {
+	s storage.Storer
+}
+
+func (t memoryTx) Storer() storage.Storer { return t.s }
+func (memoryTx) Commit() error            { return nil }
+func (memoryTx) Rollback() error          { return nil }
+
+func TestVerifyInits(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit()
+	master := h.commit(h.commit(b), a)
+	other := h.commit(a)
+
+	require.NoError(h.storage.SetReference(
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master),
+	))
+
+	r := model.NewRepository()
+	for name, hash := range map[string]plumbing.Hash{
+		"refs/heads/master": master,
+		"refs/heads/other":  other,
+	} {
+		ref := plumbing.NewHashReference(
+			plumbing.ReferenceName(fmt.Sprintf("%s/%s", name, r.ID)),
+			hash,
+		)
+		require.NoError(h.storage.SetReference(ref))
+	}
+
+	r.References = []*model.Reference{
+		{Name: "refs/heads/master", Hash: model.SHA1(master), Init: model.SHA1(a)},
+		{Name: "refs/heads/other", Hash: model.SHA1(other), Init: model.SHA1(a)},
+		{Name: "refs/heads/missing", Hash: model.SHA1(other), Init: model.SHA1(a)},
+	}
+
+	tx := memoryTransactioner{plumbing.Hash(a): h.storage}
+	mismatches, err := VerifyInits(tx, r)
+	require.NoError(err)
+	require.Len(mismatches, 2)
+
+	byName := make(map[string]*InitMismatch)
+	for _, m := range mismatches {
+		byName[m.Reference.Name] = m
+	}
+
+	require.Equal(model.SHA1(b), byName["refs/heads/master"].Init)
+	require.True(byName["refs/heads/missing"].Init.IsZero())
+
+	r.References[0].Init = model.SHA1(b)
+	_, err = VerifyInits(tx, r)
+	require.Error(err)
+
+	r.ID = kallax.NewULID()
+	r.References = r.References[1:2]
+	mismatches, err = VerifyInits(tx, r)
+	require.NoError(err)
+	require.Len(mismatches, 1)
+}
@@ -0,0 +1,133 @@
+package borges
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/src-d/borges/storage"
+	"gopkg.in/src-d/framework.v0/queue"
+	"gopkg.in/src-d/go-errors.v0"
+)
+
+// ErrMalformedEntry is returned by a JSONL JobIter when a line cannot be
+// turned into a job. The iterator can still be used after this error.
+var ErrMalformedEntry = errors.NewKind("malformed entry at line %d: %s")
+
+// jsonlEntry is the representation of a repository in each line of a JSONL
+// source.
+type jsonlEntry struct {
+	Endpoint string   `json:"endpoint"`
+	Aliases  []string `json:"aliases"`
+	IsFork   *bool    `json:"is_fork"`
+	Priority uint8    `json:"priority"`
+	Labels   []string `json:"labels"`
+	// IncludeRefs and ExcludeRefs override the reference filter of the
+	// archiver for this repository.
+	IncludeRefs []string `json:"include_refs"`
+	ExcludeRefs []string `json:"exclude_refs"`
+}
+
+type jsonlJobIter struct {
+	storer storage.RepoStore
+	*bufio.Scanner
+	r    io.ReadCloser
+	line int
+}
+
+// NewJSONLJobIter returns a JobIter that returns jobs generated from a reader
+// with one JSON object per line describing a repository, for example:
+//
+//   {"endpoint": "https://github.com/a/b", "aliases": ["git://github.com/a/b"], "is_fork": false, "priority": 8, "labels": ["ml"]}
+//
+// Only endpoint is mandatory. A priority of 0 means the job is published with
+// the default priority of the queue. Blank lines are ignored, malformed lines
+// make Next return an ErrMalformedEntry error with the line number, but the
+// following lines can still be read. The references archived for a repository
+// can be chosen with include_refs and exclude_refs, as in RefFilter.
+func NewJSONLJobIter(r io.ReadCloser, storer storage.RepoStore) JobIter {
+	return &jsonlJobIter{
+		storer:  storer,
+		Scanner: bufio.NewScanner(r),
+		r:       r,
+	}
+}
+
+func (i *jsonlJobIter) Next() (*Job, error) {
+	var line string
+	for line == "" {
+		if !i.Scan() {
+			if err := i.Err(); err != nil {
+				return nil, err
+			}
+
+			return nil, io.EOF
+		}
+
+		i.line++
+		line = strings.TrimSpace(string(i.Bytes()))
+	}
+
+	var e jsonlEntry
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		return nil, ErrMalformedEntry.Wrap(err, i.line, err)
+	}
+
+	if e.Endpoint == "" {
+		return nil, ErrMalformedEntry.New(i.line, "endpoint is empty")
+	}
+
+	if e.Priority > uint8(queue.PriorityUrgent) {
+		return nil, ErrMalformedEntry.New(i.line, "priority must be between 0 and 8")
+	}
+
+	endpoints, err := jsonlEndpoints(e.Endpoint, e.Aliases)
+	if err != nil {
+		return nil, ErrMalformedEntry.Wrap(err, i.line, err)
+	}
+
+	ID, err := RepositoryID(endpoints, e.IsFork, i.storer)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Job{
+		RepositoryID: ID,
+		Priority:     queue.Priority(e.Priority),
+		Labels:       e.Labels,
+	}
+
+	if e.IncludeRefs != nil || e.ExcludeRefs != nil {
+		j.RefFilter = &RefFilter{Include: e.IncludeRefs, Exclude: e.ExcludeRefs}
+	}
+
+	return j, nil
+}
+
+// jsonlEndpoints returns the normalized list of endpoints of an entry, with
+// the main endpoint first and without duplicates.
+func jsonlEndpoints(endpoint string, aliases []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, e := range append([]string{endpoint}, aliases...) {
+		e, err := normalizeEndpoint(e)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[e] {
+			continue
+		}
+
+		seen[e] = true
+		endpoints = append(endpoints, e)
+	}
+
+	return endpoints, nil
+}
+
+// Close closes the underlying reader.
+func (i *jsonlJobIter) Close() error {
+	return i.r.Close()
+}
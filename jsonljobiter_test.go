@@ -0,0 +1,126 @@
+package borges
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/src-d/borges/storage"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/test"
+	"gopkg.in/src-d/framework.v0/queue"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestJSONLJobIter(t *testing.T) {
+	suite.Run(t, new(JSONLJobIterSuite))
+}
+
+type JSONLJobIterSuite struct {
+	test.Suite
+}
+
+func (s *JSONLJobIterSuite) SetupTest() {
+	s.Suite.Setup()
+}
+
+func (s *JSONLJobIterSuite) TearDownTest() {
+	s.Suite.TearDown()
+}
+
+func (s *JSONLJobIterSuite) TestGetJobs() {
+	require := s.Require()
+	text := `{"endpoint": "git://foo/bar.git", "aliases": ["https://foo/bar.git", "git://foo/bar.git"], "is_fork": true}
+
+{"endpoint": "https://foo/baz.git", "priority": 8, "labels": ["a", "b"]}`
+	r := ioutil.NopCloser(strings.NewReader(text))
+
+	storer := storage.FromDatabase(s.DB)
+	iter := NewJSONLJobIter(r, storer)
+
+	j, err := iter.Next()
+	require.NoError(err)
+	ID, err := getIDByEndpoint("https://foo/bar.git", s.DB)
+	require.NoError(err)
+	require.Equal(&Job{RepositoryID: ID}, j)
+
+	repo, err := storer.Get(kallax.ULID(ID))
+	require.NoError(err)
+	require.Equal([]string{"git://foo/bar.git", "https://foo/bar.git"}, repo.Endpoints)
+	require.NotNil(repo.IsFork)
+	require.True(*repo.IsFork)
+
+	j, err = iter.Next()
+	require.NoError(err)
+	ID, err = getIDByEndpoint("https://foo/baz.git", s.DB)
+	require.NoError(err)
+	require.Equal(&Job{
+		RepositoryID: ID,
+		Priority:     queue.PriorityUrgent,
+		Labels:       []string{"a", "b"},
+	}, j)
+
+	j, err = iter.Next()
+	require.Equal(io.EOF, err)
+	require.Nil(j)
+}
+
+func (s *JSONLJobIterSuite) TestRefFilter() {
+	require := s.Require()
+	text := `{"endpoint": "git://foo/bar.git", "include_refs": ["refs/heads/*"], "exclude_refs": ["refs/heads/tmp-*"]}`
+	r := ioutil.NopCloser(strings.NewReader(text))
+
+	iter := NewJSONLJobIter(r, storage.FromDatabase(s.DB))
+	j, err := iter.Next()
+	require.NoError(err)
+	require.Equal(&RefFilter{
+		Include: []string{"refs/heads/*"},
+		Exclude: []string{"refs/heads/tmp-*"},
+	}, j.RefFilter)
+}
+
+func (s *JSONLJobIterSuite) TestMalformedEntries() {
+	require := s.Require()
+	text := `{"endpoint": "git://foo/bar.git"
+{"aliases": ["git://foo/bar.git"]}
+{"endpoint": "foo"}
+{"endpoint": "git://foo/bar.git", "priority": 9}
+{"endpoint": "git://foo/baz.git"}`
+	r := ioutil.NopCloser(strings.NewReader(text))
+
+	storer := storage.FromDatabase(s.DB)
+	iter := NewJSONLJobIter(r, storer)
+
+	for i := 1; i <= 4; i++ {
+		j, err := iter.Next()
+		require.True(ErrMalformedEntry.Is(err), "line %d: %s", i, err)
+		require.Contains(err.Error(), fmt.Sprintf("line %d", i))
+		require.Nil(j)
+	}
+
+	j, err := iter.Next()
+	require.NoError(err)
+	ID, err := getIDByEndpoint("git://foo/baz.git", s.DB)
+	require.NoError(err)
+	require.Equal(&Job{RepositoryID: ID}, j)
+
+	_, err = model.NewRepositoryStore(s.DB).FindOne(model.NewRepositoryQuery().
+		Where(kallax.ArrayContains(model.Schema.Repository.Endpoints, "git://foo/bar.git")))
+	require.Equal(kallax.ErrNotFound, err)
+
+	j, err = iter.Next()
+	require.Equal(io.EOF, err)
+	require.Nil(j)
+}
+
+func (s *JSONLJobIterSuite) TestEmpty() {
+	r := ioutil.NopCloser(strings.NewReader("\n\n"))
+	iter := NewJSONLJobIter(r, nil)
+
+	j, err := iter.Next()
+	s.Equal(io.EOF, err)
+	s.Nil(j)
+}
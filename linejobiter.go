@@ -37,18 +37,36 @@ func (i *lineJobIter) Next() (*Job, error) {
 		return nil, io.EOF
 	}
 
-	line := string(i.Bytes())
-	// check if the line is an absolute path to a directory.
-	// If the path is a directory we can look for the .git directory to try
-	// to guess if it's a git repo or a bare repo.
-	// If .git does not exist it will be treated as a bare repo (even if it's
-	// not).
+	line, err := normalizeEndpoint(string(i.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	ID, err := RepositoryID([]string{line}, nil, i.storer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{RepositoryID: ID}, nil
+}
+
+// Close closes the underlying reader.
+func (i *lineJobIter) Close() error {
+	return i.r.Close()
+}
+
+// normalizeEndpoint turns a line with a repository location into an absolute
+// URL. If the line is an absolute path to a directory it is converted to a
+// file:// URL, looking for the .git directory to try to guess if it's a
+// regular repository or a bare one. If .git does not exist it will be treated
+// as a bare repo (even if it's not).
+func normalizeEndpoint(line string) (string, error) {
 	if path.IsAbs(line) {
 		dotGit := filepath.Join(line, ".git")
 		if _, err := os.Stat(dotGit); os.IsNotExist(err) {
 			line = fmt.Sprintf("file://%s", line)
 		} else if err != nil {
-			return nil, fmt.Errorf("expecting remote or local repository, instead %q was found", line)
+			return "", fmt.Errorf("expecting remote or local repository, instead %q was found", line)
 		} else {
 			line = fmt.Sprintf("file://%s", dotGit)
 		}
@@ -56,22 +74,12 @@ func (i *lineJobIter) Next() (*Job, error) {
 
 	u, err := url.Parse(line)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	if !u.IsAbs() {
-		return nil, fmt.Errorf("expected absolute URL: %s", line)
-	}
-
-	ID, err := RepositoryID([]string{line}, nil, i.storer)
-	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("expected absolute URL: %s", line)
 	}
 
-	return &Job{RepositoryID: ID}, nil
-}
-
-// Close closes the underlying reader.
-func (i *lineJobIter) Close() error {
-	return i.r.Close()
+	return line, nil
 }
@@ -0,0 +1,8 @@
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package borges
+
+// mmapFile is not supported on this platform, files are read instead.
+func mmapFile(path string) ([]byte, func() error, error) {
+	return nil, nil, errMmapNotSupported
+}
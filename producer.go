@@ -75,7 +75,7 @@ func (p *Producer) start() {
 		if err := p.add(j); err != nil {
 			log.Error("error adding job to the queue", "job", j.RepositoryID, "error", err)
 		} else {
-			log.Info("job queued", "job", j.RepositoryID)
+			log.Info("job queued", "job", j.RepositoryID, "labels", j.Labels)
 		}
 	}
 
@@ -84,6 +84,10 @@ func (p *Producer) start() {
 
 func (p *Producer) add(j *Job) error {
 	qj := queue.NewJob()
+	if j.Priority != 0 {
+		qj.SetPriority(j.Priority)
+	}
+
 	if err := qj.Encode(j); err != nil {
 		return err
 	}
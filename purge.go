@@ -0,0 +1,156 @@
+package borges
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/framework.v0/lock"
+	"gopkg.in/src-d/go-errors.v0"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	gitstorage "gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+// ErrPurge is returned when a repository could not be removed from some of
+// its rooted repositories, wrapping the last error. The repository is not
+// deleted, so it can be purged again.
+var ErrPurge = errors.NewKind("purging repository %s from %d out of %d rooted repositories failed")
+
+// Purge removes the given repository from the archive. Its references, HEAD
+// and remote configuration are deleted from the rooted repositories of the
+// init commits of its references, its deleted references and the previous
+// tips kept in its history, which are then rewritten so the objects no other
+// repository references are pruned. Finally, the repository is deleted from
+// the store.
+func Purge(
+	store storage.RepoStore,
+	w *RootedRewriter,
+	ls lock.Session,
+	r *model.Repository,
+) error {
+	inits, err := purgedInits(store, r)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	var lastErr error
+	for _, init := range inits {
+		_, _, err := w.rewrite(ls, init, func(s gitstorage.Storer, fresh gitstorage.Storer) (bool, error) {
+			if err := purgeRepository(s, r.ID); err != nil {
+				return false, err
+			}
+
+			_, err := collectGarbage(s, fresh)
+			return true, err
+		})
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %s", init, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return ErrPurge.Wrap(lastErr, r.ID.String(), failed, len(inits))
+	}
+
+	return store.Delete(r)
+}
+
+// purgedInits returns the init commits of the rooted repositories where the
+// given repository may have references: the ones of its references, and the
+// ones its deleted references and the previous tips of its references were
+// kept in, which it may no longer reference.
+func purgedInits(store storage.RepoStore, r *model.Repository) ([]model.SHA1, error) {
+	inits, _ := referencesByInit(r.References)
+	seen := make(map[model.SHA1]bool, len(inits))
+	for _, init := range inits {
+		seen[init] = true
+	}
+
+	add := func(init model.SHA1) {
+		if init != (model.SHA1{}) && !seen[init] {
+			seen[init] = true
+			inits = append(inits, init)
+		}
+	}
+
+	deleted, err := store.DeletedReferences(r.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range deleted {
+		add(ref.Init)
+	}
+
+	// previous tips are kept in the rooted repository of their init commit,
+	// which may not be the one of the reference anymore
+	history, err := store.ReferenceHistory(r.ID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range history {
+		add(c.OldInit)
+	}
+
+	return inits, nil
+}
+
+// purgeRepository removes from s the references and the remote configuration
+// of the repository with the given ID. Besides its references, which end with
+// its ID, the ones in a namespace of the repository, such as its remote or
+// the history of its force pushed references, are removed too.
+func purgeRepository(s gitstorage.Storer, id kallax.ULID) error {
+	suffix := fmt.Sprintf("/%s", id)
+	namespace := fmt.Sprintf("/%s/", id)
+
+	refs, err := s.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	var names []plumbing.ReferenceName
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().String()
+		if strings.HasSuffix(name, suffix) || strings.Contains(name, namespace) {
+			names = append(names, ref.Name())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := s.RemoveReference(name); err != nil {
+			return err
+		}
+	}
+
+	c, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.Remotes[id.String()]; !ok && !c.Raw.Section("remote").HasSubsection(id.String()) {
+		return nil
+	}
+
+	delete(c.Remotes, id.String())
+	section := c.Raw.Section("remote")
+	subsections := section.Subsections[:0]
+	for _, ss := range section.Subsections {
+		if ss.Name != id.String() {
+			subsections = append(subsections, ss)
+		}
+	}
+
+	section.Subsections = subsections
+	return s.SetConfig(c)
+}
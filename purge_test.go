@@ -0,0 +1,283 @@
+package borges
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/borges/storage"
+
+	"github.com/src-d/go-git-fixtures"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/framework.v0/lock"
+	"gopkg.in/src-d/go-billy.v3/memfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	gitstorage "gopkg.in/src-d/go-git.v4/storage"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestPurgeRepository(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	fs := fixtures.Basic().One().DotGit()
+	sto, err := filesystem.NewStorage(fs)
+	require.NoError(err)
+
+	r, err := git.Open(sto, memfs.New())
+	require.NoError(err)
+
+	master := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	branch := plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+
+	purged := model.NewRepository()
+	purged.Endpoints = []string{"git://purged"}
+	other := model.NewRepository()
+	other.Endpoints = []string{"git://other"}
+
+	refs := map[string]plumbing.Hash{
+		fmt.Sprintf("refs/heads/master/%s", purged.ID):                       master,
+		fmt.Sprintf("refs/heads/branch/%s", purged.ID):                       branch,
+		fmt.Sprintf("refs/tags/v1.0.0/%s", purged.ID):                        master,
+		fmt.Sprintf("refs/heads/master/%s", other.ID):                        master,
+		fmt.Sprintf("refs/remotes/%s/branch", other.ID):                      master,
+		fmt.Sprintf("refs/borges/history/%s/refs/heads/master/1", purged.ID): branch,
+		fmt.Sprintf("refs/borges/history/%s/refs/heads/master/1", other.ID):  master,
+		"refs/heads/other": master,
+	}
+
+	it, err := sto.IterReferences()
+	require.NoError(err)
+	require.NoError(it.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			return sto.RemoveReference(ref.Name())
+		}
+
+		return nil
+	}))
+
+	for name, h := range refs {
+		require.NoError(sto.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(name), h)))
+	}
+
+	for _, mr := range []*model.Repository{purged, other} {
+		require.NoError(StoreConfig(r, mr))
+		require.NoError(StoreHead(r, mr.ID, "refs/heads/master"))
+	}
+
+	require.NoError(purgeRepository(sto, purged.ID))
+
+	it, err = sto.IterReferences()
+	require.NoError(err)
+	var names []string
+	require.NoError(it.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().String())
+		return nil
+	}))
+	require.Len(names, 6)
+	for _, name := range names {
+		require.NotContains(name, purged.ID.String())
+	}
+
+	cfg, err := sto.Config()
+	require.NoError(err)
+	require.NotContains(cfg.Remotes, purged.ID.String())
+	require.Contains(cfg.Remotes, other.ID.String())
+	require.False(cfg.Raw.Section("remote").HasSubsection(purged.ID.String()))
+	require.True(cfg.Raw.Section("remote").HasSubsection(other.ID.String()))
+
+	fresh, err := filesystem.NewStorage(memfs.New())
+	require.NoError(err)
+
+	stats, err := collectGarbage(sto, fresh)
+	require.NoError(err)
+	require.NotZero(stats.Unreachable)
+	require.Equal(plumbing.ErrObjectNotFound, fresh.HasEncodedObject(branch))
+	require.NoError(fresh.HasEncodedObject(master))
+	requireSameReferences(t, sto, fresh)
+
+	cfg, err = fresh.Config()
+	require.NoError(err)
+	require.NotContains(cfg.Remotes, purged.ID.String())
+	require.Contains(cfg.Remotes, other.ID.String())
+}
+
+func TestPurge_DeletedReferences(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	remote := memfs.New()
+	copier := repository.NewLocalCopier(remote)
+	tx := repository.NewSivaRootedTransactioner(copier, memfs.New())
+	ls, err := lock.NewLocal().NewSession(&lock.SessionConfig{TTL: 10 * time.Second})
+	require.NoError(err)
+	defer ls.Close()
+
+	master := model.NewSHA1("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	branch := model.NewSHA1("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	init := model.NewSHA1("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	// the tombstone is kept in a rooted repository the repository no longer
+	// references
+	deletedInit := model.NewSHA1("0000000000000000000000000000000000000001")
+
+	store := storage.Local()
+	r := model.NewRepository()
+	r.Endpoints = []string{"git://purged"}
+	r.References = []*model.Reference{
+		{Name: "refs/heads/master", Hash: master, Init: init},
+	}
+	require.NoError(store.Create(r))
+
+	tombstone := historyReferenceName("refs/borges/deleted", r.ID, "refs/heads/branch", time.Now())
+	require.NoError(store.AddDeletedReferences(r, []*storage.DeletedReference{{
+		RepositoryID: r.ID,
+		Name:         "refs/heads/branch",
+		Hash:         branch,
+		Init:         deletedInit,
+		Tombstone:    tombstone.String(),
+		DeletedAt:    time.Now(),
+	}}))
+
+	writeRootedRepository(t, tx, ls, init, map[plumbing.ReferenceName]model.SHA1{
+		plumbing.ReferenceName(fmt.Sprintf("refs/heads/master/%s", r.ID)): master,
+	})
+	writeRootedRepository(t, tx, ls, deletedInit, map[plumbing.ReferenceName]model.SHA1{
+		tombstone: branch,
+	})
+
+	w := NewRootedRewriter(copier, memfs.New())
+	require.NoError(Purge(store, w, ls, r))
+
+	for _, init := range []model.SHA1{init, deletedInit} {
+		requireNoReferencesOf(t, tx, ls, init, r.ID)
+	}
+
+	_, err = store.Get(r.ID)
+	require.Equal(kallax.ErrNotFound, err)
+}
+
+// writeRootedRepository writes the rooted repository of the given init commit
+// with the objects of the basic fixture and the given references.
+func writeRootedRepository(
+	t *testing.T,
+	tx repository.RootedTransactioner,
+	ls lock.Session,
+	init model.SHA1,
+	refs map[plumbing.ReferenceName]model.SHA1,
+) {
+	src, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(t, err)
+
+	err = withRootedRepository(tx, ls, init, func(s gitstorage.Storer) (bool, error) {
+		iter, err := src.IterEncodedObjects(plumbing.AnyObject)
+		if err != nil {
+			return false, err
+		}
+
+		err = iter.ForEach(func(obj plumbing.EncodedObject) error {
+			_, err := s.SetEncodedObject(obj)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+
+		for name, h := range refs {
+			ref := plumbing.NewHashReference(name, plumbing.Hash(h))
+			if err := s.SetReference(ref); err != nil {
+				return false, err
+			}
+		}
+
+		return true, nil
+	})
+	require.NoError(t, err)
+}
+
+// requireNoReferencesOf checks that the rooted repository of the given init
+// commit has no references of the repository with the given ID.
+func requireNoReferencesOf(
+	t *testing.T,
+	tx repository.RootedTransactioner,
+	ls lock.Session,
+	init model.SHA1,
+	id kallax.ULID,
+) {
+	err := withRootedRepository(tx, ls, init, func(s gitstorage.Storer) (bool, error) {
+		iter, err := s.IterReferences()
+		if err != nil {
+			return false, err
+		}
+
+		return false, iter.ForEach(func(ref *plumbing.Reference) error {
+			require.False(t, strings.Contains(ref.Name().String(), id.String()),
+				"%s: %s", init, ref.Name())
+			return nil
+		})
+	})
+	require.NoError(t, err)
+}
+
+func TestPurge_History(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	remote := memfs.New()
+	copier := repository.NewLocalCopier(remote)
+	tx := repository.NewSivaRootedTransactioner(copier, memfs.New())
+	ls, err := lock.NewLocal().NewSession(&lock.SessionConfig{TTL: 10 * time.Second})
+	require.NoError(err)
+	defer ls.Close()
+
+	master := model.NewSHA1("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	branch := model.NewSHA1("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	init := model.NewSHA1("b029517f6300c2da0f4b651b8642506cd6aaf45d")
+	// the previous tip of master is kept in the rooted repository of its
+	// former init commit, which the repository no longer references
+	oldInit := model.NewSHA1("0000000000000000000000000000000000000001")
+
+	store := storage.Local()
+	r := model.NewRepository()
+	r.Endpoints = []string{"git://purged"}
+	r.References = []*model.Reference{
+		{Name: "refs/heads/master", Hash: master, Init: init},
+	}
+	require.NoError(store.Create(r))
+
+	now := time.Now()
+	require.NoError(store.UpdateReferences(r, r.References, nil, []*storage.ReferenceChange{{
+		Name:    "refs/heads/master",
+		Action:  "update",
+		OldHash: branch,
+		OldInit: oldInit,
+		NewHash: master,
+		NewInit: init,
+		Forced:  true,
+		Time:    now,
+	}}))
+
+	writeRootedRepository(t, tx, ls, init, map[plumbing.ReferenceName]model.SHA1{
+		plumbing.ReferenceName(fmt.Sprintf("refs/heads/master/%s", r.ID)): master,
+	})
+	writeRootedRepository(t, tx, ls, oldInit, map[plumbing.ReferenceName]model.SHA1{
+		historyReferenceName("refs/borges/history", r.ID, "refs/heads/master", now): branch,
+	})
+
+	w := NewRootedRewriter(copier, memfs.New())
+	require.NoError(Purge(store, w, ls, r))
+
+	for _, init := range []model.SHA1{init, oldInit} {
+		requireNoReferencesOf(t, tx, ls, init, r.ID)
+	}
+
+	_, err = store.Get(r.ID)
+	require.Equal(kallax.ErrNotFound, err)
+}
@@ -0,0 +1,225 @@
+package borges
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/inconshreveable/log15"
+	"github.com/src-d/borges/storage"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	gitstorage "gopkg.in/src-d/go-git.v4/storage"
+)
+
+// recoverPendingCommit recovers the references of a repository whose last job
+// was interrupted while committing its changes, before the repository was
+// updated. The references of the repository in the rooted repositories of
+// the pending commit are replaced by the ones in those rooted repositories,
+// and only those are stored, along with their changes made by the given job.
+// A reference moved to another rooted repository is recorded as deleted from
+// one and created in the other.
+func (a *Archiver) recoverPendingCommit(
+	log log15.Logger,
+	r *model.Repository,
+	job string,
+	now time.Time,
+) error {
+	inits, err := a.Store.PendingCommit(r.ID)
+	if err != nil || len(inits) == 0 {
+		return err
+	}
+
+	log.Warn("recovering references of an interrupted commit", "roots", len(inits))
+	previous := r.References
+	var changes []*storage.ReferenceChange
+	for _, ic := range inits {
+		var refs []*model.Reference
+		err := withRootedRepository(a.RootedTransactioner, a.LockSession, ic,
+			func(s gitstorage.Storer) (bool, error) {
+				var err error
+				refs, err = rootedReferences(s, ic, r)
+				if err != nil {
+					return false, err
+				}
+
+				graph := newLazyCommitGraph(s)
+				defer func() { _ = graph.Close() }()
+
+				commands := recoveredCommands(r.References, ic, refs)
+				ffs := fastForwards(log, graphAncestry{graph}, commands)
+				changes = append(changes, referenceChanges(commands, ffs, job, now)...)
+				return false, nil
+			})
+		if err != nil {
+			return err
+		}
+
+		r.References = replaceReferences(r.References, ic, refs)
+	}
+
+	updated, deleted := referencesDiff(previous, r.References)
+	if err := a.Store.UpdateReferences(r, updated, deleted, changes); err != nil {
+		return err
+	}
+
+	return a.Store.SetPendingCommit(r, nil)
+}
+
+// clearPendingCommit removes the pending commit of the repository once it is
+// updated. If it cannot be removed, it is recovered by the next job.
+func (a *Archiver) clearPendingCommit(log log15.Logger, r *model.Repository) {
+	if err := a.Store.SetPendingCommit(r, nil); err != nil {
+		log.Error("error removing pending commit", "error", err)
+	}
+}
+
+// rootedReferences returns the references of the repository in the rooted
+// repository with the given init commit and storage.
+func rootedReferences(s gitstorage.Storer, ic model.SHA1, r *model.Repository) ([]*model.Reference, error) {
+	rr, err := git.Open(s, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := fmt.Sprintf("/%s", r.ID)
+	refs, err := gitReferencer{
+		Repository: rr,
+		filter: func(name plumbing.ReferenceName) bool {
+			return strings.HasSuffix(name.String(), suffix)
+		},
+	}.References()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		ref.Name = strings.TrimSuffix(ref.Name, suffix)
+		ref.Init = ic
+	}
+
+	return refs, nil
+}
+
+// recoveredCommands returns the commands that replace the references with the
+// given init commit by the given ones.
+func recoveredCommands(refs []*model.Reference, ic model.SHA1, with []*model.Reference) []*Command {
+	old := make(map[string]*model.Reference)
+	for _, ref := range refs {
+		if ref.Init == ic {
+			old[ref.Name] = ref
+		}
+	}
+
+	var commands []*Command
+	for _, ref := range with {
+		o, ok := old[ref.Name]
+		delete(old, ref.Name)
+		switch {
+		case !ok:
+			commands = append(commands, &Command{New: ref})
+		case o.Hash != ref.Hash:
+			commands = append(commands, &Command{Old: o, New: ref})
+		}
+	}
+
+	for _, ref := range old {
+		commands = append(commands, &Command{Old: ref})
+	}
+
+	return commands
+}
+
+// replaceReferences replaces the references with the given init commit by the
+// given ones.
+func replaceReferences(refs []*model.Reference, ic model.SHA1, with []*model.Reference) []*model.Reference {
+	var result []*model.Reference
+	for _, ref := range refs {
+		if ref.Init != ic {
+			result = append(result, ref)
+		}
+	}
+
+	return append(result, with...)
+}
+
+// UpdateReferences stores the changes in the references of the repository
+// since they were the given previous ones, so only the references that were
+// created, updated or deleted are written. It is used when the references
+// are replaced without commands, such as when they are repaired.
+func UpdateReferences(store storage.RepoStore, r *model.Repository, previous []*model.Reference) error {
+	updated, deleted := referencesDiff(previous, r.References)
+	if len(updated) == 0 && len(deleted) == 0 {
+		return nil
+	}
+
+	return store.UpdateReferences(r, updated, deleted, nil)
+}
+
+// updateAppliedReferences stores the references of the repository created,
+// updated or deleted by the given commands, which were applied to it, along
+// with the given changes of their history.
+func updateAppliedReferences(
+	store storage.RepoStore,
+	r *model.Repository,
+	commands []*Command,
+	history []*storage.ReferenceChange,
+) error {
+	updated, deleted := appliedReferences(commands)
+	if len(updated) == 0 && len(deleted) == 0 && len(history) == 0 {
+		return nil
+	}
+
+	return store.UpdateReferences(r, updated, deleted, history)
+}
+
+// appliedReferences returns the references created or updated by the given
+// commands, and the names of the references they delete. A reference whose
+// init commit changed is deleted and created again, so it is only updated.
+func appliedReferences(commands []*Command) ([]*model.Reference, []string) {
+	var updated []*model.Reference
+	created := make(map[string]bool)
+	for _, c := range commands {
+		if c.New != nil {
+			updated = append(updated, c.New)
+			created[c.New.Name] = true
+		}
+	}
+
+	var deleted []string
+	for _, c := range commands {
+		if c.Action() == Delete && !created[c.Old.Name] {
+			deleted = append(deleted, c.Old.Name)
+		}
+	}
+
+	sort.Strings(deleted)
+	return updated, deleted
+}
+
+// referencesDiff returns the references in refs that are new or different
+// from the previous ones, and the names of the previous references that are
+// not in refs anymore.
+func referencesDiff(previous, refs []*model.Reference) ([]*model.Reference, []string) {
+	old := refsByName(previous)
+	var updated []*model.Reference
+	for _, ref := range refs {
+		o, ok := old[ref.Name]
+		delete(old, ref.Name)
+		if ok && o.Hash == ref.Hash && o.Init == ref.Init {
+			continue
+		}
+
+		updated = append(updated, ref)
+	}
+
+	deleted := make([]string, 0, len(old))
+	for name := range old {
+		deleted = append(deleted, name)
+	}
+
+	sort.Strings(deleted)
+	return updated, deleted
+}
@@ -0,0 +1,135 @@
+package borges
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestRootedReferences(t *testing.T) {
+	require := require.New(t)
+
+	r := model.NewRepository()
+	h := newSyntheticHistory()
+	root := h.commit()
+	master := h.commit(root)
+	// the roots of the references of other repositories are not computed
+	broken := h.commit(plumbing.NewHash("0000000000000000000000000000000000000001"))
+	syntheticRepository(t, h, map[string]plumbing.Hash{
+		fmt.Sprintf("refs/heads/master/%s", r.ID):             master,
+		fmt.Sprintf("refs/tags/v1/%s", r.ID):                  root,
+		fmt.Sprintf("refs/heads/master/%s", kallax.NewULID()): broken,
+	})
+
+	refs, err := rootedReferences(h.storage, model.SHA1(root), r)
+	require.NoError(err)
+
+	byName := refsByName(refs)
+	require.Len(byName, 2)
+	require.Equal(model.SHA1(master), byName["refs/heads/master"].Hash)
+	require.Equal(model.SHA1(root), byName["refs/heads/master"].Init)
+	require.Equal(model.SHA1(root), byName["refs/tags/v1"].Hash)
+	require.Equal(model.SHA1(root), byName["refs/tags/v1"].Init)
+}
+
+func TestRecoveredCommands(t *testing.T) {
+	require := require.New(t)
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	refs := []*model.Reference{
+		{Name: "refs/heads/master", Hash: a, Init: a},
+		{Name: "refs/heads/same", Hash: a, Init: a},
+		{Name: "refs/heads/old", Hash: a, Init: a},
+		{Name: "refs/heads/other", Hash: a, Init: b},
+	}
+
+	recovered := []*model.Reference{
+		{Name: "refs/heads/master", Hash: b, Init: a},
+		{Name: "refs/heads/same", Hash: a, Init: a},
+		{Name: "refs/heads/new", Hash: b, Init: a},
+	}
+
+	require.Equal([]*Command{
+		{Old: refs[0], New: recovered[0]},
+		{New: recovered[2]},
+		{Old: refs[2]},
+	}, recoveredCommands(refs, a, recovered))
+}
+
+func TestReplaceReferences(t *testing.T) {
+	require := require.New(t)
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	refs := []*model.Reference{
+		{Name: "refs/heads/master", Init: a},
+		{Name: "refs/heads/old", Init: a},
+		{Name: "refs/heads/other", Init: b},
+	}
+
+	recovered := []*model.Reference{
+		{Name: "refs/heads/master", Init: a, Hash: a},
+		{Name: "refs/heads/new", Init: a},
+	}
+
+	result := replaceReferences(refs, a, recovered)
+	require.Equal([]*model.Reference{refs[2], recovered[0], recovered[1]}, result)
+}
+
+func TestReferencesDiff(t *testing.T) {
+	require := require.New(t)
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	previous := []*model.Reference{
+		{Name: "refs/heads/master", Hash: a, Init: a},
+		{Name: "refs/heads/same", Hash: a, Init: a},
+		{Name: "refs/heads/removed", Hash: a, Init: a},
+	}
+
+	refs := []*model.Reference{
+		{Name: "refs/heads/master", Hash: b, Init: a},
+		{Name: "refs/heads/same", Hash: a, Init: a},
+		{Name: "refs/heads/new", Hash: b, Init: b},
+	}
+
+	updated, deleted := referencesDiff(previous, refs)
+	require.Equal([]*model.Reference{refs[0], refs[2]}, updated)
+	require.Equal([]string{"refs/heads/removed"}, deleted)
+}
+
+func TestAppliedReferences(t *testing.T) {
+	require := require.New(t)
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	commands := []*Command{
+		{Old: &model.Reference{Name: "refs/heads/master", Hash: a, Init: a},
+			New: &model.Reference{Name: "refs/heads/master", Hash: b, Init: a}},
+		{Old: &model.Reference{Name: "refs/heads/removed", Hash: a, Init: a}},
+		{New: &model.Reference{Name: "refs/heads/new", Hash: b, Init: b}},
+		// the init commit of moved changes from a to b
+		{Old: &model.Reference{Name: "refs/heads/moved", Hash: a, Init: a}},
+		{New: &model.Reference{Name: "refs/heads/moved", Hash: b, Init: b}},
+	}
+
+	updated, deleted := appliedReferences(commands)
+	require.Equal([]*model.Reference{commands[0].New, commands[2].New, commands[4].New}, updated)
+	require.Equal([]string{"refs/heads/removed"}, deleted)
+}
+
+func TestSortedInits(t *testing.T) {
+	require := require.New(t)
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	c := model.NewSHA1("0000000000000000000000000000000000000003")
+	changes := Changes{c: nil, a: nil, b: nil}
+
+	require.Equal([]model.SHA1{a, b, c}, sortedInits(changes))
+}
@@ -0,0 +1,154 @@
+package borges
+
+import (
+	"strings"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// RefFilter selects references by their names. A reference is selected if
+// it matches any of the Include patterns, or there are none, and it does not
+// match any of the Exclude patterns. In patterns, * matches any sequence of
+// characters, slashes included, so refs/pull/* matches refs/pull/1/head. A
+// nil RefFilter selects all references.
+type RefFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Match returns whether the reference with the given name is selected.
+func (f *RefFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Include) > 0 && !matchAnyPattern(f.Include, name) {
+		return false
+	}
+
+	return !matchAnyPattern(f.Exclude, name)
+}
+
+// RefSpecs returns the refspecs used to fetch the references selected by the
+// filter out of the given ones advertised by the remote. Include patterns are
+// used as refspecs when they can be, otherwise the selected references are
+// fetched by name, so excluded references are never downloaded. It returns
+// no refspecs if none of the advertised references is selected.
+func (f *RefFilter) RefSpecs(advertised []plumbing.ReferenceName) []config.RefSpec {
+	if f == nil {
+		return []config.RefSpec{FetchRefSpec}
+	}
+
+	if !f.byName() {
+		if len(f.Include) == 0 {
+			return []config.RefSpec{FetchRefSpec}
+		}
+
+		var specs []config.RefSpec
+		for _, p := range f.Include {
+			specs = append(specs, config.RefSpec("+"+p+":"+p))
+		}
+
+		return specs
+	}
+
+	var specs []config.RefSpec
+	for _, name := range advertised {
+		if name == plumbing.HEAD || !f.Match(name.String()) {
+			continue
+		}
+
+		specs = append(specs, config.RefSpec("+"+name+":"+name))
+	}
+
+	return specs
+}
+
+// byName returns whether the selected references must be fetched by name
+// because the filter cannot be expressed as refspecs, which is the case if
+// it has Exclude patterns or Include patterns with more than one wildcard.
+func (f *RefFilter) byName() bool {
+	if f == nil {
+		return false
+	}
+
+	if len(f.Exclude) > 0 {
+		return true
+	}
+
+	for _, p := range f.Include {
+		if strings.Count(p, "*") > 1 {
+			// git refspecs only have one wildcard
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchAnyPattern(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if matchPattern(p, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchPattern(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+
+	name = name[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+
+		name = name[i+len(part):]
+	}
+
+	return len(name) >= len(last) && strings.HasSuffix(name, last)
+}
+
+// NewFilteredReferencer returns a Referencer with the references of r
+// selected by the given filter.
+func NewFilteredReferencer(r Referencer, f *RefFilter) Referencer {
+	if f == nil {
+		return r
+	}
+
+	return filteredReferencer{r, f}
+}
+
+type filteredReferencer struct {
+	Referencer
+	filter *RefFilter
+}
+
+func (r filteredReferencer) References() ([]*model.Reference, error) {
+	refs, err := r.Referencer.References()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*model.Reference
+	for _, ref := range refs {
+		if r.filter.Match(ref.Name) {
+			result = append(result, ref)
+		}
+	}
+
+	return result, nil
+}
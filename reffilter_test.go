@@ -0,0 +1,117 @@
+package borges
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestRefFilter_Match(t *testing.T) {
+	filter := &RefFilter{
+		Include: []string{"refs/heads/*", "refs/tags/*", "refs/pull/*/head"},
+		Exclude: []string{"refs/heads/tmp-*", "refs/pull/1*"},
+	}
+
+	for name, expected := range map[string]bool{
+		"refs/heads/master":       true,
+		"refs/heads/feature/foo":  true,
+		"refs/heads/tmp-foo":      false,
+		"refs/tags/v1.0.0":        true,
+		"refs/pull/2/head":        true,
+		"refs/pull/2/merge":       false,
+		"refs/pull/12/head":       false,
+		"refs/changes/01/1/1":     false,
+		"refs/merge-requests/1/a": false,
+	} {
+		require.Equal(t, expected, filter.Match(name), name)
+	}
+
+	var nilFilter *RefFilter
+	require.True(t, nilFilter.Match("refs/pull/1/head"))
+
+	filter = &RefFilter{Exclude: []string{"refs/pull/*", "refs/merge-requests/*"}}
+	require.True(t, filter.Match("refs/heads/master"))
+	require.False(t, filter.Match("refs/pull/1/head"))
+	require.False(t, filter.Match("refs/merge-requests/1/head"))
+}
+
+func TestMatchPattern(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		expected      bool
+	}{
+		{"refs/heads/master", "refs/heads/master", true},
+		{"refs/heads/master", "refs/heads/master2", false},
+		{"*", "refs/heads/master", true},
+		{"refs/*/master", "refs/heads/master", true},
+		{"refs/*/master", "refs/remotes/origin/master", true},
+		{"refs/*/master", "refs/heads/main", false},
+		{"refs/*/*/head", "refs/pull/1/head", true},
+		{"refs/*/*/head", "refs/pull/head", false},
+		{"refs/*ab*ab", "refs/ab", false},
+		{"refs/*ab*ab", "refs/abab", true},
+	} {
+		require.Equal(t, tc.expected, matchPattern(tc.pattern, tc.name),
+			"%s %s", tc.pattern, tc.name)
+	}
+}
+
+func TestRefFilter_RefSpecs(t *testing.T) {
+	require := require.New(t)
+
+	advertised := []plumbing.ReferenceName{
+		plumbing.HEAD,
+		"refs/heads/master",
+		"refs/pull/1/head",
+		"refs/pull/1/merge",
+		"refs/tags/v1",
+	}
+
+	var filter *RefFilter
+	require.Equal([]config.RefSpec{FetchRefSpec}, filter.RefSpecs(advertised))
+
+	filter = &RefFilter{Include: []string{"refs/heads/*", "refs/tags/v1"}}
+	require.Equal([]config.RefSpec{
+		"+refs/heads/*:refs/heads/*",
+		"+refs/tags/v1:refs/tags/v1",
+	}, filter.RefSpecs(advertised))
+
+	filter = &RefFilter{Exclude: []string{"refs/pull/*"}}
+	require.Equal([]config.RefSpec{
+		"+refs/heads/master:refs/heads/master",
+		"+refs/tags/v1:refs/tags/v1",
+	}, filter.RefSpecs(advertised))
+
+	filter = &RefFilter{Include: []string{"refs/heads/*", "refs/*/*/head"}}
+	require.Equal([]config.RefSpec{
+		"+refs/heads/master:refs/heads/master",
+		"+refs/pull/1/head:refs/pull/1/head",
+	}, filter.RefSpecs(advertised))
+
+	filter = &RefFilter{Exclude: []string{"*"}}
+	require.Len(filter.RefSpecs(advertised), 0)
+}
+
+func TestNewFilteredReferencer(t *testing.T) {
+	require := require.New(t)
+
+	r := NewModelReferencer(&model.Repository{References: []*model.Reference{
+		{Name: "refs/heads/master"},
+		{Name: "refs/pull/1/head"},
+		{Name: "refs/tags/v1"},
+	}})
+
+	refs, err := NewFilteredReferencer(r, &RefFilter{
+		Exclude: []string{"refs/pull/*"},
+	}).References()
+	require.NoError(err)
+	require.Equal([]*model.Reference{
+		{Name: "refs/heads/master"},
+		{Name: "refs/tags/v1"},
+	}, refs)
+
+	require.Equal(r, NewFilteredReferencer(r, nil))
+}
@@ -0,0 +1,188 @@
+package borges
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges/storage"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/go-errors.v0"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+// ErrRepositoryExists is returned when a repository found while reindexing is
+// already in the store.
+var ErrRepositoryExists = errors.NewKind("repository %s already exists")
+
+// reindexedRepository is a repository rebuilt from rooted repositories.
+type reindexedRepository struct {
+	*model.Repository
+	head    string
+	shallow []model.SHA1
+}
+
+// Reindex rebuilds the repositories archived in the rooted repositories of the
+// given init commits and creates them in the store, which must not contain
+// any of them. Repositories are found in the remote configuration written by
+// StoreConfig, and their references, HEAD and shallow commits in the
+// references of the rooted repositories. They are created as fetched. It
+// returns the number of repositories created.
+func Reindex(store storage.RepoStore, tx repository.RootedTransactioner, inits []model.SHA1) (int, error) {
+	repos := make(map[kallax.ULID]*reindexedRepository)
+	for _, init := range inits {
+		if err := reindexRootedRepository(tx, init, repos); err != nil {
+			return 0, fmt.Errorf("rooted repository %s: %s", init, err)
+		}
+	}
+
+	sorted := make([]*reindexedRepository, 0, len(repos))
+	for _, r := range repos {
+		sorted = append(sorted, r)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID.String() < sorted[j].ID.String()
+	})
+
+	for i, r := range sorted {
+		if _, err := store.Get(r.ID); err == nil {
+			return i, ErrRepositoryExists.New(r.ID.String())
+		} else if err != kallax.ErrNotFound {
+			return i, err
+		}
+
+		if err := store.Create(r.Repository); err != nil {
+			return i, err
+		}
+
+		if err := store.SetHead(r.Repository, r.head); err != nil {
+			return i, err
+		}
+
+		if len(r.shallow) > 0 {
+			err := store.SetShallow(r.Repository, &storage.Shallow{Commits: r.shallow})
+			if err != nil {
+				return i, err
+			}
+		}
+	}
+
+	return len(sorted), nil
+}
+
+func reindexRootedRepository(
+	tx repository.RootedTransactioner,
+	init model.SHA1,
+	repos map[kallax.ULID]*reindexedRepository,
+) (err error) {
+	t, err := tx.Begin(plumbing.Hash(init))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if rErr := t.Rollback(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}()
+
+	rr, err := git.Open(t.Storer(), nil)
+	if err != nil {
+		return err
+	}
+
+	c, err := rr.Config()
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]*reindexedRepository)
+	for _, ss := range c.Raw.Section("remote").Subsections {
+		u, err := uuid.FromString(ss.Name)
+		if err != nil {
+			continue
+		}
+
+		id := kallax.ULID(u)
+		r, ok := repos[id]
+		if !ok {
+			r = &reindexedRepository{Repository: &model.Repository{
+				ID:        id,
+				Endpoints: ss.Options.GetAll("url"),
+				Status:    model.Fetched,
+			}}
+
+			if isFork, err := strconv.ParseBool(ss.Option("isfork")); err == nil {
+				r.IsFork = &isFork
+			}
+
+			repos[id] = r
+		}
+
+		found[ss.Name] = r
+	}
+
+	refs, err := NewGitReferencer(rr).References()
+	if err != nil {
+		return err
+	}
+
+	shallow, err := shallowCommits(rr.Storer)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		i := strings.LastIndex(ref.Name, "/")
+		if i < 0 {
+			continue
+		}
+
+		r, ok := found[ref.Name[i+1:]]
+		if !ok {
+			continue
+		}
+
+		ref.Name = ref.Name[:i]
+		ref.Init = init
+		for _, root := range ref.Roots {
+			if shallow[plumbing.Hash(root)] && !containsSHA1(r.shallow, root) {
+				r.shallow = append(r.shallow, root)
+			}
+		}
+
+		r.References = append(r.References, ref)
+	}
+
+	for id, r := range found {
+		head, err := rr.Storer.Reference(plumbing.ReferenceName(
+			fmt.Sprintf("refs/remotes/%s/HEAD", id),
+		))
+		if err == plumbing.ErrReferenceNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		r.head = strings.TrimSuffix(head.Target().String(), "/"+id)
+	}
+
+	return nil
+}
+
+func containsSHA1(hashes []model.SHA1, h model.SHA1) bool {
+	for _, hash := range hashes {
+		if hash == h {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package borges
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/src-d/borges/storage"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestReindex(t *testing.T) {
+	require := require.New(t)
+
+	fork := model.NewRepository()
+	fork.Endpoints = []string{"git://fork"}
+	isFork := true
+	fork.IsFork = &isFork
+	shallow := model.NewRepository()
+	shallow.Endpoints = []string{"git://shallow"}
+
+	h := newSyntheticHistory()
+	root := h.commit()
+	master := h.commit(root)
+	dev := h.commit(master)
+	rooted := syntheticRepository(t, h, map[string]plumbing.Hash{
+		fmt.Sprintf("refs/heads/master/%s", fork.ID):          master,
+		fmt.Sprintf("refs/heads/dev/%s", shallow.ID):          dev,
+		fmt.Sprintf("refs/heads/master/%s", kallax.NewULID()): root,
+	})
+	require.NoError(StoreConfig(rooted, fork))
+	require.NoError(StoreConfig(rooted, shallow))
+	require.NoError(StoreHead(rooted, fork.ID, "refs/heads/master"))
+
+	sh := newSyntheticHistory()
+	cut := sh.commit(plumbing.NewHash("0000000000000000000000000000000000000001"))
+	tip := sh.commit(cut)
+	require.NoError(sh.storage.SetShallow([]plumbing.Hash{cut}))
+	shallowRooted := syntheticRepository(t, sh, map[string]plumbing.Hash{
+		fmt.Sprintf("refs/heads/master/%s", shallow.ID): tip,
+	})
+	require.NoError(StoreConfig(shallowRooted, shallow))
+	require.NoError(StoreHead(shallowRooted, shallow.ID, "refs/heads/master"))
+
+	tx := memoryTransactioner{
+		root:                                   h.storage,
+		plumbing.Hash(ShallowInit(shallow.ID)): sh.storage,
+	}
+	inits := []model.SHA1{model.SHA1(root), ShallowInit(shallow.ID)}
+
+	repos := make(map[kallax.ULID]*reindexedRepository)
+	for _, init := range inits {
+		require.NoError(reindexRootedRepository(tx, init, repos))
+	}
+
+	require.Len(repos, 2)
+
+	r := repos[fork.ID]
+	require.Equal(fork.Endpoints, r.Endpoints)
+	require.Equal(model.Fetched, r.Status)
+	require.NotNil(r.IsFork)
+	require.True(*r.IsFork)
+	require.Equal("refs/heads/master", r.head)
+	require.Len(r.shallow, 0)
+	require.Len(r.References, 1)
+	require.Equal("refs/heads/master", r.References[0].Name)
+	require.Equal(model.SHA1(master), r.References[0].Hash)
+	require.Equal(model.SHA1(root), r.References[0].Init)
+	require.Equal([]model.SHA1{model.SHA1(root)}, r.References[0].Roots)
+
+	r = repos[shallow.ID]
+	require.Equal(shallow.Endpoints, r.Endpoints)
+	require.NotNil(r.IsFork)
+	require.False(*r.IsFork)
+	require.Equal("refs/heads/master", r.head)
+	require.Equal([]model.SHA1{model.SHA1(cut)}, r.shallow)
+	refs := refsByName(r.References)
+	require.Len(refs, 2)
+	require.Equal(model.SHA1(root), refs["refs/heads/dev"].Init)
+	require.Equal(ShallowInit(shallow.ID), refs["refs/heads/master"].Init)
+	require.Equal(model.SHA1(tip), refs["refs/heads/master"].Hash)
+
+	store := storage.Local()
+	n, err := Reindex(store, tx, inits)
+	require.NoError(err)
+	require.Equal(2, n)
+
+	head, err := store.Head(fork.ID)
+	require.NoError(err)
+	require.Equal("refs/heads/master", head)
+
+	s, err := store.Shallow(shallow.ID)
+	require.NoError(err)
+	require.Equal(&storage.Shallow{Commits: []model.SHA1{model.SHA1(cut)}}, s)
+
+	_, err = Reindex(store, tx, inits)
+	require.True(ErrRepositoryExists.Is(err))
+}
@@ -0,0 +1,220 @@
+package borges
+
+import (
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+// rootSet is an ordered set of root commits. Sets are never modified once
+// they are built, so the same set is shared by all the commits with the same
+// roots. A set extending another one shares its storage with it when
+// possible, so the roots are not copied on every merge.
+type rootSet struct {
+	data *rootSetData
+	n    int
+}
+
+// rootSetData is the storage shared by sets. Each set uses the first n roots.
+type rootSetData struct {
+	roots []model.SHA1
+	index map[model.SHA1]int
+}
+
+func newRootSet(roots ...model.SHA1) *rootSet {
+	s := &rootSet{data: &rootSetData{index: make(map[model.SHA1]int)}}
+	return s.extend(roots)
+}
+
+func (s *rootSet) roots() []model.SHA1 {
+	return s.data.roots[:s.n]
+}
+
+func (s *rootSet) contains(h model.SHA1) bool {
+	i, ok := s.data.index[h]
+	return ok && i < s.n
+}
+
+// extend returns a new set with the roots of s followed by the given ones,
+// that must not be in s.
+func (s *rootSet) extend(roots []model.SHA1) *rootSet {
+	data := s.data
+	if s.n != len(data.roots) {
+		// the storage was already extended by another set
+		data = &rootSetData{index: make(map[model.SHA1]int, s.n+len(roots))}
+		for _, h := range s.roots() {
+			data.index[h] = len(data.roots)
+			data.roots = append(data.roots, h)
+		}
+	}
+
+	for _, h := range roots {
+		if _, ok := data.index[h]; ok {
+			continue
+		}
+
+		data.index[h] = len(data.roots)
+		data.roots = append(data.roots, h)
+	}
+
+	return &rootSet{data: data, n: len(data.roots)}
+}
+
+// unionRootSets returns the union of the given sets, keeping the order of the
+// sets and of the roots inside them. If the union is equal to the first set,
+// that set is returned instead of a new one.
+func unionRootSets(sets []*rootSet) *rootSet {
+	result := sets[0]
+	for _, s := range sets[1:] {
+		if s == result {
+			continue
+		}
+
+		var missing []model.SHA1
+		for _, h := range s.roots() {
+			if !result.contains(h) {
+				missing = append(missing, h)
+			}
+		}
+
+		if len(missing) > 0 {
+			result = result.extend(missing)
+		}
+	}
+
+	return result
+}
+
+// rootsFinder finds the root commits reachable from commits, that is, the
+// commits with no parents. The roots of a commit are the roots of its
+// parents, in the order of the parents and without duplicates, or the commit
+// itself if it has no parents. It also finds the init commit of commits, the
+// root reached following the first parent of each commit.
+//
+// The roots of every visited commit are kept, so when the finder is used for
+// several references each commit is only visited once. Commits are visited
+// with an iterative post-order walk, so long histories do not grow the call
+// stack.
+type rootsFinder struct {
+	graph commitParents
+	roots map[plumbing.Hash]*rootSet
+	inits map[plumbing.Hash]model.SHA1
+}
+
+func newRootsFinder(graph commitParents) *rootsFinder {
+	return &rootsFinder{
+		graph: graph,
+		roots: make(map[plumbing.Hash]*rootSet),
+		inits: make(map[plumbing.Hash]model.SHA1),
+	}
+}
+
+// add sets the roots of a commit, so its history is not walked to find its
+// roots. Its init commit is still computed following its first parents.
+func (f *rootsFinder) add(h plumbing.Hash, roots []model.SHA1) {
+	f.roots[h] = newRootSet(roots...)
+}
+
+// find returns the roots of the given commit.
+func (f *rootsFinder) find(start plumbing.Hash) ([]model.SHA1, error) {
+	if err := f.walk(start); err != nil {
+		return nil, err
+	}
+
+	return append([]model.SHA1(nil), f.roots[start].roots()...), nil
+}
+
+// walk computes the roots of all the commits reachable from start whose roots
+// are not known yet. Each frame of the stack is a commit whose parents are
+// being visited; once all of them have roots, the roots of the commit are the
+// union of theirs. As histories have no cycles, a commit in the stack is never
+// reached again until its roots are known.
+func (f *rootsFinder) walk(start plumbing.Hash) error {
+	type frame struct {
+		hash    plumbing.Hash
+		parents []plumbing.Hash
+		cursor  int
+	}
+
+	var stack []*frame
+	visit := func(h plumbing.Hash) error {
+		if _, ok := f.roots[h]; ok {
+			return nil
+		}
+
+		parents, err := f.graph.parents(h)
+		if err != nil {
+			return err
+		}
+
+		if len(parents) == 0 {
+			f.roots[h] = newRootSet(model.SHA1(h))
+			f.inits[h] = model.SHA1(h)
+			return nil
+		}
+
+		stack = append(stack, &frame{hash: h, parents: parents})
+		return nil
+	}
+
+	if err := visit(start); err != nil {
+		return err
+	}
+
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if top.cursor < len(top.parents) {
+			top.cursor++
+			if err := visit(top.parents[top.cursor-1]); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		sets := make([]*rootSet, len(top.parents))
+		for i, p := range top.parents {
+			sets[i] = f.roots[p]
+		}
+
+		f.roots[top.hash] = unionRootSets(sets)
+		if init, ok := f.inits[top.parents[0]]; ok {
+			f.inits[top.hash] = init
+		}
+
+		stack = stack[:len(stack)-1]
+	}
+
+	return nil
+}
+
+// init returns the init commit of the given commit. Only the commits whose
+// init commit is not known yet are visited.
+func (f *rootsFinder) init(start plumbing.Hash) (model.SHA1, error) {
+	var chain []plumbing.Hash
+	var init model.SHA1
+	for h := start; ; {
+		if known, ok := f.inits[h]; ok {
+			init = known
+			break
+		}
+
+		chain = append(chain, h)
+		parents, err := f.graph.parents(h)
+		if err != nil {
+			return model.SHA1{}, err
+		}
+
+		if len(parents) == 0 {
+			init = model.SHA1(h)
+			break
+		}
+
+		h = parents[0]
+	}
+
+	for _, h := range chain {
+		f.inits[h] = init
+	}
+
+	return init, nil
+}
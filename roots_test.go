@@ -0,0 +1,199 @@
+package borges
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+)
+
+// syntheticHistory builds commits with the given parents in an in-memory
+// storage. All the commits have the same empty tree.
+type syntheticHistory struct {
+	storage *memory.Storage
+	tree    plumbing.Hash
+	n       int
+}
+
+func newSyntheticHistory() *syntheticHistory {
+	h := &syntheticHistory{storage: memory.NewStorage()}
+	obj := h.storage.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(obj); err != nil {
+		panic(err)
+	}
+
+	tree, err := h.storage.SetEncodedObject(obj)
+	if err != nil {
+		panic(err)
+	}
+
+	h.tree = tree
+	return h
+}
+
+func (h *syntheticHistory) commit(parents ...plumbing.Hash) plumbing.Hash {
+	h.n++
+	sig := object.Signature{
+		Name:  "borges",
+		Email: "borges@example.com",
+		When:  time.Unix(int64(h.n), 0),
+	}
+
+	c := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      fmt.Sprintf("commit %d", h.n),
+		TreeHash:     h.tree,
+		ParentHashes: parents,
+	}
+
+	obj := h.storage.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		panic(err)
+	}
+
+	hash, err := h.storage.SetEncodedObject(obj)
+	if err != nil {
+		panic(err)
+	}
+
+	return hash
+}
+
+// manyRoots returns a history where n unrelated histories are merged one by
+// one into the main line, as in subtree merges or monorepo imports.
+func manyRoots(n int) (*syntheticHistory, plumbing.Hash, []model.SHA1) {
+	h := newSyntheticHistory()
+	head := h.commit()
+	roots := []model.SHA1{model.SHA1(head)}
+	for i := 1; i < n; i++ {
+		root := h.commit()
+		roots = append(roots, model.SHA1(root))
+		head = h.commit(head, h.commit(root))
+	}
+
+	return h, head, roots
+}
+
+// mergeChain returns a history with a single root and n merges, each of them
+// merging a short branch forked from the previous merge.
+func mergeChain(n int) (*syntheticHistory, plumbing.Hash, []model.SHA1) {
+	h := newSyntheticHistory()
+	root := h.commit()
+	head := root
+	for i := 0; i < n; i++ {
+		side := h.commit(head)
+		head = h.commit(h.commit(head), side)
+	}
+
+	return h, head, []model.SHA1{model.SHA1(root)}
+}
+
+func TestRootsFinder(t *testing.T) {
+	for name, build := range map[string]func(int) (*syntheticHistory, plumbing.Hash, []model.SHA1){
+		"many roots":  manyRoots,
+		"merge chain": mergeChain,
+	} {
+		t.Run(name, func(t *testing.T) {
+			require := require.New(t)
+			h, head, expected := build(100)
+
+			roots, err := newRootsFinder(objectParents{h.storage}).find(head)
+			require.NoError(err)
+			require.Equal(expected, roots)
+		})
+	}
+}
+
+func TestRootsFinder_SharedHistory(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit()
+	ab := h.commit(a, b)
+	ba := h.commit(b, a)
+	top := h.commit(ba, ab)
+
+	finder := newRootsFinder(objectParents{h.storage})
+	roots, err := finder.find(ab)
+	require.NoError(err)
+	require.Equal([]model.SHA1{model.SHA1(a), model.SHA1(b)}, roots)
+
+	roots, err = finder.find(top)
+	require.NoError(err)
+	require.Equal([]model.SHA1{model.SHA1(b), model.SHA1(a)}, roots)
+
+	roots[0] = model.SHA1(top)
+	roots, err = finder.find(ba)
+	require.NoError(err)
+	require.Equal([]model.SHA1{model.SHA1(b), model.SHA1(a)}, roots)
+}
+
+func TestRootsFinder_MissingCommit(t *testing.T) {
+	h := newSyntheticHistory()
+	head := h.commit(plumbing.NewHash("0000000000000000000000000000000000000001"))
+
+	_, err := newRootsFinder(objectParents{h.storage}).find(head)
+	require.Equal(t, plumbing.ErrObjectNotFound, err)
+}
+
+func TestRootsFinder_Init(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit()
+	merge := h.commit(h.commit(b), a)
+	head := h.commit(merge)
+
+	finder := newRootsFinder(objectParents{h.storage})
+	roots, err := finder.find(head)
+	require.NoError(err)
+	require.Equal([]model.SHA1{model.SHA1(b), model.SHA1(a)}, roots)
+
+	init, err := finder.init(head)
+	require.NoError(err)
+	require.Equal(model.SHA1(b), init)
+
+	// known roots in a different order do not change the init commit
+	finder = newRootsFinder(objectParents{h.storage})
+	finder.add(merge, []model.SHA1{model.SHA1(a), model.SHA1(b)})
+
+	roots, err = finder.find(head)
+	require.NoError(err)
+	require.Equal([]model.SHA1{model.SHA1(a), model.SHA1(b)}, roots)
+
+	init, err = finder.init(head)
+	require.NoError(err)
+	require.Equal(model.SHA1(b), init)
+}
+
+func benchmarkRootsFinder(b *testing.B, h *syntheticHistory, head plumbing.Hash) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := newRootsFinder(objectParents{h.storage}).find(head); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRootsFinder_ManyRoots1000(b *testing.B) {
+	h, head, _ := manyRoots(1000)
+	benchmarkRootsFinder(b, h, head)
+}
+
+func BenchmarkRootsFinder_ManyRoots5000(b *testing.B) {
+	h, head, _ := manyRoots(5000)
+	benchmarkRootsFinder(b, h, head)
+}
+
+func BenchmarkRootsFinder_MergeChain10000(b *testing.B) {
+	h, head, _ := mergeChain(10000)
+	benchmarkRootsFinder(b, h, head)
+}
@@ -0,0 +1,483 @@
+// Package s3 stores rooted repositories in an S3-compatible object store.
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-errors.v0"
+)
+
+var (
+	// ErrNotFound is returned when the requested object does not exist.
+	ErrNotFound = errors.NewKind("object %s not found")
+	// ErrPreconditionFailed is returned when an object is not written because
+	// it was modified since it was read.
+	ErrPreconditionFailed = errors.NewKind("object %s was modified concurrently")
+	// ErrRequest is returned for any other failed request.
+	ErrRequest = errors.NewKind("%s %s failed with status %d: %s")
+)
+
+// DefaultTimeout is the maximum duration of requests of clients without an
+// HTTPClient, including reading the response body. It is large, as whole siva
+// files are read and written, but it keeps a stalled request from blocking
+// forever.
+const DefaultTimeout = 30 * time.Minute
+
+// defaultHTTPClient is the client used when a Client has no HTTPClient.
+// Besides DefaultTimeout, it gives up early if the store does not accept
+// the connection or does not respond.
+var defaultHTTPClient = &http.Client{
+	Timeout: DefaultTimeout,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   10,
+	},
+}
+
+const (
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	timeFormat       = "20060102T150405Z"
+	dateFormat       = "20060102"
+)
+
+// Client is a minimal client of the S3 API, enough to read and write whole
+// objects. Requests use path-style URLs, <endpoint>/<bucket>/<key>, which
+// are supported by S3 and by S3-compatible stores like MinIO, and are signed
+// with AWS Signature Version 4.
+type Client struct {
+	// Endpoint is the base URL of the store, e.g. https://s3.amazonaws.com.
+	Endpoint string
+	// Region is the region of the bucket, us-east-1 by default.
+	Region string
+	// Bucket is the name of the bucket.
+	Bucket string
+	// AccessKey and SecretKey are the credentials to sign requests with.
+	AccessKey string
+	SecretKey string
+	// HTTPClient is the client used to send requests. By default, a client
+	// with DefaultTimeout is used.
+	HTTPClient *http.Client
+
+	// now returns the time requests are signed at, for testing.
+	now func() time.Time
+}
+
+// Object is the metadata of an object.
+type Object struct {
+	// ETag identifies the version of the object. It changes every time the
+	// object is written.
+	ETag string
+	// Size is the size of the object in bytes.
+	Size int64
+}
+
+// Conditions are the preconditions of a request.
+type Conditions struct {
+	// IfMatch makes the request fail with ErrPreconditionFailed if the
+	// object does not have this ETag.
+	IfMatch string
+	// IfNoneMatch makes a read return nil if the object has this ETag, and
+	// makes a write fail with ErrPreconditionFailed if the object exists
+	// when it is "*".
+	IfNoneMatch string
+}
+
+func (c *Conditions) header() http.Header {
+	h := make(http.Header)
+	if c == nil {
+		return h
+	}
+
+	if c.IfMatch != "" {
+		h.Set("If-Match", c.IfMatch)
+	}
+
+	if c.IfNoneMatch != "" {
+		h.Set("If-None-Match", c.IfNoneMatch)
+	}
+
+	return h
+}
+
+// Head returns the metadata of the object with the given key.
+func (c *Client) Head(key string) (*Object, error) {
+	res, err := c.do("HEAD", key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	return object(res), nil
+}
+
+// Get returns the object with the given key and its content, which must be
+// closed. If the conditions are not met because the object has the ETag of
+// IfNoneMatch, it returns nil and no error.
+func (c *Client) Get(key string, cond *Conditions) (*Object, io.ReadCloser, error) {
+	res, err := c.do("GET", key, nil, cond.header(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return nil, nil, nil
+	}
+
+	return object(res), res.Body, nil
+}
+
+// Put writes an object with the given key and content, returning its ETag.
+func (c *Client) Put(key string, content []byte, cond *Conditions) (string, error) {
+	res, err := c.do("PUT", key, nil, cond.header(), content)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	return res.Header.Get("ETag"), nil
+}
+
+// Delete removes the object with the given key.
+func (c *Client) Delete(key string) error {
+	res, err := c.do("DELETE", key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// List returns the keys of the objects whose keys start with the given
+// prefix, in lexicographical order.
+func (c *Client) List(prefix string) ([]string, error) {
+	q := url.Values{
+		"list-type": {"2"},
+		"prefix":    {prefix},
+	}
+
+	var keys []string
+	for {
+		res, err := c.do("GET", "", q, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range result.Contents {
+			keys = append(keys, o.Key)
+		}
+
+		if !result.IsTruncated {
+			return keys, nil
+		}
+
+		q.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Upload writes an object with the given key and the content read from r,
+// returning its ETag. Content larger than partSize is uploaded in parts of
+// that size with a multipart upload, the conditions are checked when the
+// upload is completed. The upload is aborted if it fails.
+func (c *Client) Upload(key string, r io.Reader, partSize int64, cond *Conditions) (string, error) {
+	part, err := readPart(r, partSize)
+	if err != nil {
+		return "", err
+	}
+
+	if int64(len(part)) < partSize {
+		return c.Put(key, part, cond)
+	}
+
+	id, err := c.createMultipartUpload(key)
+	if err != nil {
+		return "", err
+	}
+
+	etag, err := c.uploadParts(key, id, part, r, partSize, cond)
+	if err != nil {
+		_ = c.abortMultipartUpload(key, id)
+		return "", err
+	}
+
+	return etag, nil
+}
+
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, size)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (c *Client) uploadParts(
+	key, id string,
+	part []byte,
+	r io.Reader,
+	partSize int64,
+	cond *Conditions,
+) (string, error) {
+	var parts []completedPart
+	for n := 1; len(part) > 0; n++ {
+		q := url.Values{
+			"partNumber": {strconv.Itoa(n)},
+			"uploadId":   {id},
+		}
+
+		res, err := c.do("PUT", key, q, nil, part)
+		if err != nil {
+			return "", err
+		}
+		res.Body.Close()
+
+		parts = append(parts, completedPart{n, res.Header.Get("ETag")})
+		if part, err = readPart(r, partSize); err != nil {
+			return "", err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return "", err
+	}
+
+	res, err := c.do("POST", key, url.Values{"uploadId": {id}}, cond.header(), body)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	// errors completing the upload may be reported with a 200 status
+	var result struct {
+		ETag    string
+		Code    string
+		Message string
+	}
+
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	if result.Code == "PreconditionFailed" {
+		return "", ErrPreconditionFailed.New(key)
+	} else if result.Code != "" {
+		return "", ErrRequest.New("POST", key, res.StatusCode, result.Code+": "+result.Message)
+	}
+
+	return result.ETag, nil
+}
+
+func (c *Client) createMultipartUpload(key string) (string, error) {
+	res, err := c.do("POST", key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		UploadId string
+	}
+
+	if err := xml.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+
+	return result.UploadId, nil
+}
+
+func (c *Client) abortMultipartUpload(key, id string) error {
+	res, err := c.do("DELETE", key, url.Values{"uploadId": {id}}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+func object(res *http.Response) *Object {
+	return &Object{
+		ETag: res.Header.Get("ETag"),
+		Size: res.ContentLength,
+	}
+}
+
+// do sends a signed request. Responses with an error status are returned as
+// errors, but 304 Not Modified.
+func (c *Client) do(
+	method, key string,
+	query url.Values,
+	header http.Header,
+	body []byte,
+) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path += "/" + c.Bucket + "/" + key
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req.ContentLength = int64(len(body))
+	c.sign(req, body)
+
+	client := c.HTTPClient
+	if client == nil {
+		client = defaultHTTPClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 300 || res.StatusCode == http.StatusNotModified {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return nil, ErrNotFound.New(key)
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return nil, ErrPreconditionFailed.New(key)
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return nil, ErrRequest.New(method, key, res.StatusCode, strings.TrimSpace(string(msg)))
+}
+
+// sign adds to the request the headers of AWS Signature Version 4.
+func (c *Client) sign(req *http.Request, body []byte) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+
+	t := now().UTC()
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	req.Header.Set("X-Amz-Date", t.Format(timeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", t.Format(dateFormat), region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		t.Format(timeFormat),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), t.Format(dateFormat))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query as required by Signature Version 4, with
+// the keys sorted and spaces encoded as %20.
+func canonicalQuery(q url.Values) string {
+	return strings.Replace(q.Encode(), "+", "%20", -1)
+}
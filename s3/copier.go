@@ -0,0 +1,190 @@
+package s3
+
+import (
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/src-d/borges/sivacache"
+
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/go-billy.v3"
+)
+
+// DefaultPartSize is the size of the parts siva files are uploaded in.
+const DefaultPartSize = 64 << 20
+
+// Copier copies siva files between a local filesystem and a bucket. It can
+// be used with the siva rooted transactioner of core-retrieval to keep the
+// rooted repositories in an S3-compatible object store.
+//
+// The version of every siva file copied from the bucket is kept, and it is
+// only copied back if the object was not modified meanwhile, otherwise
+// ErrPreconditionFailed is returned. Siva files not found in the bucket are
+// only written if they were not created meanwhile. The rooted repositories
+// are locked while they are modified, so this only happens if the lock is
+// lost.
+type Copier struct {
+	client *Client
+	prefix string
+	// PartSize is the size of the parts of multipart uploads, siva files
+	// smaller than this are written in a single request.
+	PartSize int64
+
+	mut      sync.Mutex
+	versions map[string]string
+	cache    *sivacache.Cache
+}
+
+var _ repository.Copier = new(Copier)
+
+// NewCopier returns a Copier storing siva files in the bucket of the given
+// client, with the given prefix prepended to their names. Siva files are
+// cached in the given cache, if it is not nil.
+func NewCopier(client *Client, prefix string, cache *sivacache.Cache) *Copier {
+	return &Copier{
+		client:   client,
+		prefix:   prefix,
+		PartSize: DefaultPartSize,
+		versions: make(map[string]string),
+		cache:    cache,
+	}
+}
+
+func (c *Copier) key(name string) string {
+	if c.prefix == "" {
+		return name
+	}
+
+	return path.Join(c.prefix, name)
+}
+
+// Files returns the names of the siva files in the bucket with the prefix of
+// the copier, which is not part of the names.
+func (c *Copier) Files() ([]string, error) {
+	prefix := c.key("")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	keys, err := c.client.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, prefix)
+		if path.Ext(name) == ".siva" && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// CopyFromRemote copies the siva file src of the bucket to dst. Nothing is
+// copied if it does not exist, as a new rooted repository is created then.
+// If the siva file is cached and was not modified, it is copied from the
+// cache.
+func (c *Copier) CopyFromRemote(src, dst string, localFs billy.Filesystem) error {
+	key := c.key(src)
+	var cond *Conditions
+	var cached string
+	if c.cache != nil {
+		if cached = c.cache.Version(key); cached != "" {
+			cond = &Conditions{IfNoneMatch: cached}
+		}
+	}
+
+	obj, r, err := c.client.Get(key, cond)
+	if ErrNotFound.Is(err) {
+		c.setVersion(key, "")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if obj == nil {
+		if err := c.cache.CopyTo(key, cached, dst, localFs); err == nil {
+			c.setVersion(key, cached)
+			return nil
+		}
+
+		// the siva file was evicted meanwhile
+		if obj, r, err = c.client.Get(key, nil); err != nil {
+			return err
+		}
+	}
+
+	defer r.Close()
+	if err := writeFile(localFs, dst, r); err != nil {
+		return err
+	}
+
+	c.setVersion(key, obj.ETag)
+	if c.cache != nil {
+		return c.cache.Add(key, obj.ETag, dst, localFs)
+	}
+
+	return nil
+}
+
+// CopyToRemote copies src to the siva file dst of the bucket, if it was not
+// modified since it was copied from the bucket.
+func (c *Copier) CopyToRemote(src, dst string, localFs billy.Filesystem) error {
+	key := c.key(dst)
+	cond := &Conditions{IfNoneMatch: "*"}
+	if v, ok := c.version(key); ok && v != "" {
+		cond = &Conditions{IfMatch: v}
+	}
+
+	f, err := localFs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	etag, err := c.client.Upload(key, f, c.PartSize, cond)
+	if err != nil {
+		return err
+	}
+
+	c.setVersion(key, etag)
+	if c.cache != nil {
+		return c.cache.Add(key, etag, src, localFs)
+	}
+
+	return nil
+}
+
+func (c *Copier) version(key string) (string, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	v, ok := c.versions[key]
+	return v, ok
+}
+
+func (c *Copier) setVersion(key, version string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.versions[key] = version
+}
+
+func writeFile(fs billy.Filesystem, name string, r io.Reader) (err error) {
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cErr := f.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+
+	_, err = io.Copy(f, r)
+	return err
+}
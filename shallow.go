@@ -0,0 +1,262 @@
+package borges
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"sort"
+	"time"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+// ShallowInit returns the key used instead of the init commit for the
+// references of the repository with the given ID whose history is not
+// complete. The roots of these references are shallow commits, which are not
+// root commits, so they are not grouped with the references of other
+// repositories; all of them are kept in the same rooted repository.
+func ShallowInit(id kallax.ULID) model.SHA1 {
+	return model.SHA1(sha1.Sum([]byte("shallow " + id.String())))
+}
+
+// NewShallowReferencer returns a Referencer with the references of r, but the
+// ones that have shallow commits as roots get the init commit given by
+// ShallowInit for the repository.
+func NewShallowReferencer(r Referencer, id kallax.ULID, shallow []plumbing.Hash) Referencer {
+	commits := make(map[model.SHA1]bool, len(shallow))
+	for _, h := range shallow {
+		commits[model.SHA1(h)] = true
+	}
+
+	return shallowReferencer{r, ShallowInit(id), commits}
+}
+
+type shallowReferencer struct {
+	Referencer
+	init    model.SHA1
+	shallow map[model.SHA1]bool
+}
+
+func (r shallowReferencer) References() ([]*model.Reference, error) {
+	refs, err := r.Referencer.References()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		for _, root := range ref.Roots {
+			if r.shallow[root] {
+				ref.Init = r.init
+				break
+			}
+		}
+	}
+
+	return refs, nil
+}
+
+// shallowParents returns no parents for shallow commits, whose parents were
+// not fetched, so they are the roots of the history that is available.
+type shallowParents struct {
+	commitParents
+	shallow map[plumbing.Hash]bool
+}
+
+func newShallowParents(graph commitParents, s storer.ShallowStorer) (commitParents, error) {
+	commits, err := s.Shallow()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(commits) == 0 {
+		return graph, nil
+	}
+
+	shallow := make(map[plumbing.Hash]bool, len(commits))
+	for _, h := range commits {
+		shallow[h] = true
+	}
+
+	return shallowParents{graph, shallow}, nil
+}
+
+func (p shallowParents) parents(h plumbing.Hash) ([]plumbing.Hash, error) {
+	if p.shallow[h] {
+		return nil, nil
+	}
+
+	return p.commitParents.parents(h)
+}
+
+// limitHistory makes the repository shallow at the commits whose parents are
+// older than since, as git does when fetching with --shallow-since. Commits
+// pointed by references are kept even if they are older. The objects that are
+// no longer reachable are not removed, but they are not archived.
+func limitHistory(r *git.Repository, since time.Time) error {
+	commits, err := r.Storer.Shallow()
+	if err != nil {
+		return err
+	}
+
+	wasShallow := make(map[plumbing.Hash]bool, len(commits))
+	for _, h := range commits {
+		wasShallow[h] = true
+	}
+
+	iter, err := r.References()
+	if err != nil {
+		return err
+	}
+
+	var pending []*object.Commit
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		c, err := ResolveCommit(r, ref.Hash())
+		if err == ErrReferencedObjectTypeNotSupported {
+			return nil
+		}
+
+		pending = append(pending, c)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	var shallow []plumbing.Hash
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[c.Hash] {
+			continue
+		}
+
+		seen[c.Hash] = true
+		if wasShallow[c.Hash] {
+			shallow = append(shallow, c.Hash)
+			continue
+		}
+
+		parents, cut, err := recentParents(r.Storer, c, since)
+		if err != nil {
+			return err
+		}
+
+		if cut {
+			shallow = append(shallow, c.Hash)
+			continue
+		}
+
+		pending = append(pending, parents...)
+	}
+
+	sort.Slice(shallow, func(i, j int) bool {
+		return bytes.Compare(shallow[i][:], shallow[j][:]) < 0
+	})
+
+	return r.Storer.SetShallow(shallow)
+}
+
+// recentParents returns the parents of a commit, or whether the history must
+// be cut at the commit because any of them is older than since.
+func recentParents(
+	s storer.EncodedObjectStorer,
+	c *object.Commit,
+	since time.Time,
+) ([]*object.Commit, bool, error) {
+	var parents []*object.Commit
+	for _, h := range c.ParentHashes {
+		p, err := object.GetCommit(s, h)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if p.Committer.When.Before(since) {
+			return nil, true, nil
+		}
+
+		parents = append(parents, p)
+	}
+
+	return parents, false, nil
+}
+
+// copyObjects copies to dst the objects reachable from h in src that are not
+// in dst yet. The parents of shallow commits are not copied. It returns the
+// shallow commits that were copied.
+func copyObjects(
+	src, dst storer.EncodedObjectStorer,
+	h plumbing.Hash,
+	shallow map[plumbing.Hash]bool,
+) ([]plumbing.Hash, error) {
+	var copied []plumbing.Hash
+	pending := []plumbing.Hash{h}
+	seen := make(map[plumbing.Hash]bool)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+
+		seen[h] = true
+		if err := dst.HasEncodedObject(h); err == nil {
+			continue
+		} else if err != plumbing.ErrObjectNotFound {
+			return nil, err
+		}
+
+		obj, err := src.EncodedObject(plumbing.AnyObject, h)
+		if err != nil {
+			return nil, err
+		}
+
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			c, err := object.DecodeCommit(src, obj)
+			if err != nil {
+				return nil, err
+			}
+
+			pending = append(pending, c.TreeHash)
+			if shallow[h] {
+				copied = append(copied, h)
+			} else {
+				pending = append(pending, c.ParentHashes...)
+			}
+		case plumbing.TreeObject:
+			t, err := object.DecodeTree(src, obj)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, e := range t.Entries {
+				if e.Mode != filemode.Submodule {
+					pending = append(pending, e.Hash)
+				}
+			}
+		case plumbing.TagObject:
+			t, err := object.DecodeTag(src, obj)
+			if err != nil {
+				return nil, err
+			}
+
+			pending = append(pending, t.Target)
+		}
+
+		if _, err := dst.SetEncodedObject(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	return copied, nil
+}
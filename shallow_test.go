@@ -0,0 +1,219 @@
+package borges
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/src-d/go-git-fixtures"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-billy.v3/memfs"
+	"gopkg.in/src-d/go-billy.v3/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestShallowParents(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit(a)
+	c := h.commit(b)
+	d := h.commit(h.commit(c), a)
+	require.NoError(h.storage.SetShallow([]plumbing.Hash{b}))
+
+	graph, err := newShallowParents(objectParents{h.storage}, h.storage)
+	require.NoError(err)
+
+	finder := newRootsFinder(graph)
+	roots, err := finder.find(d)
+	require.NoError(err)
+	require.Equal([]model.SHA1{model.SHA1(b), model.SHA1(a)}, roots)
+
+	init, err := finder.init(d)
+	require.NoError(err)
+	require.Equal(model.SHA1(b), init)
+}
+
+func TestShallowReferencer(t *testing.T) {
+	require := require.New(t)
+
+	shallow := plumbing.NewHash("0000000000000000000000000000000000000001")
+	root := model.NewSHA1("0000000000000000000000000000000000000002")
+	id := kallax.NewULID()
+
+	r := NewModelReferencer(&model.Repository{References: []*model.Reference{
+		{Name: "refs/heads/a", Init: model.SHA1(shallow), Roots: []model.SHA1{model.SHA1(shallow)}},
+		{Name: "refs/heads/b", Init: root, Roots: []model.SHA1{root, model.SHA1(shallow)}},
+		{Name: "refs/heads/c", Init: root, Roots: []model.SHA1{root}},
+	}})
+
+	refs, err := NewShallowReferencer(r, id, []plumbing.Hash{shallow}).References()
+	require.NoError(err)
+	require.Equal(ShallowInit(id), refs[0].Init)
+	require.Equal(ShallowInit(id), refs[1].Init)
+	require.Equal(root, refs[2].Init)
+
+	require.Equal(ShallowInit(id), ShallowInit(id))
+	require.NotEqual(ShallowInit(id), ShallowInit(kallax.NewULID()))
+}
+
+func TestLimitHistory(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit(a)
+	c := h.commit(b)
+	d := h.commit(c, a)
+	old := h.commit(a)
+
+	r := syntheticRepository(t, h, map[string]plumbing.Hash{
+		"refs/heads/master": d,
+		"refs/heads/old":    old,
+	})
+
+	// commits are created one second after the other, the history is cut
+	// at the commits with any older parent
+	require.NoError(limitHistory(r, time.Unix(3, 0)))
+
+	shallow, err := r.Storer.Shallow()
+	require.NoError(err)
+	require.Len(shallow, 2)
+	require.Contains(shallow, d)
+	require.Contains(shallow, old)
+
+	// commits that were already shallow are kept shallow
+	require.NoError(limitHistory(r, time.Unix(2, 0)))
+	shallow, err = r.Storer.Shallow()
+	require.NoError(err)
+	require.Len(shallow, 2)
+	require.Contains(shallow, d)
+	require.Contains(shallow, old)
+
+	h = newSyntheticHistory()
+	a = h.commit()
+	b = h.commit(a)
+	r = syntheticRepository(t, h, map[string]plumbing.Hash{"refs/heads/master": b})
+
+	require.NoError(limitHistory(r, time.Unix(0, 0)))
+	shallow, err = r.Storer.Shallow()
+	require.NoError(err)
+	require.Len(shallow, 0)
+}
+
+func TestCopyObjects(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit(a)
+	c := h.commit(b)
+
+	dst := memory.NewStorage()
+	copied, err := copyObjects(h.storage, dst, c, map[plumbing.Hash]bool{b: true})
+	require.NoError(err)
+	require.Equal([]plumbing.Hash{b}, copied)
+
+	for _, hash := range []plumbing.Hash{c, b, h.tree} {
+		require.NoError(dst.HasEncodedObject(hash))
+	}
+
+	require.Equal(plumbing.ErrObjectNotFound, dst.HasEncodedObject(a))
+
+	// objects already in the destination are not copied again
+	copied, err = copyObjects(h.storage, dst, c, nil)
+	require.NoError(err)
+	require.Len(copied, 0)
+	require.Equal(plumbing.ErrObjectNotFound, dst.HasEncodedObject(a))
+}
+
+func TestTemporaryRepository_CopyTo(t *testing.T) {
+	fixtures.Init()
+	defer fixtures.Clean()
+	require := require.New(t)
+
+	sto, err := filesystem.NewStorage(fixtures.Basic().One().DotGit())
+	require.NoError(err)
+
+	r, err := git.Open(sto, memfs.New())
+	require.NoError(err)
+
+	master, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	require.NoError(err)
+
+	dir, err := ioutil.TempDir("", "borges-test")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cloner := NewTemporaryCloner(osfs.New(dir))
+	err = WithInProcRepository(r, func(url string) error {
+		gr, err := cloner.Clone(context.TODO(), "foo", url, &CloneOptions{
+			Since: master.Committer.When,
+		})
+		require.NoError(err)
+		defer func() { require.NoError(gr.Close()) }()
+
+		shallow, err := gr.Shallow()
+		require.NoError(err)
+		require.Contains(shallow, master.Hash)
+
+		refs, err := gr.References()
+		require.NoError(err)
+		ref := refsByName(refs)["refs/heads/master"]
+		require.Equal([]model.SHA1{model.SHA1(master.Hash)}, ref.Roots)
+
+		dst := memory.NewStorage()
+		err = gr.CopyTo(dst, []config.RefSpec{
+			"+refs/heads/master:refs/heads/master/foo",
+		})
+		require.NoError(err)
+
+		copied, err := dst.Reference("refs/heads/master/foo")
+		require.NoError(err)
+		require.Equal(master.Hash, copied.Hash())
+
+		dstShallow, err := dst.Shallow()
+		require.NoError(err)
+		require.Equal([]plumbing.Hash{master.Hash}, dstShallow)
+
+		c, err := object.GetCommit(dst, master.Hash)
+		require.NoError(err)
+		_, err = c.Tree()
+		require.NoError(err)
+		require.Equal(plumbing.ErrObjectNotFound, dst.HasEncodedObject(c.ParentHashes[0]))
+
+		err = gr.CopyTo(dst, []config.RefSpec{":refs/heads/master/foo"})
+		require.NoError(err)
+		_, err = dst.Reference("refs/heads/master/foo")
+		require.Equal(plumbing.ErrReferenceNotFound, err)
+		return nil
+	})
+	require.NoError(err)
+}
+
+// syntheticRepository returns a repository with the history and the given
+// references.
+func syntheticRepository(t *testing.T, h *syntheticHistory, refs map[string]plumbing.Hash) *git.Repository {
+	require := require.New(t)
+	for name, hash := range refs {
+		ref := plumbing.NewHashReference(plumbing.ReferenceName(name), hash)
+		require.NoError(h.storage.SetReference(ref))
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master)
+	require.NoError(h.storage.SetReference(head))
+
+	r, err := git.Open(h.storage, nil)
+	require.NoError(err)
+	return r
+}
@@ -0,0 +1,152 @@
+// Package sivacache caches locally the siva files of rooted repositories kept
+// in a remote location.
+package sivacache
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"sync"
+
+	"gopkg.in/src-d/go-billy.v3"
+	"gopkg.in/src-d/go-errors.v0"
+)
+
+// ErrNotCached is returned when a file is not in the cache.
+var ErrNotCached = errors.NewKind("version %s of %s is not cached")
+
+// Cache keeps copies of the last used siva files, with their versions, in a
+// local filesystem, up to a maximum total size. Files larger than that are
+// not cached.
+type Cache struct {
+	fs      billy.Filesystem
+	maxSize int64
+
+	mut     sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	version string
+	file    string
+	size    int64
+}
+
+// NewCache returns an empty cache storing files in the given filesystem,
+// which should not be used for anything else.
+func NewCache(fs billy.Filesystem, maxSize int64) *Cache {
+	return &Cache{
+		fs:      fs,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Version returns the version of the cached file with the given key, or an
+// empty string if it is not cached.
+func (c *Cache) Version(key string) string {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return ""
+	}
+
+	return e.Value.(*cacheEntry).version
+}
+
+// CopyTo copies the cached file with the given key and version to the file
+// dst of the given filesystem.
+func (c *Cache) CopyTo(key, version, dst string, fs billy.Filesystem) error {
+	c.mut.Lock()
+	e, ok := c.entries[key]
+	if !ok || e.Value.(*cacheEntry).version != version {
+		c.mut.Unlock()
+		return ErrNotCached.New(version, key)
+	}
+
+	c.lru.MoveToFront(e)
+	// the file can be evicted once it is open
+	f, err := c.fs.Open(e.Value.(*cacheEntry).file)
+	c.mut.Unlock()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFile(fs, dst, f)
+}
+
+// Add caches the file src of the given filesystem as the given version of the
+// file with the given key, evicting the least recently used files if the
+// cache is full.
+func (c *Cache) Add(key, version, src string, fs billy.Filesystem) error {
+	name := fmt.Sprintf("%x", sha1.Sum([]byte(key+"\x00"+version)))
+	size, err := c.copyFrom(name, src, fs)
+	if err != nil {
+		return err
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		if entry := c.forget(e); entry.file != name {
+			if err := c.fs.Remove(entry.file); err != nil {
+				return err
+			}
+		}
+	}
+
+	if size > c.maxSize {
+		return c.fs.Remove(name)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key, version, name, size})
+	c.size += size
+	for c.size > c.maxSize {
+		if err := c.remove(c.lru.Back()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Cache) copyFrom(name, src string, fs billy.Filesystem) (int64, error) {
+	r, err := fs.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	w, err := c.fs.Create(name)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(w, r)
+	if cErr := w.Close(); cErr != nil && err == nil {
+		err = cErr
+	}
+
+	return n, err
+}
+
+func (c *Cache) remove(e *list.Element) error {
+	return c.fs.Remove(c.forget(e).file)
+}
+
+// forget removes the entry from the cache, but not its file.
+func (c *Cache) forget(e *list.Element) *cacheEntry {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	return entry
+}
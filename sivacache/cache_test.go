@@ -0,0 +1,60 @@
+package sivacache
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v3"
+	"gopkg.in/src-d/go-billy.v3/memfs"
+	"gopkg.in/src-d/go-billy.v3/util"
+)
+
+func TestCache(t *testing.T) {
+	require := require.New(t)
+	fs := memfs.New()
+	cacheFs := memfs.New()
+	c := NewCache(cacheFs, 10)
+
+	writeString(t, fs, "a", "aaaa")
+	writeString(t, fs, "b", "bbbb")
+	writeString(t, fs, "big", "0123456789a")
+
+	require.NoError(c.Add("a", "1", "a", fs))
+	require.NoError(c.Add("b", "1", "b", fs))
+	require.NoError(c.CopyTo("a", "1", "out", fs))
+	require.Equal("aaaa", readString(t, fs, "out"))
+
+	// b is the least recently used
+	require.NoError(c.Add("c", "1", "a", fs))
+	require.Equal("", c.Version("b"))
+	require.Equal("1", c.Version("a"))
+	require.Equal("1", c.Version("c"))
+
+	require.True(ErrNotCached.Is(c.CopyTo("a", "2", "out", fs)))
+
+	require.NoError(c.Add("a", "2", "b", fs))
+	require.NoError(c.CopyTo("a", "2", "out", fs))
+	require.Equal("bbbb", readString(t, fs, "out"))
+
+	require.NoError(c.Add("big", "1", "big", fs))
+	require.Equal("", c.Version("big"))
+
+	files, err := cacheFs.ReadDir("/")
+	require.NoError(err)
+	require.Len(files, 2)
+}
+
+func writeString(t *testing.T, fs billy.Filesystem, name, content string) {
+	require.NoError(t, util.WriteFile(fs, name, []byte(content), 0644))
+}
+
+func readString(t *testing.T, fs billy.Filesystem, name string) string {
+	f, err := fs.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	return string(content)
+}
@@ -0,0 +1,100 @@
+package sivacache
+
+import (
+	"io"
+	"os"
+
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/go-billy.v3"
+)
+
+// VersionedCopier is a repository.Copier that knows the version of the files
+// in the remote location.
+type VersionedCopier interface {
+	repository.Copier
+	// Version returns the version of the remote file with the given name,
+	// which changes every time it is written, or an empty string if it does
+	// not exist.
+	Version(name string) (string, error)
+}
+
+// Copier is a repository.Copier that keeps the siva files it copies in a
+// cache, so they are not copied again from the remote location by the next
+// transactions of the same rooted repositories, as long as they were not
+// modified meanwhile. Siva files are identified by their name, which is the
+// init commit of their rooted repository.
+type Copier struct {
+	copier VersionedCopier
+	cache  *Cache
+}
+
+var _ repository.Copier = new(Copier)
+
+// NewCopier returns a Copier copying siva files with the given copier and
+// caching them in the given cache.
+func NewCopier(copier VersionedCopier, cache *Cache) *Copier {
+	return &Copier{copier, cache}
+}
+
+// CopyFromRemote copies the remote siva file src to dst, from the cache if it
+// is cached and was not modified.
+func (c *Copier) CopyFromRemote(src, dst string, localFs billy.Filesystem) error {
+	version, err := c.copier.Version(src)
+	if err != nil {
+		return err
+	}
+
+	if version == "" {
+		return c.copier.CopyFromRemote(src, dst, localFs)
+	}
+
+	if err := c.cache.CopyTo(src, version, dst, localFs); err == nil {
+		return nil
+	}
+
+	if err := c.copier.CopyFromRemote(src, dst, localFs); err != nil {
+		return err
+	}
+
+	return c.cache.Add(src, version, dst, localFs)
+}
+
+// CopyToRemote copies src to the remote siva file dst and caches it.
+func (c *Copier) CopyToRemote(src, dst string, localFs billy.Filesystem) error {
+	if err := c.copier.CopyToRemote(src, dst, localFs); err != nil {
+		return err
+	}
+
+	version, err := c.copier.Version(dst)
+	if err != nil {
+		return err
+	}
+
+	return c.cache.Add(dst, version, src, localFs)
+}
+
+func copyFile(dstFs billy.Filesystem, dst string, srcFs billy.Filesystem, src string) error {
+	f, err := srcFs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFile(dstFs, dst, f)
+}
+
+func writeFile(fs billy.Filesystem, name string, r io.Reader) (err error) {
+	f, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cErr := f.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}()
+
+	_, err = io.Copy(f, r)
+	return err
+}
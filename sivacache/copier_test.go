@@ -0,0 +1,86 @@
+package sivacache
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v3"
+	"gopkg.in/src-d/go-billy.v3/memfs"
+	"gopkg.in/src-d/go-billy.v3/osfs"
+)
+
+type countingCopier struct {
+	VersionedCopier
+	copiesFromRemote int
+}
+
+func (c *countingCopier) CopyFromRemote(src, dst string, localFs billy.Filesystem) error {
+	c.copiesFromRemote++
+	return c.VersionedCopier.CopyFromRemote(src, dst, localFs)
+}
+
+func TestLocalCopier(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "borges-sivacache")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fs := memfs.New()
+	c := NewLocalCopier(osfs.New(dir))
+
+	v, err := c.Version("foo.siva")
+	require.NoError(err)
+	require.Equal("", v)
+	require.NoError(c.CopyFromRemote("foo.siva", "a", fs))
+	_, err = fs.Stat("a")
+	require.True(os.IsNotExist(err))
+
+	writeString(t, fs, "a", "foo")
+	require.NoError(c.CopyToRemote("a", "foo.siva", fs))
+	v, err = c.Version("foo.siva")
+	require.NoError(err)
+	require.NotEqual("", v)
+
+	require.NoError(c.CopyFromRemote("foo.siva", "b", fs))
+	require.Equal("foo", readString(t, fs, "b"))
+
+	writeString(t, fs, "a", "foobar")
+	require.NoError(c.CopyToRemote("a", "foo.siva", fs))
+	newV, err := c.Version("foo.siva")
+	require.NoError(err)
+	require.NotEqual(v, newV)
+}
+
+func TestCopier(t *testing.T) {
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "borges-sivacache")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	fs := memfs.New()
+	remote := &countingCopier{VersionedCopier: NewLocalCopier(osfs.New(dir))}
+	c := NewCopier(remote, NewCache(memfs.New(), 1024))
+
+	require.NoError(c.CopyFromRemote("foo.siva", "a", fs))
+	writeString(t, fs, "a", "foo")
+	require.NoError(c.CopyToRemote("a", "foo.siva", fs))
+
+	require.NoError(c.CopyFromRemote("foo.siva", "b", fs))
+	require.Equal("foo", readString(t, fs, "b"))
+	require.Equal(1, remote.copiesFromRemote)
+
+	// modified by another consumer
+	other := NewLocalCopier(osfs.New(dir))
+	writeString(t, fs, "c", "foobar")
+	require.NoError(other.CopyToRemote("c", "foo.siva", fs))
+
+	require.NoError(c.CopyFromRemote("foo.siva", "d", fs))
+	require.Equal("foobar", readString(t, fs, "d"))
+	require.Equal(2, remote.copiesFromRemote)
+
+	require.NoError(c.CopyFromRemote("foo.siva", "e", fs))
+	require.Equal("foobar", readString(t, fs, "e"))
+	require.Equal(2, remote.copiesFromRemote)
+}
@@ -0,0 +1,96 @@
+package sivacache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/colinmarc/hdfs"
+	"gopkg.in/src-d/go-billy.v3"
+)
+
+// HDFSCopier is a VersionedCopier of siva files kept in a directory of HDFS,
+// like the HDFS copier of core-retrieval. The version of a file is given by
+// its modification time and size.
+type HDFSCopier struct {
+	client *hdfs.Client
+	dir    string
+}
+
+var _ VersionedCopier = new(HDFSCopier)
+
+// NewHDFSCopier returns an HDFSCopier of the siva files in the given directory
+// of the HDFS cluster with the namenode at the given address (host:port).
+func NewHDFSCopier(address, dir string) (*HDFSCopier, error) {
+	client, err := hdfs.New(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HDFSCopier{client, dir}, nil
+}
+
+// Version implements the VersionedCopier interface.
+func (c *HDFSCopier) Version(name string) (string, error) {
+	fi, err := c.client.Stat(path.Join(c.dir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size()), nil
+}
+
+// CopyFromRemote implements the repository.Copier interface. Nothing is
+// copied if src does not exist.
+func (c *HDFSCopier) CopyFromRemote(src, dst string, localFs billy.Filesystem) error {
+	r, err := c.client.Open(path.Join(c.dir, src))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return writeFile(localFs, dst, r)
+}
+
+// CopyToRemote implements the repository.Copier interface. The file is
+// written with a temporary name and then renamed, so it is never read
+// partially written.
+func (c *HDFSCopier) CopyToRemote(src, dst string, localFs billy.Filesystem) error {
+	f, err := localFs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dst = path.Join(c.dir, dst)
+	tmp := dst + ".tmp"
+	if err := c.client.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := c.client.MkdirAll(path.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	w, err := c.client.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	// rename overwrites the previous file
+	return c.client.Rename(tmp, dst)
+}
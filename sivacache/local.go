@@ -0,0 +1,58 @@
+package sivacache
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/src-d/go-billy.v3"
+)
+
+// LocalCopier is a VersionedCopier of siva files kept in a filesystem, like
+// the local copier of core-retrieval. The version of a file is given by its
+// modification time and size.
+type LocalCopier struct {
+	fs billy.Filesystem
+}
+
+var _ VersionedCopier = new(LocalCopier)
+
+// NewLocalCopier returns a LocalCopier of the siva files in the given
+// filesystem.
+func NewLocalCopier(fs billy.Filesystem) *LocalCopier {
+	return &LocalCopier{fs}
+}
+
+// Version implements the VersionedCopier interface.
+func (c *LocalCopier) Version(name string) (string, error) {
+	fi, err := c.fs.Stat(name)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d-%d", fi.ModTime().UnixNano(), fi.Size()), nil
+}
+
+// CopyFromRemote implements the repository.Copier interface. Nothing is
+// copied if src does not exist.
+func (c *LocalCopier) CopyFromRemote(src, dst string, localFs billy.Filesystem) error {
+	err := copyFile(localFs, dst, c.fs, src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// CopyToRemote implements the repository.Copier interface. The file is
+// written with a temporary name and then renamed, so it is never read
+// partially written.
+func (c *LocalCopier) CopyToRemote(src, dst string, localFs billy.Filesystem) error {
+	tmp := dst + ".tmp"
+	if err := copyFile(c.fs, tmp, localFs, src); err != nil {
+		return err
+	}
+
+	return c.fs.Rename(tmp, dst)
+}
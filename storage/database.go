@@ -10,22 +10,42 @@ import (
 
 type dbRepoStore struct {
 	*model.RepositoryStore
+	db *sql.DB
 }
 
 // FromDatabase returns a new repository store that interacts with a PostgreSQL
 // FromDatabase to store all the data.
 func FromDatabase(db *sql.DB) RepoStore {
-	return &dbRepoStore{model.NewRepositoryStore(db)}
+	return &dbRepoStore{model.NewRepositoryStore(db), db}
 }
 
 func (s *dbRepoStore) Create(repo *model.Repository) error {
-	_, err := s.Save(repo)
-	return err
+	return s.Transaction(func(store *model.RepositoryStore) error {
+		// references are not stored in the repositories table
+		refs := repo.References
+		repo.References = nil
+		_, err := store.Save(repo)
+		repo.References = refs
+		if err != nil {
+			return err
+		}
+
+		return writeReferences(kallaxExec(store.GenericStore()), repo, refs, nil)
+	})
 }
 
 func (s *dbRepoStore) Get(id kallax.ULID) (*model.Repository, error) {
 	q := model.NewRepositoryQuery().FindByID(id)
-	return s.FindOne(q)
+	r, err := s.FindOne(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadReferences(r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
 }
 
 func (s *dbRepoStore) GetByEndpoints(endpoints ...string) ([]*model.Repository, error) {
@@ -49,6 +69,28 @@ func (s *dbRepoStore) GetByEndpoints(endpoints ...string) ([]*model.Repository,
 		return nil, err
 	}
 
+	if err := s.loadReferences(repositories...); err != nil {
+		return nil, err
+	}
+
+	return repositories, nil
+}
+
+func (s *dbRepoStore) GetByStatus(status model.FetchStatus) ([]*model.Repository, error) {
+	rs, err := s.Find(model.NewRepositoryQuery().FindByStatus(status))
+	if err != nil {
+		return nil, err
+	}
+
+	repositories, err := rs.All()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.loadReferences(repositories...); err != nil {
+		return nil, err
+	}
+
 	return repositories, nil
 }
 
@@ -56,6 +98,7 @@ func (s *dbRepoStore) SetStatus(repo *model.Repository, status model.FetchStatus
 	repo.Status = status
 	_, err := s.RepositoryStore.Update(
 		repo,
+		model.Schema.Repository.UpdatedAt,
 		model.Schema.Repository.Status,
 	)
 	return err
@@ -72,7 +115,6 @@ func (s *dbRepoStore) UpdateFailed(repo *model.Repository, status model.FetchSta
 	_, err := s.Update(repo,
 		model.Schema.Repository.UpdatedAt,
 		model.Schema.Repository.FetchErrorAt,
-		model.Schema.Repository.References,
 		model.Schema.Repository.Status,
 	)
 
@@ -89,12 +131,243 @@ func (s *dbRepoStore) UpdateFetched(repo *model.Repository, fetchedAt time.Time)
 		model.Schema.Repository.FetchedAt,
 		model.Schema.Repository.LastCommitAt,
 		model.Schema.Repository.Status,
-		model.Schema.Repository.References,
 	)
 
 	return err
 }
 
+func (s *dbRepoStore) SetHead(repo *model.Repository, head string) error {
+	if head == "" {
+		_, err := s.db.Exec(
+			`DELETE FROM repository_heads WHERE repository_id = $1`,
+			repo.ID,
+		)
+		return err
+	}
+
+	_, err := s.db.Exec(
+		`INSERT INTO repository_heads (repository_id, head) VALUES ($1, $2)
+		ON CONFLICT (repository_id) DO UPDATE SET head = EXCLUDED.head`,
+		repo.ID, head,
+	)
+	return err
+}
+
+func (s *dbRepoStore) Head(id kallax.ULID) (string, error) {
+	var head string
+	err := s.db.QueryRow(
+		`SELECT head FROM repository_heads WHERE repository_id = $1`,
+		id,
+	).Scan(&head)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return head, err
+}
+
+func (s *dbRepoStore) SetShallow(repo *model.Repository, shallow *Shallow) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	for _, table := range []string{"repository_shallows", "repository_shallow_commits"} {
+		_, err = tx.Exec(
+			`DELETE FROM `+table+` WHERE repository_id = $1`,
+			repo.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if shallow == nil {
+		return nil
+	}
+
+	var since *time.Time
+	if !shallow.Since.IsZero() {
+		since = &shallow.Since
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO repository_shallows (repository_id, depth, since) VALUES ($1, $2, $3)`,
+		repo.ID, shallow.Depth, since,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range shallow.Commits {
+		_, err = tx.Exec(
+			`INSERT INTO repository_shallow_commits (repository_id, hash) VALUES ($1, $2)`,
+			repo.ID, c.String(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *dbRepoStore) Shallow(id kallax.ULID) (*Shallow, error) {
+	var shallow Shallow
+	var since *time.Time
+	err := s.db.QueryRow(
+		`SELECT depth, since FROM repository_shallows WHERE repository_id = $1`,
+		id,
+	).Scan(&shallow.Depth, &since)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if since != nil {
+		shallow.Since = *since
+	}
+
+	rows, err := s.db.Query(
+		`SELECT hash FROM repository_shallow_commits WHERE repository_id = $1 ORDER BY hash`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+
+		shallow.Commits = append(shallow.Commits, model.NewSHA1(hash))
+	}
+
+	return &shallow, rows.Err()
+}
+
+func (s *dbRepoStore) SetPendingCommit(repo *model.Repository, inits []model.SHA1) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	_, err = tx.Exec(
+		`DELETE FROM repository_pending_commits WHERE repository_id = $1`,
+		repo.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, init := range inits {
+		_, err = tx.Exec(
+			`INSERT INTO repository_pending_commits (repository_id, init) VALUES ($1, $2)`,
+			repo.ID, init.String(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *dbRepoStore) PendingCommit(id kallax.ULID) ([]model.SHA1, error) {
+	rows, err := s.db.Query(
+		`SELECT init FROM repository_pending_commits WHERE repository_id = $1 ORDER BY init`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inits []model.SHA1
+	for rows.Next() {
+		var init string
+		if err := rows.Scan(&init); err != nil {
+			return nil, err
+		}
+
+		inits = append(inits, model.NewSHA1(init))
+	}
+
+	return inits, rows.Err()
+}
+
+func (s *dbRepoStore) Delete(repo *model.Repository) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	for _, table := range []string{
+		"repository_heads",
+		"repository_shallows",
+		"repository_shallow_commits",
+		"repository_pending_commits",
+		"repository_references",
+		"repository_reference_history",
+		"repository_deleted_references",
+	} {
+		_, err = tx.Exec(
+			`DELETE FROM `+table+` WHERE repository_id = $1`,
+			repo.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.Exec(`DELETE FROM repositories WHERE id = $1`, repo.ID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		err = kallax.ErrNotFound
+	}
+
+	return err
+}
+
 func lastCommitTime(refs []*model.Reference) *time.Time {
 	if len(refs) == 0 {
 		return nil
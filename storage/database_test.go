@@ -20,9 +20,11 @@ func (s *DatabaseSuite) SetupTest() {
 	s.Setup()
 	s.rawStore = model.NewRepositoryStore(s.DB)
 	s.store = FromDatabase(s.DB).(*dbRepoStore)
+	s.Require().NoError(CreateSchema(s.DB))
 }
 
 func (s *DatabaseSuite) TearDownTest() {
+	s.Require().NoError(DropSchema(s.DB))
 	s.TearDown()
 }
 
@@ -62,6 +64,22 @@ func (s *DatabaseSuite) TestGetByEndpoints() {
 	require.NoError(err)
 }
 
+func (s *DatabaseSuite) TestGetByStatus() {
+	require := s.Require()
+
+	s.createRepo(model.Pending, "foo")
+	fetching := s.createRepo(model.Fetching, "bar")
+
+	result, err := s.store.GetByStatus(model.Fetching)
+	require.NoError(err)
+	require.Len(result, 1)
+	require.Equal(fetching.ID, result[0].ID)
+
+	result, err = s.store.GetByStatus(model.NotFound)
+	require.NoError(err)
+	require.Len(result, 0)
+}
+
 func (s *DatabaseSuite) TestSetStatus() {
 	require := s.Require()
 	repo := s.createRepo(model.Pending, "foo")
@@ -119,6 +137,310 @@ func (s *DatabaseSuite) TestUpdateFetched() {
 	require.Equal(model.Fetched, repo.Status)
 }
 
+func (s *DatabaseSuite) TestSetHead() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "foo")
+
+	head, err := s.store.Head(repo.ID)
+	require.NoError(err)
+	require.Equal("", head)
+
+	require.NoError(s.store.SetHead(repo, "refs/heads/master"))
+	require.NoError(s.store.SetHead(repo, "refs/heads/main"))
+
+	head, err = s.store.Head(repo.ID)
+	require.NoError(err)
+	require.Equal("refs/heads/main", head)
+
+	require.NoError(s.store.SetHead(repo, ""))
+
+	head, err = s.store.Head(repo.ID)
+	require.NoError(err)
+	require.Equal("", head)
+}
+
+func (s *DatabaseSuite) TestSetShallow() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "foo")
+
+	shallow, err := s.store.Shallow(repo.ID)
+	require.NoError(err)
+	require.Nil(shallow)
+
+	expected := &Shallow{
+		Depth: 10,
+		Since: withoutNs(time.Now()),
+		Commits: []model.SHA1{
+			model.NewSHA1("0000000000000000000000000000000000000001"),
+			model.NewSHA1("0000000000000000000000000000000000000002"),
+		},
+	}
+
+	require.NoError(s.store.SetShallow(repo, &Shallow{Depth: 1}))
+	require.NoError(s.store.SetShallow(repo, expected))
+
+	shallow, err = s.store.Shallow(repo.ID)
+	require.NoError(err)
+	require.Equal(expected.Depth, shallow.Depth)
+	require.True(expected.Since.Equal(shallow.Since))
+	require.Equal(expected.Commits, shallow.Commits)
+
+	require.NoError(s.store.SetShallow(repo, nil))
+
+	shallow, err = s.store.Shallow(repo.ID)
+	require.NoError(err)
+	require.Nil(shallow)
+}
+
+func (s *DatabaseSuite) TestSetPendingCommit() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "foo")
+
+	inits, err := s.store.PendingCommit(repo.ID)
+	require.NoError(err)
+	require.Len(inits, 0)
+
+	expected := []model.SHA1{
+		model.NewSHA1("0000000000000000000000000000000000000001"),
+		model.NewSHA1("0000000000000000000000000000000000000002"),
+	}
+
+	require.NoError(s.store.SetPendingCommit(repo, expected[:1]))
+	require.NoError(s.store.SetPendingCommit(repo, expected))
+
+	inits, err = s.store.PendingCommit(repo.ID)
+	require.NoError(err)
+	require.Equal(expected, inits)
+
+	require.NoError(s.store.SetPendingCommit(repo, nil))
+
+	inits, err = s.store.PendingCommit(repo.ID)
+	require.NoError(err)
+	require.Len(inits, 0)
+}
+
+func (s *DatabaseSuite) TestUpdateReferences() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "foo")
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	err := s.store.UpdateReferences(repo, []*model.Reference{
+		{Name: "refs/heads/master", Hash: a, Init: a, Roots: []model.SHA1{a}},
+		{Name: "refs/heads/old", Hash: a, Init: a},
+	}, nil, nil)
+	require.NoError(err)
+
+	err = s.store.UpdateReferences(repo, []*model.Reference{
+		{Name: "refs/heads/master", Hash: b, Init: a, Roots: []model.SHA1{a, b}},
+		{Name: "refs/tags/v1", Hash: b, Init: a},
+	}, []string{"refs/heads/old"}, nil)
+	require.NoError(err)
+
+	// the references are not updated if their history cannot be
+	err = s.store.UpdateReferences(repo,
+		[]*model.Reference{{Name: "refs/heads/new", Hash: b, Init: a}}, nil,
+		[]*ReferenceChange{{Name: "refs/heads/\x00", Action: "create"}},
+	)
+	require.Error(err)
+
+	repo, err = s.store.Get(repo.ID)
+	require.NoError(err)
+	require.Len(repo.References, 2)
+	require.Equal("refs/heads/master", repo.References[0].Name)
+	require.Equal(b, repo.References[0].Hash)
+	require.Equal(a, repo.References[0].Init)
+	require.Equal([]model.SHA1{a, b}, repo.References[0].Roots)
+	require.Equal("refs/tags/v1", repo.References[1].Name)
+}
+
+func (s *DatabaseSuite) TestCreate() {
+	require := s.Require()
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	repo := model.NewRepository()
+	repo.Endpoints = []string{"foo"}
+	repo.References = []*model.Reference{{Name: "refs/heads/master", Hash: a, Init: a}}
+	require.NoError(s.store.Create(repo))
+
+	stored, err := s.store.Get(repo.ID)
+	require.NoError(err)
+	require.Len(stored.References, 1)
+	require.Equal("refs/heads/master", stored.References[0].Name)
+
+	// the repository is not saved if its references cannot be, text
+	// columns cannot contain NUL bytes
+	invalid := model.NewRepository()
+	invalid.References = []*model.Reference{{Name: "refs/heads/\x00", Hash: a, Init: a}}
+	require.Error(s.store.Create(invalid))
+
+	_, err = s.store.Get(invalid.ID)
+	require.Equal(kallax.ErrNotFound, err)
+}
+
+func (s *DatabaseSuite) TestMigrateReferences() {
+	require := s.Require()
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	repo := s.createRepo(model.Fetched, "foo")
+	repo.References = []*model.Reference{{Name: "refs/heads/master", Hash: a, Init: a}}
+	_, err := s.rawStore.Update(repo, model.Schema.Repository.References)
+	require.NoError(err)
+	s.createRepo(model.Fetched, "bar")
+
+	// repositories with references in the table are skipped
+	other := s.createRepo(model.Fetched, "baz")
+	other.References = []*model.Reference{{Name: "refs/heads/old", Hash: a, Init: a}}
+	_, err = s.rawStore.Update(other, model.Schema.Repository.References)
+	require.NoError(err)
+	require.NoError(s.store.UpdateReferences(other,
+		[]*model.Reference{{Name: "refs/heads/new", Hash: a, Init: a}}, nil, nil))
+
+	n, err := MigrateReferences(s.DB)
+	require.NoError(err)
+	require.Equal(1, n)
+
+	stored, err := s.store.Get(other.ID)
+	require.NoError(err)
+	require.Len(stored.References, 1)
+	require.Equal("refs/heads/new", stored.References[0].Name)
+
+	stored, err = s.store.Get(repo.ID)
+	require.NoError(err)
+	require.Len(stored.References, 1)
+
+	// the column is left untouched
+	raw, err := s.rawStore.FindOne(model.NewRepositoryQuery().FindByID(repo.ID))
+	require.NoError(err)
+	require.Len(raw.References, 1)
+
+	// references are not copied again once the repository has some
+	require.NoError(s.store.UpdateReferences(repo, nil, []string{"refs/heads/master"}, nil))
+	require.NoError(s.store.UpdateReferences(repo,
+		[]*model.Reference{{Name: "refs/heads/other", Hash: a, Init: a}}, nil, nil))
+
+	n, err = MigrateReferences(s.DB)
+	require.NoError(err)
+	require.Equal(0, n)
+
+	stored, err = s.store.Get(repo.ID)
+	require.NoError(err)
+	require.Len(stored.References, 1)
+	require.Equal("refs/heads/other", stored.References[0].Name)
+}
+
+func (s *DatabaseSuite) TestReferenceHistory() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "foo")
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	b := model.NewSHA1("0000000000000000000000000000000000000002")
+	now := withoutNs(time.Now())
+	expected := []*ReferenceChange{
+		{Name: "refs/heads/master", Action: "create", NewHash: a, NewInit: a, Job: "job", Time: now},
+		{Name: "refs/tags/v1", Action: "create", NewHash: a, NewInit: a, Time: now},
+		{
+			Name: "refs/heads/master", Action: "update",
+			OldHash: a, OldInit: a, NewHash: b, NewInit: a,
+			Forced: true, Time: now.Add(time.Second),
+		},
+	}
+
+	require.NoError(s.store.UpdateReferences(repo, nil, nil, expected[:2]))
+	require.NoError(s.store.UpdateReferences(repo, nil, nil, expected[2:]))
+
+	changes, err := s.store.ReferenceHistory(repo.ID, "refs/heads/master")
+	require.NoError(err)
+	require.Len(changes, 2)
+	require.Equal(expected[0].Job, changes[0].Job)
+	require.True(changes[0].OldHash.IsZero())
+	require.Equal(expected[2].OldHash, changes[1].OldHash)
+	require.Equal(expected[2].NewHash, changes[1].NewHash)
+	require.True(changes[1].Forced)
+	require.True(expected[2].Time.Equal(changes[1].Time))
+
+	changes, err = s.store.ReferenceHistory(repo.ID, "")
+	require.NoError(err)
+	require.Len(changes, 3)
+}
+
+func (s *DatabaseSuite) TestDeletedReferences() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "foo")
+
+	a := model.NewSHA1("0000000000000000000000000000000000000001")
+	now := withoutNs(time.Now())
+	old := &DeletedReference{
+		RepositoryID: repo.ID,
+		Name:         "refs/heads/old",
+		Hash:         a,
+		Init:         a,
+		Tombstone:    "refs/borges/deleted/old",
+		DeletedAt:    now.Add(-time.Hour),
+	}
+	recent := &DeletedReference{
+		RepositoryID: repo.ID,
+		Name:         "refs/heads/recent",
+		Hash:         a,
+		Init:         a,
+		Tombstone:    "refs/borges/deleted/recent",
+		DeletedAt:    now,
+	}
+	require.NoError(s.store.AddDeletedReferences(repo, []*DeletedReference{old, recent}))
+
+	refs, err := s.store.DeletedReferences(repo.ID)
+	require.NoError(err)
+	require.Len(refs, 2)
+	require.Equal(old.Tombstone, refs[0].Tombstone)
+	require.Equal(old.Name, refs[0].Name)
+	require.Equal(a, refs[0].Hash)
+	require.Equal(a, refs[0].Init)
+	require.True(old.DeletedAt.Equal(refs[0].DeletedAt))
+
+	refs, err = s.store.ExpiredDeletedReferences(now.Add(-time.Minute))
+	require.NoError(err)
+	require.Len(refs, 1)
+	require.Equal(repo.ID, refs[0].RepositoryID)
+	require.Equal(old.Tombstone, refs[0].Tombstone)
+
+	require.NoError(s.store.RemoveDeletedReferences(refs))
+
+	refs, err = s.store.DeletedReferences(repo.ID)
+	require.NoError(err)
+	require.Len(refs, 1)
+	require.Equal(recent.Tombstone, refs[0].Tombstone)
+}
+
+func (s *DatabaseSuite) TestDelete() {
+	require := s.Require()
+	repo := s.createRepo(model.Fetched, "foo")
+	require.NoError(s.store.SetHead(repo, "refs/heads/main"))
+	require.NoError(s.store.SetShallow(repo, &Shallow{Depth: 1}))
+	require.NoError(s.store.SetPendingCommit(repo, []model.SHA1{model.SHA1{}}))
+	require.NoError(s.store.UpdateReferences(repo,
+		[]*model.Reference{{Name: "refs/heads/master"}}, nil,
+		[]*ReferenceChange{{Name: "refs/heads/master", Action: "create", Time: time.Now()}}))
+
+	require.NoError(s.store.Delete(repo))
+
+	_, err := s.store.Get(repo.ID)
+	require.Equal(kallax.ErrNotFound, err)
+
+	head, err := s.store.Head(repo.ID)
+	require.NoError(err)
+	require.Equal("", head)
+
+	shallow, err := s.store.Shallow(repo.ID)
+	require.NoError(err)
+	require.Nil(shallow)
+
+	inits, err := s.store.PendingCommit(repo.ID)
+	require.NoError(err)
+	require.Len(inits, 0)
+
+	require.Equal(kallax.ErrNotFound, s.store.Delete(repo))
+}
+
 func (s *DatabaseSuite) createRepo(status model.FetchStatus, remotes ...string) *model.Repository {
 	repo := model.NewRepository()
 	repo.Status = status
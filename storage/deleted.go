@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"time"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	kallax "gopkg.in/src-d/go-kallax.v1"
+)
+
+func (s *dbRepoStore) AddDeletedReferences(repo *model.Repository, refs []*DeletedReference) (err error) {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	for _, ref := range refs {
+		_, err = tx.Exec(
+			`INSERT INTO repository_deleted_references
+			(repository_id, tombstone, name, hash, init, deleted_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (repository_id, tombstone) DO NOTHING`,
+			repo.ID, ref.Tombstone, ref.Name, ref.Hash.String(), ref.Init.String(),
+			ref.DeletedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *dbRepoStore) DeletedReferences(id kallax.ULID) ([]*DeletedReference, error) {
+	return s.queryDeletedReferences(
+		`SELECT repository_id, tombstone, name, hash, init, deleted_at
+		FROM repository_deleted_references WHERE repository_id = $1
+		ORDER BY deleted_at, tombstone`,
+		id,
+	)
+}
+
+func (s *dbRepoStore) ExpiredDeletedReferences(before time.Time) ([]*DeletedReference, error) {
+	return s.queryDeletedReferences(
+		`SELECT repository_id, tombstone, name, hash, init, deleted_at
+		FROM repository_deleted_references WHERE deleted_at < $1
+		ORDER BY deleted_at, tombstone`,
+		before,
+	)
+}
+
+func (s *dbRepoStore) queryDeletedReferences(query string, args ...interface{}) ([]*DeletedReference, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []*DeletedReference
+	for rows.Next() {
+		var ref DeletedReference
+		var hash, init string
+		err := rows.Scan(
+			&ref.RepositoryID, &ref.Tombstone, &ref.Name, &hash, &init,
+			&ref.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		ref.Hash = model.NewSHA1(hash)
+		ref.Init = model.NewSHA1(init)
+		refs = append(refs, &ref)
+	}
+
+	return refs, rows.Err()
+}
+
+func (s *dbRepoStore) RemoveDeletedReferences(refs []*DeletedReference) (err error) {
+	if len(refs) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	for _, ref := range refs {
+		_, err = tx.Exec(
+			`DELETE FROM repository_deleted_references
+			WHERE repository_id = $1 AND tombstone = $2`,
+			ref.RepositoryID, ref.Tombstone,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"database/sql"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	kallax "gopkg.in/src-d/go-kallax.v1"
+)
+
+// writeReferenceChanges appends the given changes of the references of the
+// repository to their history.
+func writeReferenceChanges(exec execFunc, repo *model.Repository, changes []*ReferenceChange) error {
+	for _, c := range changes {
+		var job *string
+		if c.Job != "" {
+			job = &c.Job
+		}
+
+		err := exec(
+			`INSERT INTO repository_reference_history
+			(repository_id, name, action, old_hash, old_init, new_hash, new_init, forced, job_id, time)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			repo.ID, c.Name, c.Action,
+			nullSHA1(c.OldHash), nullSHA1(c.OldInit),
+			nullSHA1(c.NewHash), nullSHA1(c.NewInit),
+			c.Forced, job, c.Time,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *dbRepoStore) ReferenceHistory(id kallax.ULID, name string) ([]*ReferenceChange, error) {
+	rows, err := s.db.Query(
+		`SELECT name, action, old_hash, old_init, new_hash, new_init, forced, job_id, time
+		FROM repository_reference_history
+		WHERE repository_id = $1 AND ($2 = '' OR name = $2)
+		ORDER BY time, id`,
+		id, name,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*ReferenceChange
+	for rows.Next() {
+		var (
+			c                                  ReferenceChange
+			oldHash, oldInit, newHash, newInit sql.NullString
+			job                                sql.NullString
+		)
+
+		err := rows.Scan(
+			&c.Name, &c.Action, &oldHash, &oldInit, &newHash, &newInit,
+			&c.Forced, &job, &c.Time,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		c.OldHash = model.NewSHA1(oldHash.String)
+		c.OldInit = model.NewSHA1(oldInit.String)
+		c.NewHash = model.NewSHA1(newHash.String)
+		c.NewInit = model.NewSHA1(newInit.String)
+		c.Job = job.String
+		changes = append(changes, &c)
+	}
+
+	return changes, rows.Err()
+}
+
+// nullSHA1 returns the hash as a string, or nil if it is zero.
+func nullSHA1(h model.SHA1) interface{} {
+	if h.IsZero() {
+		return nil
+	}
+
+	return h.String()
+}
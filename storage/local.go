@@ -2,6 +2,7 @@ package storage
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -25,13 +26,27 @@ func (r *localRepo) toRepo() *model.Repository {
 
 type localRepoStore struct {
 	sync.RWMutex
-	repos map[kallax.ULID]*localRepo
+	repos    map[kallax.ULID]*localRepo
+	heads    map[kallax.ULID]string
+	shallows map[kallax.ULID]*Shallow
+	pending  map[kallax.ULID][]model.SHA1
+	refs     map[kallax.ULID]map[string]*model.Reference
+	history  map[kallax.ULID][]*ReferenceChange
+	deleted  map[kallax.ULID][]*DeletedReference
+	updated  map[kallax.ULID]time.Time
 }
 
 // Local creates a new local repository store that needs no database connection.
 func Local() RepoStore {
 	return &localRepoStore{
-		repos: make(map[kallax.ULID]*localRepo),
+		repos:    make(map[kallax.ULID]*localRepo),
+		heads:    make(map[kallax.ULID]string),
+		shallows: make(map[kallax.ULID]*Shallow),
+		pending:  make(map[kallax.ULID][]model.SHA1),
+		refs:     make(map[kallax.ULID]map[string]*model.Reference),
+		history:  make(map[kallax.ULID][]*ReferenceChange),
+		deleted:  make(map[kallax.ULID][]*DeletedReference),
+		updated:  make(map[kallax.ULID]time.Time),
 	}
 }
 
@@ -43,11 +58,19 @@ func (s *localRepoStore) Create(repo *model.Repository) error {
 		return fmt.Errorf("expecting only 1 endpoint for repo %q, got %d", repo.ID, len(repo.Endpoints))
 	}
 
+	if repo.UpdatedAt.IsZero() {
+		repo.UpdatedAt = time.Now()
+	}
+
+	s.updated[repo.ID] = repo.UpdatedAt
 	s.repos[repo.ID] = &localRepo{
 		ID:       repo.ID,
 		Endpoint: repo.Endpoints[0],
 		Status:   repo.Status,
 	}
+
+	delete(s.refs, repo.ID)
+	s.updateReferences(repo.ID, repo.References, nil)
 	return nil
 }
 
@@ -59,7 +82,7 @@ func (s *localRepoStore) Get(id kallax.ULID) (*model.Repository, error) {
 		return nil, kallax.ErrNotFound
 	}
 
-	return repo.toRepo(), nil
+	return s.toRepo(repo), nil
 }
 
 func (s *localRepoStore) GetByEndpoints(endpoints ...string) ([]*model.Repository, error) {
@@ -73,7 +96,21 @@ func (s *localRepoStore) GetByEndpoints(endpoints ...string) ([]*model.Repositor
 	var repos []*model.Repository
 	for _, repo := range s.repos {
 		if containsString(endpoints, repo.Endpoint) {
-			repos = append(repos, repo.toRepo())
+			repos = append(repos, s.toRepo(repo))
+		}
+	}
+
+	return repos, nil
+}
+
+func (s *localRepoStore) GetByStatus(status model.FetchStatus) ([]*model.Repository, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var repos []*model.Repository
+	for _, repo := range s.repos {
+		if repo.Status == status {
+			repos = append(repos, s.toRepo(repo))
 		}
 	}
 
@@ -91,6 +128,8 @@ func (s *localRepoStore) SetStatus(repo *model.Repository, status model.FetchSta
 	}
 
 	localRepo.Status = status
+	repo.UpdatedAt = time.Now()
+	s.updated[repo.ID] = repo.UpdatedAt
 	return nil
 }
 
@@ -121,6 +160,245 @@ func (s *localRepoStore) UpdateFetched(repo *model.Repository, fetchedAt time.Ti
 	return s.SetStatus(repo, model.Fetched)
 }
 
+func (s *localRepoStore) UpdateReferences(
+	repo *model.Repository,
+	updated []*model.Reference,
+	deleted []string,
+	changes []*ReferenceChange,
+) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.repos[repo.ID]; !ok {
+		return kallax.ErrNotFound
+	}
+
+	s.updateReferences(repo.ID, updated, deleted)
+	s.history[repo.ID] = append(s.history[repo.ID], changes...)
+	return nil
+}
+
+func (s *localRepoStore) updateReferences(id kallax.ULID, updated []*model.Reference, deleted []string) {
+	refs, ok := s.refs[id]
+	if !ok {
+		refs = make(map[string]*model.Reference)
+		s.refs[id] = refs
+	}
+
+	for _, name := range deleted {
+		delete(refs, name)
+	}
+
+	for _, ref := range updated {
+		refs[ref.Name] = ref
+	}
+}
+
+// toRepo returns the repository with its references and the time it was
+// updated.
+func (s *localRepoStore) toRepo(r *localRepo) *model.Repository {
+	repo := r.toRepo()
+	repo.UpdatedAt = s.updated[r.ID]
+	names := make([]string, 0, len(s.refs[r.ID]))
+	for name := range s.refs[r.ID] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		repo.References = append(repo.References, s.refs[r.ID][name])
+	}
+
+	return repo
+}
+
+func (s *localRepoStore) SetHead(repo *model.Repository, head string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.repos[repo.ID]; !ok {
+		return kallax.ErrNotFound
+	}
+
+	if head == "" {
+		delete(s.heads, repo.ID)
+	} else {
+		s.heads[repo.ID] = head
+	}
+
+	return nil
+}
+
+func (s *localRepoStore) Head(id kallax.ULID) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.repos[id]; !ok {
+		return "", kallax.ErrNotFound
+	}
+
+	return s.heads[id], nil
+}
+
+func (s *localRepoStore) SetShallow(repo *model.Repository, shallow *Shallow) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.repos[repo.ID]; !ok {
+		return kallax.ErrNotFound
+	}
+
+	if shallow == nil {
+		delete(s.shallows, repo.ID)
+	} else {
+		s.shallows[repo.ID] = shallow
+	}
+
+	return nil
+}
+
+func (s *localRepoStore) Shallow(id kallax.ULID) (*Shallow, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.repos[id]; !ok {
+		return nil, kallax.ErrNotFound
+	}
+
+	return s.shallows[id], nil
+}
+
+func (s *localRepoStore) SetPendingCommit(repo *model.Repository, inits []model.SHA1) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.repos[repo.ID]; !ok {
+		return kallax.ErrNotFound
+	}
+
+	if len(inits) == 0 {
+		delete(s.pending, repo.ID)
+	} else {
+		s.pending[repo.ID] = inits
+	}
+
+	return nil
+}
+
+func (s *localRepoStore) PendingCommit(id kallax.ULID) ([]model.SHA1, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.repos[id]; !ok {
+		return nil, kallax.ErrNotFound
+	}
+
+	return s.pending[id], nil
+}
+
+func (s *localRepoStore) ReferenceHistory(id kallax.ULID, name string) ([]*ReferenceChange, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.repos[id]; !ok {
+		return nil, kallax.ErrNotFound
+	}
+
+	var changes []*ReferenceChange
+	for _, c := range s.history[id] {
+		if name == "" || c.Name == name {
+			changes = append(changes, c)
+		}
+	}
+
+	return changes, nil
+}
+
+func (s *localRepoStore) AddDeletedReferences(repo *model.Repository, refs []*DeletedReference) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.repos[repo.ID]; !ok {
+		return kallax.ErrNotFound
+	}
+
+	s.deleted[repo.ID] = append(s.deleted[repo.ID], refs...)
+	return nil
+}
+
+func (s *localRepoStore) DeletedReferences(id kallax.ULID) ([]*DeletedReference, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.repos[id]; !ok {
+		return nil, kallax.ErrNotFound
+	}
+
+	return append([]*DeletedReference(nil), s.deleted[id]...), nil
+}
+
+func (s *localRepoStore) ExpiredDeletedReferences(before time.Time) ([]*DeletedReference, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var refs []*DeletedReference
+	for _, deleted := range s.deleted {
+		for _, ref := range deleted {
+			if ref.DeletedAt.Before(before) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	sort.SliceStable(refs, func(i, j int) bool {
+		return refs[i].DeletedAt.Before(refs[j].DeletedAt)
+	})
+
+	return refs, nil
+}
+
+func (s *localRepoStore) RemoveDeletedReferences(refs []*DeletedReference) error {
+	s.Lock()
+	defer s.Unlock()
+
+	removed := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		removed[ref.RepositoryID.String()+ref.Tombstone] = true
+	}
+
+	for id, deleted := range s.deleted {
+		var kept []*DeletedReference
+		for _, ref := range deleted {
+			if !removed[id.String()+ref.Tombstone] {
+				kept = append(kept, ref)
+			}
+		}
+
+		s.deleted[id] = kept
+	}
+
+	return nil
+}
+
+func (s *localRepoStore) Delete(repo *model.Repository) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.repos[repo.ID]; !ok {
+		return kallax.ErrNotFound
+	}
+
+	delete(s.repos, repo.ID)
+	delete(s.heads, repo.ID)
+	delete(s.shallows, repo.ID)
+	delete(s.pending, repo.ID)
+	delete(s.refs, repo.ID)
+	delete(s.history, repo.ID)
+	delete(s.deleted, repo.ID)
+	delete(s.updated, repo.ID)
+	return nil
+}
+
 func containsString(slice []string, str string) bool {
 	for _, s := range slice {
 		if s == str {
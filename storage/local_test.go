@@ -70,6 +70,25 @@ func (s *LocalSuite) TestGetByEndpoints() {
 	require.NoError(err)
 }
 
+func (s *LocalSuite) TestGetByStatus() {
+	require := s.Require()
+	for _, r := range []*localRepo{
+		{ID: kallax.NewULID(), Endpoint: "foo", Status: model.Pending},
+		{ID: kallax.NewULID(), Endpoint: "bar", Status: model.Fetching},
+	} {
+		s.store.repos[r.ID] = r
+	}
+
+	result, err := s.store.GetByStatus(model.Fetching)
+	require.NoError(err)
+	require.Len(result, 1)
+	require.Equal("bar", result[0].Endpoints[0])
+
+	result, err = s.store.GetByStatus(model.NotFound)
+	require.NoError(err)
+	require.Len(result, 0)
+}
+
 func (s *LocalSuite) TestSetStatus() {
 	require := s.Require()
 	repo := &localRepo{
@@ -141,6 +160,210 @@ func (s *LocalSuite) TestUpdateFetched() {
 	require.Equal(model.Fetched, s.store.repos[repo.ID].Status)
 }
 
+func (s *LocalSuite) TestSetHead() {
+	require := s.Require()
+	repo := &localRepo{
+		ID:       kallax.NewULID(),
+		Endpoint: "foo",
+		Status:   model.Fetched,
+	}
+	s.store.repos[repo.ID] = repo
+
+	head, err := s.store.Head(repo.ID)
+	require.NoError(err)
+	require.Equal("", head)
+
+	err = s.store.SetHead(repo.toRepo(), "refs/heads/main")
+	require.NoError(err)
+
+	head, err = s.store.Head(repo.ID)
+	require.NoError(err)
+	require.Equal("refs/heads/main", head)
+
+	_, err = s.store.Head(kallax.NewULID())
+	require.Equal(kallax.ErrNotFound, err)
+}
+
+func (s *LocalSuite) TestSetShallow() {
+	require := s.Require()
+	repo := &localRepo{
+		ID:       kallax.NewULID(),
+		Endpoint: "foo",
+		Status:   model.Fetched,
+	}
+	s.store.repos[repo.ID] = repo
+
+	shallow, err := s.store.Shallow(repo.ID)
+	require.NoError(err)
+	require.Nil(shallow)
+
+	expected := &Shallow{Depth: 1}
+	require.NoError(s.store.SetShallow(repo.toRepo(), expected))
+
+	shallow, err = s.store.Shallow(repo.ID)
+	require.NoError(err)
+	require.Equal(expected, shallow)
+
+	require.NoError(s.store.SetShallow(repo.toRepo(), nil))
+
+	shallow, err = s.store.Shallow(repo.ID)
+	require.NoError(err)
+	require.Nil(shallow)
+}
+
+func (s *LocalSuite) TestSetPendingCommit() {
+	require := s.Require()
+	repo := &localRepo{
+		ID:       kallax.NewULID(),
+		Endpoint: "foo",
+		Status:   model.Fetched,
+	}
+	s.store.repos[repo.ID] = repo
+
+	inits, err := s.store.PendingCommit(repo.ID)
+	require.NoError(err)
+	require.Len(inits, 0)
+
+	expected := []model.SHA1{model.NewSHA1("0000000000000000000000000000000000000001")}
+	require.NoError(s.store.SetPendingCommit(repo.toRepo(), expected))
+
+	inits, err = s.store.PendingCommit(repo.ID)
+	require.NoError(err)
+	require.Equal(expected, inits)
+
+	require.NoError(s.store.SetPendingCommit(repo.toRepo(), nil))
+
+	inits, err = s.store.PendingCommit(repo.ID)
+	require.NoError(err)
+	require.Len(inits, 0)
+}
+
+func (s *LocalSuite) TestUpdateReferences() {
+	require := s.Require()
+	repo := &localRepo{
+		ID:       kallax.NewULID(),
+		Endpoint: "foo",
+		Status:   model.Fetched,
+	}
+	s.store.repos[repo.ID] = repo
+
+	master := &model.Reference{Name: "refs/heads/master"}
+	old := &model.Reference{Name: "refs/heads/old"}
+	require.NoError(s.store.UpdateReferences(repo.toRepo(), []*model.Reference{master, old}, nil, nil))
+
+	updated := &model.Reference{
+		Name: "refs/heads/master",
+		Hash: model.NewSHA1("0000000000000000000000000000000000000001"),
+	}
+	tag := &model.Reference{Name: "refs/tags/v1"}
+	err := s.store.UpdateReferences(repo.toRepo(),
+		[]*model.Reference{updated, tag}, []string{"refs/heads/old"}, nil)
+	require.NoError(err)
+
+	r, err := s.store.Get(repo.ID)
+	require.NoError(err)
+	require.Equal([]*model.Reference{updated, tag}, r.References)
+
+	err = s.store.UpdateReferences(&model.Repository{ID: kallax.NewULID()}, nil, nil, nil)
+	require.Equal(kallax.ErrNotFound, err)
+}
+
+func (s *LocalSuite) TestReferenceHistory() {
+	require := s.Require()
+	repo := &localRepo{
+		ID:       kallax.NewULID(),
+		Endpoint: "foo",
+		Status:   model.Fetched,
+	}
+	s.store.repos[repo.ID] = repo
+
+	master := &ReferenceChange{Name: "refs/heads/master", Action: "create"}
+	tag := &ReferenceChange{Name: "refs/tags/v1", Action: "create"}
+	forced := &ReferenceChange{Name: "refs/heads/master", Action: "update", Forced: true}
+	require.NoError(s.store.UpdateReferences(repo.toRepo(), nil, nil, []*ReferenceChange{master, tag}))
+	require.NoError(s.store.UpdateReferences(repo.toRepo(), nil, nil, []*ReferenceChange{forced}))
+
+	changes, err := s.store.ReferenceHistory(repo.ID, "refs/heads/master")
+	require.NoError(err)
+	require.Equal([]*ReferenceChange{master, forced}, changes)
+
+	changes, err = s.store.ReferenceHistory(repo.ID, "")
+	require.NoError(err)
+	require.Equal([]*ReferenceChange{master, tag, forced}, changes)
+
+	_, err = s.store.ReferenceHistory(kallax.NewULID(), "")
+	require.Equal(kallax.ErrNotFound, err)
+}
+
+func (s *LocalSuite) TestDeletedReferences() {
+	require := s.Require()
+	repo := &localRepo{
+		ID:       kallax.NewULID(),
+		Endpoint: "foo",
+		Status:   model.Fetched,
+	}
+	s.store.repos[repo.ID] = repo
+
+	now := time.Now()
+	old := &DeletedReference{
+		RepositoryID: repo.ID,
+		Name:         "refs/heads/old",
+		Tombstone:    "refs/borges/deleted/old",
+		DeletedAt:    now.Add(-time.Hour),
+	}
+	recent := &DeletedReference{
+		RepositoryID: repo.ID,
+		Name:         "refs/heads/recent",
+		Tombstone:    "refs/borges/deleted/recent",
+		DeletedAt:    now,
+	}
+	require.NoError(s.store.AddDeletedReferences(repo.toRepo(), []*DeletedReference{old, recent}))
+
+	refs, err := s.store.DeletedReferences(repo.ID)
+	require.NoError(err)
+	require.Equal([]*DeletedReference{old, recent}, refs)
+
+	refs, err = s.store.ExpiredDeletedReferences(now.Add(-time.Minute))
+	require.NoError(err)
+	require.Equal([]*DeletedReference{old}, refs)
+
+	require.NoError(s.store.RemoveDeletedReferences(refs))
+
+	refs, err = s.store.DeletedReferences(repo.ID)
+	require.NoError(err)
+	require.Equal([]*DeletedReference{recent}, refs)
+}
+
+func (s *LocalSuite) TestDelete() {
+	require := s.Require()
+	repo := &localRepo{
+		ID:       kallax.NewULID(),
+		Endpoint: "foo",
+		Status:   model.Fetched,
+	}
+	s.store.repos[repo.ID] = repo
+	require.NoError(s.store.SetHead(repo.toRepo(), "refs/heads/main"))
+	require.NoError(s.store.SetShallow(repo.toRepo(), &Shallow{Depth: 1}))
+	require.NoError(s.store.SetPendingCommit(repo.toRepo(), []model.SHA1{model.SHA1{}}))
+	require.NoError(s.store.UpdateReferences(repo.toRepo(),
+		[]*model.Reference{{Name: "refs/heads/master"}}, nil,
+		[]*ReferenceChange{{Name: "refs/heads/master"}}))
+	require.NoError(s.store.AddDeletedReferences(repo.toRepo(), []*DeletedReference{{Name: "refs/heads/old"}}))
+
+	require.NoError(s.store.Delete(repo.toRepo()))
+
+	_, err := s.store.Get(repo.ID)
+	require.Equal(kallax.ErrNotFound, err)
+	require.Empty(s.store.heads)
+	require.Empty(s.store.shallows)
+	require.Empty(s.store.pending)
+	require.Empty(s.store.refs)
+	require.Empty(s.store.history)
+	require.Empty(s.store.deleted)
+
+	require.Equal(kallax.ErrNotFound, s.store.Delete(repo.toRepo()))
+}
+
 func TestLocal(t *testing.T) {
 	suite.Run(t, new(LocalSuite))
 }
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	kallax "gopkg.in/src-d/go-kallax.v1"
+)
+
+// loadReferences sets the references of the given repositories, replacing
+// the ones read from the repositories table.
+func (s *dbRepoStore) loadReferences(repos ...*model.Repository) error {
+	if len(repos) == 0 {
+		return nil
+	}
+
+	byID := make(map[kallax.ULID]*model.Repository, len(repos))
+	ids := make([]string, len(repos))
+	for i, r := range repos {
+		r.References = nil
+		byID[r.ID] = r
+		ids[i] = r.ID.String()
+	}
+
+	rows, err := s.db.Query(
+		`SELECT repository_id, name, hash, init, roots, time, created_at, updated_at
+		FROM repository_references WHERE repository_id = ANY($1::uuid[])
+		ORDER BY repository_id, name`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id         kallax.ULID
+			ref        model.Reference
+			hash, init string
+			roots      []string
+			t          *time.Time
+		)
+
+		err := rows.Scan(
+			&id, &ref.Name, &hash, &init, pq.Array(&roots), &t,
+			&ref.CreatedAt, &ref.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		ref.Hash = model.NewSHA1(hash)
+		ref.Init = model.NewSHA1(init)
+		for _, root := range roots {
+			ref.Roots = append(ref.Roots, model.NewSHA1(root))
+		}
+
+		if t != nil {
+			ref.Time = *t
+		}
+
+		r := byID[id]
+		r.References = append(r.References, &ref)
+	}
+
+	return rows.Err()
+}
+
+func (s *dbRepoStore) UpdateReferences(
+	repo *model.Repository,
+	updated []*model.Reference,
+	deleted []string,
+	changes []*ReferenceChange,
+) (err error) {
+	if len(updated) == 0 && len(deleted) == 0 && len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	if err = writeReferences(txExec(tx), repo, updated, deleted); err != nil {
+		return err
+	}
+
+	return writeReferenceChanges(txExec(tx), repo, changes)
+}
+
+// execFunc executes a statement with the given arguments.
+type execFunc func(query string, args ...interface{}) error
+
+func txExec(tx *sql.Tx) execFunc {
+	return func(query string, args ...interface{}) error {
+		_, err := tx.Exec(query, args...)
+		return err
+	}
+}
+
+func kallaxExec(store *kallax.Store) execFunc {
+	return func(query string, args ...interface{}) error {
+		_, err := store.RawExec(query, args...)
+		return err
+	}
+}
+
+// writeReferences stores the given references of the repository and removes
+// the ones with the given names.
+func writeReferences(
+	exec execFunc,
+	repo *model.Repository,
+	updated []*model.Reference,
+	deleted []string,
+) error {
+	for _, name := range deleted {
+		err := exec(
+			`DELETE FROM repository_references WHERE repository_id = $1 AND name = $2`,
+			repo.ID, name,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, ref := range updated {
+		if ref.CreatedAt.IsZero() {
+			ref.CreatedAt = now
+		}
+
+		ref.UpdatedAt = now
+		roots := make([]string, len(ref.Roots))
+		for i, root := range ref.Roots {
+			roots[i] = root.String()
+		}
+
+		var t *time.Time
+		if !ref.Time.IsZero() {
+			t = &ref.Time
+		}
+
+		err := exec(
+			`INSERT INTO repository_references
+			(repository_id, name, hash, init, roots, time, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (repository_id, name) DO UPDATE SET
+			hash = EXCLUDED.hash, init = EXCLUDED.init, roots = EXCLUDED.roots,
+			time = EXCLUDED.time, updated_at = EXCLUDED.updated_at`,
+			repo.ID, ref.Name, ref.Hash.String(), ref.Init.String(),
+			pq.Array(roots), t, ref.CreatedAt, ref.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateReferences copies the references of the repositories from the
+// references column of the repositories table, where they were stored by
+// previous versions, to the repository_references table. The column is left
+// untouched. Repositories that already have references in the table, such
+// as the ones archived by the current version, are skipped, and the rest are
+// copied in a single transaction, so it can be run any number of times. It
+// returns the number of repositories whose references were copied.
+func MigrateReferences(db *sql.DB) (n int, err error) {
+	rs, err := model.NewRepositoryStore(db).Find(model.NewRepositoryQuery())
+	if err != nil {
+		return 0, err
+	}
+	defer rs.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			n = 0
+			return
+		}
+
+		err = tx.Commit()
+	}()
+
+	for rs.Next() {
+		r, err := rs.Get()
+		if err != nil {
+			return n, err
+		}
+
+		if len(r.References) == 0 {
+			continue
+		}
+
+		var migrated bool
+		err = tx.QueryRow(
+			`SELECT EXISTS (SELECT 1 FROM repository_references WHERE repository_id = $1)`,
+			r.ID,
+		).Scan(&migrated)
+		if err != nil {
+			return n, err
+		}
+
+		if migrated {
+			continue
+		}
+
+		if err := writeReferences(txExec(tx), r, r.References, nil); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
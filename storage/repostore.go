@@ -16,7 +16,10 @@ type RepoStore interface {
 	// GetByEndpoints returns the Repositories that have common endpoints with the
 	// list of endpoints passed.
 	GetByEndpoints(endpoints ...string) ([]*model.Repository, error)
-	// SetStatus changes the status of the given repository.
+	// GetByStatus returns all the Repositories with the given status.
+	GetByStatus(status model.FetchStatus) ([]*model.Repository, error)
+	// SetStatus changes the status of the given repository, and the time
+	// it was updated, so it is known when its fetch started.
 	SetStatus(repo *model.Repository, status model.FetchStatus) error
 	// SetEndpoints updates the endpoints of the repository.
 	SetEndpoints(repo *model.Repository, endpoints ...string) error
@@ -25,12 +28,114 @@ type RepoStore interface {
 	// other than setting its status, all the modification to the repo
 	// fields must be done before calling this method. That is, changing
 	// FetchErrorAt and so on should be done manually before. Refer to the
-	// concrete implementation to know what is being updated.
+	// concrete implementation to know what is being updated. References
+	// are not updated, see UpdateReferences.
 	UpdateFailed(repo *model.Repository, status model.FetchStatus) error
 	// Update updates the given repository as successfully fetched.
 	// No modifications are performed to the repository other than setting
 	// the Fetched status and the time when it was fetched, all other changes
 	// should be done to the repo before calling this method. Refer to the
-	// concrete implementation to know what is being updated.
+	// concrete implementation to know what is being updated. References
+	// are not updated, see UpdateReferences.
 	UpdateFetched(repo *model.Repository, fetchedAt time.Time) error
+	// UpdateReferences stores the given references of the repository,
+	// replacing the ones with the same names, removes the references with
+	// the given names and appends the given changes to the history of its
+	// references, all of it at once. The rest of its references are left
+	// untouched, and the repository itself is not modified.
+	UpdateReferences(
+		repo *model.Repository,
+		updated []*model.Reference,
+		deleted []string,
+		changes []*ReferenceChange,
+	) error
+	// SetHead stores the name of the reference HEAD points to in the remote
+	// repository, such as refs/heads/master. An empty name removes it.
+	SetHead(repo *model.Repository, head string) error
+	// Head returns the name of the reference HEAD points to in the remote
+	// repository with the given ID, or an empty name if it is not known.
+	Head(id kallax.ULID) (string, error)
+	// SetShallow records that the repository is archived with a limited
+	// history. A nil Shallow records that its whole history is archived.
+	SetShallow(repo *model.Repository, shallow *Shallow) error
+	// Shallow returns how the history of the repository with the given ID is
+	// limited, or nil if its whole history is archived.
+	Shallow(id kallax.ULID) (*Shallow, error)
+	// SetPendingCommit records the init commits of the rooted repositories
+	// where the changes of the repository are about to be committed, until
+	// the repository is updated with them. An empty list removes them.
+	SetPendingCommit(repo *model.Repository, inits []model.SHA1) error
+	// PendingCommit returns the init commits recorded by SetPendingCommit for
+	// the repository with the given ID.
+	PendingCommit(id kallax.ULID) ([]model.SHA1, error)
+	// ReferenceHistory returns the changes of the reference with the given
+	// name of the repository with the given ID, oldest first. An empty name
+	// returns the changes of all its references.
+	ReferenceHistory(id kallax.ULID, name string) ([]*ReferenceChange, error)
+	// AddDeletedReferences records the given references as deleted from
+	// the repository and kept in their tombstones.
+	AddDeletedReferences(repo *model.Repository, refs []*DeletedReference) error
+	// DeletedReferences returns the deleted references of the repository
+	// with the given ID, oldest first.
+	DeletedReferences(id kallax.ULID) ([]*DeletedReference, error)
+	// ExpiredDeletedReferences returns the deleted references of all the
+	// repositories deleted before the given time, oldest first.
+	ExpiredDeletedReferences(before time.Time) ([]*DeletedReference, error)
+	// RemoveDeletedReferences removes the given deleted references, once
+	// their tombstones are dropped.
+	RemoveDeletedReferences(refs []*DeletedReference) error
+	// Delete removes the repository and everything stored about it.
+	Delete(repo *model.Repository) error
+}
+
+// Shallow describes a repository archived with a limited history.
+type Shallow struct {
+	// Depth is the number of commits fetched from the tip of each
+	// reference, or 0 if it is not limited.
+	Depth int
+	// Since is the date of the oldest commits archived, or zero if it is
+	// not limited.
+	Since time.Time
+	// Commits are the shallow commits, whose parents are not archived.
+	Commits []model.SHA1
+}
+
+// ReferenceChange is a change of a reference of a repository made by a job,
+// as kept in the history of its references.
+type ReferenceChange struct {
+	// Name is the name of the reference.
+	Name string
+	// Action is either create, update or delete.
+	Action string
+	// OldHash and OldInit are the commit and init commit the reference
+	// pointed to before the change. They are zero if it was created.
+	OldHash, OldInit model.SHA1
+	// NewHash and NewInit are the commit and init commit the reference
+	// points to after the change. They are zero if it was deleted.
+	NewHash, NewInit model.SHA1
+	// Forced is whether the reference was updated to a commit that does
+	// not descend from the previous one, that is, a non-fast-forward update.
+	Forced bool
+	// Job is the ID of the job that made the change, if it is known.
+	Job string
+	// Time is when the change was made.
+	Time time.Time
+}
+
+// DeletedReference is a reference deleted from a repository that is kept in
+// a tombstone, a reference of the rooted repository where it was archived.
+type DeletedReference struct {
+	// RepositoryID is the ID of the repository the reference was deleted
+	// from.
+	RepositoryID kallax.ULID
+	// Name is the name of the reference.
+	Name string
+	// Hash and Init are the commit and init commit the reference pointed to
+	// when it was deleted.
+	Hash, Init model.SHA1
+	// Tombstone is the name of the reference keeping it in the rooted
+	// repository of its init commit.
+	Tombstone string
+	// DeletedAt is when the reference was deleted.
+	DeletedAt time.Time
 }
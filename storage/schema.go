@@ -0,0 +1,94 @@
+package storage
+
+import "database/sql"
+
+// schema are the statements creating the tables used by borges that are not
+// part of the core-retrieval schema.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS repository_heads (
+		repository_id uuid PRIMARY KEY,
+		head text NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS repository_shallows (
+		repository_id uuid PRIMARY KEY,
+		depth integer NOT NULL,
+		since timestamptz
+	)`,
+	`CREATE TABLE IF NOT EXISTS repository_shallow_commits (
+		repository_id uuid NOT NULL,
+		hash char(40) NOT NULL,
+		PRIMARY KEY (repository_id, hash)
+	)`,
+	`CREATE TABLE IF NOT EXISTS repository_pending_commits (
+		repository_id uuid NOT NULL,
+		init char(40) NOT NULL,
+		PRIMARY KEY (repository_id, init)
+	)`,
+	`CREATE TABLE IF NOT EXISTS repository_references (
+		repository_id uuid NOT NULL,
+		name text NOT NULL,
+		hash char(40) NOT NULL,
+		init char(40) NOT NULL,
+		roots text[] NOT NULL,
+		time timestamptz,
+		created_at timestamptz NOT NULL,
+		updated_at timestamptz NOT NULL,
+		PRIMARY KEY (repository_id, name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS repository_reference_history (
+		id bigserial PRIMARY KEY,
+		repository_id uuid NOT NULL,
+		name text NOT NULL,
+		action text NOT NULL,
+		old_hash char(40),
+		old_init char(40),
+		new_hash char(40),
+		new_init char(40),
+		forced boolean NOT NULL,
+		job_id text,
+		time timestamptz NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS repository_reference_history_name
+		ON repository_reference_history (repository_id, name)`,
+	`CREATE TABLE IF NOT EXISTS repository_deleted_references (
+		repository_id uuid NOT NULL,
+		tombstone text NOT NULL,
+		name text NOT NULL,
+		hash char(40) NOT NULL,
+		init char(40) NOT NULL,
+		deleted_at timestamptz NOT NULL,
+		PRIMARY KEY (repository_id, tombstone)
+	)`,
+}
+
+var dropSchema = []string{
+	`DROP TABLE IF EXISTS repository_heads`,
+	`DROP TABLE IF EXISTS repository_shallows`,
+	`DROP TABLE IF EXISTS repository_shallow_commits`,
+	`DROP TABLE IF EXISTS repository_pending_commits`,
+	`DROP TABLE IF EXISTS repository_references`,
+	`DROP TABLE IF EXISTS repository_reference_history`,
+	`DROP TABLE IF EXISTS repository_deleted_references`,
+}
+
+// CreateSchema creates the tables used by borges in the given database. The
+// core-retrieval schema must be created before. Tables that already exist are
+// left untouched.
+func CreateSchema(db *sql.DB) error {
+	return execAll(db, schema)
+}
+
+// DropSchema drops the tables created by CreateSchema.
+func DropSchema(db *sql.DB) error {
+	return execAll(db, dropSchema)
+}
+
+func execAll(db *sql.DB, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package borges
+
+import (
+	"fmt"
+
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/core-retrieval.v0/repository"
+	"gopkg.in/src-d/framework.v0/lock"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+// RefInconsistency is a reference of a repository that does not match the
+// rooted repository of its init commit.
+type RefInconsistency struct {
+	// Reference is the reference as stored in the repository model.
+	Reference *model.Reference
+	// Hash is the hash of the reference in the rooted repository. It is zero
+	// if the reference could not be found there.
+	Hash model.SHA1
+	// Err is the error found walking the objects reachable from the
+	// reference in the rooted repository, if they are not all there.
+	Err error
+}
+
+// VerifyReferences checks that every reference of the given repository is in
+// the rooted repository of its init commit, pointing to the hash stored in the
+// repository model, and that all the objects reachable from it are there. The
+// rooted repositories are not modified. It returns the references that do not
+// match.
+func VerifyReferences(tx repository.RootedTransactioner, r *model.Repository) ([]*RefInconsistency, error) {
+	inits, byInit := referencesByInit(r.References)
+
+	var incs []*RefInconsistency
+	for _, init := range inits {
+		i, err := verifyReferences(tx, r, init, byInit[init])
+		if err != nil {
+			return nil, err
+		}
+
+		incs = append(incs, i...)
+	}
+
+	return incs, nil
+}
+
+func verifyReferences(
+	tx repository.RootedTransactioner,
+	r *model.Repository,
+	init model.SHA1,
+	refs []*model.Reference,
+) (incs []*RefInconsistency, err error) {
+	t, err := tx.Begin(plumbing.Hash(init))
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if rErr := t.Rollback(); rErr != nil && err == nil {
+			err = rErr
+		}
+	}()
+
+	s := t.Storer()
+	shallow, err := shallowCommits(s)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[plumbing.Hash]bool)
+	for _, ref := range refs {
+		name := plumbing.ReferenceName(fmt.Sprintf("%s/%s", ref.Name, r.ID))
+		stored, err := s.Reference(name)
+		if err == plumbing.ErrReferenceNotFound {
+			incs = append(incs, &RefInconsistency{Reference: ref})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		hash := model.SHA1(stored.Hash())
+		if hash != ref.Hash {
+			incs = append(incs, &RefInconsistency{Reference: ref, Hash: hash})
+			continue
+		}
+
+		err = markReachable(s, []plumbing.Hash{stored.Hash()}, shallow, seen)
+		if ErrObjectNotFound.Is(err) {
+			incs = append(incs, &RefInconsistency{
+				Reference: ref,
+				Hash:      hash,
+				Err:       err,
+			})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return incs, nil
+}
+
+// RepairReferences removes the references of the given inconsistencies from
+// the rooted repositories of their init commits and from the repository, so
+// they are archived again the next time the repository is fetched. The
+// repository is not updated in the store.
+func RepairReferences(
+	tx repository.RootedTransactioner,
+	ls lock.Session,
+	r *model.Repository,
+	incs []*RefInconsistency,
+) error {
+	refs := make([]*model.Reference, len(incs))
+	for i, inc := range incs {
+		refs[i] = inc.Reference
+	}
+
+	inits, byInit := referencesByInit(refs)
+	for _, init := range inits {
+		err := withRootedRepository(tx, ls, init, func(s storage.Storer) (bool, error) {
+			for _, ref := range byInit[init] {
+				name := plumbing.ReferenceName(fmt.Sprintf("%s/%s", ref.Name, r.ID))
+				if err := s.RemoveReference(name); err != nil {
+					return false, err
+				}
+			}
+
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	removed := make(map[*model.Reference]bool, len(refs))
+	for _, ref := range refs {
+		removed[ref] = true
+	}
+
+	var kept []*model.Reference
+	for _, ref := range r.References {
+		if !removed[ref] {
+			kept = append(kept, ref)
+		}
+	}
+
+	r.References = kept
+	return nil
+}
+
+// referencesByInit groups the given references by init commit. It also
+// returns the init commits in the order they are found.
+func referencesByInit(refs []*model.Reference) ([]model.SHA1, map[model.SHA1][]*model.Reference) {
+	var inits []model.SHA1
+	byInit := make(map[model.SHA1][]*model.Reference)
+	for _, ref := range refs {
+		if _, ok := byInit[ref.Init]; !ok {
+			inits = append(inits, ref.Init)
+		}
+
+		byInit[ref.Init] = append(byInit[ref.Init], ref)
+	}
+
+	return inits, byInit
+}
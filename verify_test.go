@@ -0,0 +1,64 @@
+package borges
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/core-retrieval.v0/model"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestVerifyReferences(t *testing.T) {
+	require := require.New(t)
+
+	h := newSyntheticHistory()
+	a := h.commit()
+	b := h.commit(a)
+	missing := plumbing.NewHash("0000000000000000000000000000000000000001")
+	broken := h.commit(missing)
+
+	r := model.NewRepository()
+	for name, hash := range map[string]plumbing.Hash{
+		"refs/heads/ok":     b,
+		"refs/heads/moved":  a,
+		"refs/heads/broken": broken,
+	} {
+		ref := plumbing.NewHashReference(
+			plumbing.ReferenceName(fmt.Sprintf("%s/%s", name, r.ID)),
+			hash,
+		)
+		require.NoError(h.storage.SetReference(ref))
+	}
+
+	init := model.SHA1(a)
+	refs := []*model.Reference{
+		{Name: "refs/heads/ok", Hash: model.SHA1(b), Init: init},
+		{Name: "refs/heads/moved", Hash: model.SHA1(b), Init: init},
+		{Name: "refs/heads/broken", Hash: model.SHA1(broken), Init: init},
+		{Name: "refs/heads/gone", Hash: model.SHA1(b), Init: init},
+	}
+	r.References = refs
+
+	tx := memoryTransactioner{plumbing.Hash(init): h.storage}
+	incs, err := VerifyReferences(tx, r)
+	require.NoError(err)
+	require.Len(incs, 3)
+
+	require.Equal(refs[1], incs[0].Reference)
+	require.Equal(model.SHA1(a), incs[0].Hash)
+	require.NoError(incs[0].Err)
+
+	require.Equal(refs[2], incs[1].Reference)
+	require.Equal(model.SHA1(broken), incs[1].Hash)
+	require.True(ErrObjectNotFound.Is(incs[1].Err))
+
+	require.Equal(refs[3], incs[2].Reference)
+	require.True(incs[2].Hash.IsZero())
+	require.NoError(incs[2].Err)
+
+	r.References = refs[:1]
+	incs, err = VerifyReferences(tx, r)
+	require.NoError(err)
+	require.Len(incs, 0)
+}
@@ -0,0 +1,271 @@
+package borges
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+	"github.com/src-d/borges/storage"
+	"gopkg.in/src-d/go-errors.v0"
+)
+
+var (
+	ErrInvalidSignature = errors.NewKind("invalid webhook signature")
+	ErrNoEndpoints      = errors.NewKind("no endpoints found in the webhook payload")
+)
+
+// maxWebhookPayload is the maximum size of a webhook request body.
+const maxWebhookPayload = 25 << 20
+
+// WebhookOptions are the options of the webhook receiver.
+type WebhookOptions struct {
+	// Secret is the secret configured in the webhooks. It is used to verify
+	// the HMAC signature of GitHub and Gitea requests and it is compared
+	// with the token of GitLab requests. If it is empty, requests are not
+	// verified.
+	Secret string
+	// Debounce is the time to wait after the last push event of a
+	// repository before its job is returned.
+	Debounce time.Duration
+}
+
+// WebhookJobIter is a JobIter that returns jobs for the repositories pushed
+// to, as received from GitHub, GitLab or Gitea push webhooks. It must be
+// served as an http.Handler.
+type WebhookJobIter struct {
+	storer storage.RepoStore
+	opts   WebhookOptions
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]*time.Timer
+	jobs    chan *Job
+	done    chan struct{}
+	closed  bool
+}
+
+// NewWebhookJobIter returns a new WebhookJobIter. Bursts of events for the
+// same repository produce a single job, returned once no other event for
+// that repository is received in the debounce time.
+func NewWebhookJobIter(opts WebhookOptions, storer storage.RepoStore) *WebhookJobIter {
+	return &WebhookJobIter{
+		storer:  storer,
+		opts:    opts,
+		pending: make(map[uuid.UUID]*time.Timer),
+		jobs:    make(chan *Job),
+		done:    make(chan struct{}),
+	}
+}
+
+// Next returns the next job. It returns an error of kind ErrWaitForJobs if
+// there are no jobs ready and io.EOF after the iterator is closed.
+func (i *WebhookJobIter) Next() (*Job, error) {
+	select {
+	case j := <-i.jobs:
+		return j, nil
+	case <-i.done:
+		return nil, io.EOF
+	default:
+		return nil, ErrWaitForJobs.New()
+	}
+}
+
+// Close stops the iterator. Jobs still waiting for their debounce time are
+// discarded.
+func (i *WebhookJobIter) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		return nil
+	}
+
+	i.closed = true
+	for _, t := range i.pending {
+		t.Stop()
+	}
+
+	close(i.done)
+	return nil
+}
+
+// ServeHTTP handles a webhook request. Push events are accepted with a 202
+// status code, other events are ignored with a 204 status code.
+func (i *WebhookJobIter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	isPush, err := i.verify(r.Header, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if !isPush {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	endpoints, isFork, err := webhookEndpoints(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ID, err := RepositoryID(endpoints, isFork, i.storer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	i.schedule(ID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verify checks the signature of the request and returns whether it is a
+// push event. Gitea and Gogs are checked first as they also send GitHub
+// headers.
+func (i *WebhookJobIter) verify(h http.Header, body []byte) (bool, error) {
+	switch {
+	case h.Get("X-Gitea-Event") != "":
+		return h.Get("X-Gitea-Event") == "push",
+			i.verifyHMAC(sha256.New, h.Get("X-Gitea-Signature"), body)
+	case h.Get("X-Gogs-Event") != "":
+		return h.Get("X-Gogs-Event") == "push",
+			i.verifyHMAC(sha256.New, h.Get("X-Gogs-Signature"), body)
+	case h.Get("X-Gitlab-Event") != "":
+		event := h.Get("X-Gitlab-Event")
+		return event == "Push Hook" || event == "Tag Push Hook",
+			i.verifyToken(h.Get("X-Gitlab-Token"))
+	case h.Get("X-GitHub-Event") != "":
+		isPush := h.Get("X-GitHub-Event") == "push"
+		if sig := h.Get("X-Hub-Signature-256"); sig != "" {
+			return isPush, i.verifyHMAC(sha256.New, strings.TrimPrefix(sig, "sha256="), body)
+		}
+
+		return isPush, i.verifyHMAC(sha1.New, strings.TrimPrefix(h.Get("X-Hub-Signature"), "sha1="), body)
+	default:
+		return false, i.verifyToken("")
+	}
+}
+
+func (i *WebhookJobIter) verifyHMAC(h func() hash.Hash, signature string, body []byte) error {
+	if i.opts.Secret == "" {
+		return nil
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature.New()
+	}
+
+	mac := hmac.New(h, []byte(i.opts.Secret))
+	mac.Write(body)
+	if !hmac.Equal(expected, mac.Sum(nil)) {
+		return ErrInvalidSignature.New()
+	}
+
+	return nil
+}
+
+func (i *WebhookJobIter) verifyToken(token string) error {
+	if i.opts.Secret == "" {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(i.opts.Secret)) != 1 {
+		return ErrInvalidSignature.New()
+	}
+
+	return nil
+}
+
+// schedule returns a job for the repository after the debounce time, unless
+// it is scheduled again before.
+func (i *WebhookJobIter) schedule(ID uuid.UUID) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.closed {
+		return
+	}
+
+	if t, ok := i.pending[ID]; ok && t.Stop() {
+		t.Reset(i.opts.Debounce)
+		return
+	}
+
+	var t *time.Timer
+	t = time.AfterFunc(i.opts.Debounce, func() {
+		i.mu.Lock()
+		if i.pending[ID] == t {
+			delete(i.pending, ID)
+		}
+		i.mu.Unlock()
+
+		select {
+		case i.jobs <- &Job{RepositoryID: ID}:
+		case <-i.done:
+		}
+	})
+	i.pending[ID] = t
+}
+
+// webhookPayload contains the fields of GitHub, GitLab and Gitea push
+// payloads used to identify the repository.
+type webhookPayload struct {
+	Repository struct {
+		CloneURL   string `json:"clone_url"`
+		GitURL     string `json:"git_url"`
+		GitHTTPURL string `json:"git_http_url"`
+		Fork       *bool  `json:"fork"`
+	} `json:"repository"`
+	Project struct {
+		GitHTTPURL string `json:"git_http_url"`
+	} `json:"project"`
+}
+
+func webhookEndpoints(body []byte) ([]string, *bool, error) {
+	var p webhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, nil, err
+	}
+
+	var endpoints []string
+	seen := make(map[string]bool)
+	for _, e := range nonEmpty(
+		p.Repository.CloneURL,
+		p.Project.GitHTTPURL,
+		p.Repository.GitHTTPURL,
+		p.Repository.GitURL,
+	) {
+		if !seen[e] {
+			seen[e] = true
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	if len(endpoints) == 0 {
+		return nil, nil, ErrNoEndpoints.New()
+	}
+
+	return endpoints, p.Repository.Fork, nil
+}
@@ -0,0 +1,157 @@
+package borges
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/src-d/borges/storage"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/src-d/go-kallax.v1"
+)
+
+func TestWebhookJobIter(t *testing.T) {
+	suite.Run(t, new(WebhookJobIterSuite))
+}
+
+type WebhookJobIterSuite struct {
+	suite.Suite
+	store storage.RepoStore
+	iter  *WebhookJobIter
+}
+
+func (s *WebhookJobIterSuite) SetupTest() {
+	s.store = storage.Local()
+	s.iter = NewWebhookJobIter(WebhookOptions{
+		Secret:   "secret",
+		Debounce: 50 * time.Millisecond,
+	}, s.store)
+}
+
+func (s *WebhookJobIterSuite) TearDownTest() {
+	s.NoError(s.iter.Close())
+}
+
+func (s *WebhookJobIterSuite) TestGitHub() {
+	body := `{"repository": {"clone_url": "https://github.com/foo/bar.git", "fork": true}}`
+	s.post(http.StatusAccepted, body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "secret", body),
+	})
+	s.post(http.StatusAccepted, body, map[string]string{
+		"X-GitHub-Event":  "push",
+		"X-Hub-Signature": "sha1=" + sign(sha1.New, "secret", body),
+	})
+
+	j := s.next()
+	repo, err := s.store.Get(kallax.ULID(j.RepositoryID))
+	s.NoError(err)
+	s.Equal([]string{"https://github.com/foo/bar.git"}, repo.Endpoints)
+
+	s.noJob()
+}
+
+func (s *WebhookJobIterSuite) TestGitLab() {
+	body := `{"project": {"git_http_url": "https://gitlab.com/foo/bar.git"}}`
+	s.post(http.StatusUnauthorized, body, map[string]string{
+		"X-Gitlab-Event": "Push Hook",
+		"X-Gitlab-Token": "wrong",
+	})
+	s.post(http.StatusAccepted, body, map[string]string{
+		"X-Gitlab-Event": "Push Hook",
+		"X-Gitlab-Token": "secret",
+	})
+
+	s.next()
+	s.noJob()
+}
+
+func (s *WebhookJobIterSuite) TestGitea() {
+	body := `{"repository": {"clone_url": "https://try.gitea.io/foo/bar.git"}}`
+	headers := map[string]string{
+		"X-GitHub-Event":    "push",
+		"X-Gitea-Event":     "push",
+		"X-Gitea-Signature": sign(sha256.New, "secret", body),
+	}
+	s.post(http.StatusAccepted, body, headers)
+
+	headers["X-Gitea-Signature"] = sign(sha256.New, "wrong", body)
+	s.post(http.StatusUnauthorized, body, headers)
+
+	s.next()
+	s.noJob()
+}
+
+func (s *WebhookJobIterSuite) TestIgnoredEvents() {
+	body := `{"zen": "Keep it logically awesome."}`
+	s.post(http.StatusNoContent, body, map[string]string{
+		"X-GitHub-Event":      "ping",
+		"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "secret", body),
+	})
+
+	body = `{}`
+	s.post(http.StatusBadRequest, body, map[string]string{
+		"X-GitHub-Event":      "push",
+		"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "secret", body),
+	})
+
+	s.post(http.StatusUnauthorized, body, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	s.noJob()
+}
+
+func (s *WebhookJobIterSuite) TestClose() {
+	s.NoError(s.iter.Close())
+	_, err := s.iter.Next()
+	s.Equal(io.EOF, err)
+}
+
+func (s *WebhookJobIterSuite) post(status int, body string, headers map[string]string) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	s.iter.ServeHTTP(w, req)
+	s.Equal(status, w.Code, w.Body.String())
+}
+
+func (s *WebhookJobIterSuite) next() *Job {
+	require := s.Require()
+	timeout := time.After(time.Second)
+	for {
+		j, err := s.iter.Next()
+		if err == nil {
+			return j
+		}
+
+		require.True(ErrWaitForJobs.Is(err))
+
+		select {
+		case <-timeout:
+			require.FailNow("timeout waiting for a job")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *WebhookJobIterSuite) noJob() {
+	_, err := s.iter.Next()
+	s.True(ErrWaitForJobs.Is(err))
+}
+
+func sign(h func() hash.Hash, secret, body string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}